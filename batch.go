@@ -0,0 +1,75 @@
+package slip
+
+import "sync/atomic"
+
+// BeginBatch starts a batch of packets that are assembled one by one
+// with AddFrame and written by EndBatch with a single Write, like
+// WritePackets. The packets share one leading END, each is terminated
+// by its own. The mutex of s is held from BeginBatch to EndBatch, so
+// other writers wait for the batch; it must be ended by the same
+// goroutine. Batches do not nest: BeginBatch panics while a batch is
+// open on s, also one of another goroutine, so goroutines sharing s
+// must not start batches concurrently.
+func (s *Writer) BeginBatch() {
+	// checked before the lock, which the open batch holds
+	if atomic.LoadInt32(&s.inBatch) != 0 {
+		panic("slip: BeginBatch in a batch")
+	}
+	s.lock()
+	atomic.StoreInt32(&s.inBatch, 1)
+	s.batch = s.batch[:0]
+	s.bends = s.bends[:0]
+	s.bframes = s.bframes[:0]
+	if s.leadingEnd() {
+		s.batch = s.appendEnd(s.batch)
+	}
+}
+
+// AddFrame adds p to the batch started by BeginBatch. p is encoded
+// right away, but with WithWriteHook it is passed to the hook by
+// EndBatch and must not be modified before.
+func (s *Writer) AddFrame(p []byte) {
+	if atomic.LoadInt32(&s.inBatch) == 0 {
+		panic("slip: AddFrame outside of a batch")
+	}
+	s.batch = s.appendFrame(s.batch, p)
+	s.bends = append(s.bends, len(s.batch))
+	if s.hook != nil {
+		s.bframes = append(s.bframes, p)
+	}
+}
+
+// EndBatch writes the batch and releases s. A batch without frames
+// writes nothing. If the Write fails, a *BatchWriteError reports how
+// many packets were written completely; the batch is dropped either
+// way. Buffered writers add the batch to the buffer as a whole.
+func (s *Writer) EndBatch() error {
+	if atomic.LoadInt32(&s.inBatch) == 0 {
+		panic("slip: EndBatch outside of a batch")
+	}
+	defer s.unlock()
+	atomic.StoreInt32(&s.inBatch, 0)
+	err := s.endBatch()
+	if s.hook != nil {
+		s.batchHook(s.bframes, err)
+	}
+	s.batch = s.batch[:0]
+	s.bends = s.bends[:0]
+	s.bframes = s.bframes[:0]
+	return err
+}
+
+func (s *Writer) endBatch() error {
+	if s.closed {
+		return ErrClosed
+	}
+	if len(s.bends) == 0 {
+		return nil
+	}
+	if s.wsize > 0 {
+		n := len(s.wbuf)
+		s.wbuf = append(s.wbuf, s.batch...)
+		return s.buffered(n, len(s.bends))
+	}
+	return s.writeBatch(s.batch, s.bends)
+}
@@ -0,0 +1,105 @@
+package slip
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestBatch(t *testing.T) {
+	buf := &bytes.Buffer{}
+	cw := &countingWriter{w: buf}
+	w := NewWriter(cw)
+	w.BeginBatch()
+	w.AddFrame([]byte{1})
+	w.AddFrame([]byte{END, 2})
+	w.AddFrame(nil)
+	if err := w.EndBatch(); err != nil {
+		t.Error("Unexpected error:", err)
+	}
+	expected := []byte{END, 1, END, ESC, ESC_END, 2, END, END}
+	if cw.calls != 1 || !eqBytes(expected, buf.Bytes()) {
+		t.Error("Expected one write of", expected, "but got", cw.calls, buf.Bytes())
+	}
+	if s := w.Stats(); s.Packets != 3 {
+		t.Error("Expected 3 packets but got", s.Packets)
+	}
+
+	// An empty batch writes nothing
+	w.BeginBatch()
+	if err := w.EndBatch(); err != nil || cw.calls != 1 {
+		t.Error("Expected no write but got", cw.calls, err)
+	}
+
+	// The writer is usable after the batch
+	if err := w.WritePacket([]byte{3}); err != nil {
+		t.Error("Unexpected error:", err)
+	}
+}
+
+func TestBatchError(t *testing.T) {
+	lw := &limitedWriter{n: 6}
+	var hooked []error
+	w := NewWriter(lw, WithWriteHook(func(frame []byte, err error) {
+		hooked = append(hooked, err)
+	}))
+	w.BeginBatch()
+	w.AddFrame([]byte{1, 2})
+	w.AddFrame([]byte{3, 4})
+	err := w.EndBatch()
+	var berr *BatchWriteError
+	if !errors.As(err, &berr) || berr.Packets != 1 || !errors.Is(err, errLimit) {
+		t.Error("Expected BatchWriteError after 1 packet but got", err)
+	}
+	if len(hooked) != 2 || hooked[0] != nil || hooked[1] != err {
+		t.Error("Expected hook calls with nil and", err, "but got", hooked)
+	}
+
+	// The failed batch is dropped
+	lw.n = 100
+	w.BeginBatch()
+	w.AddFrame([]byte{5})
+	w.EndBatch()
+	if expected := []byte{END, 1, 2, END, 3, 4, END, 5, END}; !eqBytes(expected, lw.Bytes()) {
+		t.Error("Expected data", expected, "but got", lw.Bytes())
+	}
+}
+
+func TestBatchBuffered(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf, WithWriteBuffering(64))
+	w.WritePacket([]byte{1})
+	w.BeginBatch()
+	w.AddFrame([]byte{2})
+	w.AddFrame([]byte{3})
+	w.EndBatch()
+	if buf.Len() != 0 {
+		t.Error("Expected no write before Flush but got", buf.Bytes())
+	}
+	w.Flush()
+	if expected := []byte{END, 1, END, END, 2, END, 3, END}; !eqBytes(expected, buf.Bytes()) {
+		t.Error("Expected data", expected, "but got", buf.Bytes())
+	}
+}
+
+func TestBatchNested(t *testing.T) {
+	w := NewWriter(&bytes.Buffer{})
+	w.BeginBatch()
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("Expected a panic of the nested BeginBatch")
+			}
+		}()
+		w.BeginBatch()
+	}()
+
+	// The open batch is not affected
+	w.AddFrame([]byte{1})
+	if err := w.EndBatch(); err != nil {
+		t.Error("Unexpected error:", err)
+	}
+	if err := w.WritePacket([]byte{2}); err != nil {
+		t.Error("Unexpected error:", err)
+	}
+}
@@ -0,0 +1,52 @@
+package slip
+
+import "time"
+
+// coalesce appends the frames that follow the packet p within the
+// window of WithCoalesceWindow to it. A frame that does not fit into
+// limit is kept for the next read, as is an unfinished one. A protocol
+// error of a following frame is kept in cerr for the next read.
+func (s *Reader) coalesce(p []byte, limit int) []byte {
+	dl, ok := s.r.(readDeadliner)
+	timeout, nofill := s.timeout, s.nofill
+	if !ok {
+		// without deadlines only frames already buffered can be
+		// told to have arrived in time
+		s.nofill = true
+	}
+	for {
+		if ok {
+			s.timeout = time.Now().Add(s.window)
+			if !timeout.IsZero() && timeout.Before(s.timeout) {
+				s.timeout = timeout
+			}
+			s.setDeadline(dl, s.timeout)
+		}
+		s.cwait = true
+		q, _, err := s.readFrame(s.cbuf[:0], limit, ErrPacketTooLarge)
+		s.cwait = false
+		s.cbuf = q[:0]
+		if err != nil {
+			if s.dropped(err) {
+				continue
+			}
+			if isProtocolError(err) {
+				s.cerr = err
+			}
+			break
+		}
+		if limit >= 0 && len(p)+len(q) > limit {
+			s.partial = append(s.partial[:0], q...)
+			s.complete = true
+			s.redeliver = true
+			break
+		}
+		p = append(p, q...)
+	}
+	if ok {
+		s.timeout = timeout
+		s.setDeadline(dl, timeout)
+	}
+	s.nofill = nofill
+	return p
+}
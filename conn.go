@@ -0,0 +1,143 @@
+package slip
+
+import (
+	"io"
+	"net"
+	"time"
+)
+
+// Conn reads and writes SLIP packets on a single bidirectional stream
+// like a net.Conn or a serial port. Reading and writing use separate
+// locks, so a blocked ReadPacket does not delay WritePacket.
+type Conn struct {
+	*Reader
+	*Writer
+	rw io.ReadWriter
+}
+
+// NewConn returns a new Conn on rw. The options apply to both directions.
+func NewConn(rw io.ReadWriter, opts ...Option) *Conn {
+	ropts := make([]ReaderOption, len(opts))
+	wopts := make([]WriterOption, len(opts))
+	for i, opt := range opts {
+		ropts[i] = opt
+		wopts[i] = opt
+	}
+	return &Conn{
+		Reader: NewReader(rw, ropts...),
+		Writer: NewWriter(rw, wopts...),
+		rw:     rw,
+	}
+}
+
+// Close flushes the packets buffered for writing and closes the
+// underlying stream once if it implements io.Closer. The error of the
+// flush is returned before that of closing the stream. Later reads and
+// writes return ErrClosed.
+func (c *Conn) Close() error {
+	werr := c.Writer.close(false)
+	err := c.Reader.Close()
+	if werr != nil && werr != ErrClosed {
+		return werr
+	}
+	return err
+}
+
+// ReadBuffered returns the raw bytes read ahead by the reading side,
+// see Reader.Buffered. Conn has no Buffered method, the one of Reader
+// and the one of Writer would be ambiguous.
+func (c *Conn) ReadBuffered() int {
+	return c.Reader.Buffered()
+}
+
+// WriteBuffered returns the encoded bytes waiting for Flush on the
+// writing side, see Writer.Buffered.
+func (c *Conn) WriteBuffered() int {
+	return c.Writer.Buffered()
+}
+
+// Reset makes c read from and write to rw, see Reader.Reset.
+func (c *Conn) Reset(rw io.ReadWriter) {
+	c.Reader.Reset(rw)
+	c.Writer.Reset(rw)
+	c.rw = rw
+}
+
+// SetDeadline sets the read and write deadlines of the underlying
+// stream. It returns ErrDeadlineNotSupported if the stream has no
+// SetDeadline method.
+func (c *Conn) SetDeadline(t time.Time) error {
+	if d, ok := c.rw.(interface{ SetDeadline(time.Time) error }); ok {
+		return d.SetDeadline(t)
+	}
+	return ErrDeadlineNotSupported
+}
+
+// LocalAddr returns the local address if the underlying stream is a
+// net.Conn and nil otherwise.
+func (c *Conn) LocalAddr() net.Addr {
+	if nc, ok := c.rw.(net.Conn); ok {
+		return nc.LocalAddr()
+	}
+	return nil
+}
+
+// RemoteAddr returns the remote address if the underlying stream is a
+// net.Conn and nil otherwise.
+func (c *Conn) RemoteAddr() net.Addr {
+	if nc, ok := c.rw.(net.Conn); ok {
+		return nc.RemoteAddr()
+	}
+	return nil
+}
+
+// Stream returns an io.ReadWriteCloser on c with stream semantics, for
+// APIs that want one rather than packets. Every Write sends its input
+// as one packet, an empty Write sends nothing. Read returns the payload
+// bytes of the packets read from c in order, without their boundaries:
+// a packet larger than the buffer of a Read is handed out over several
+// calls, and a Read returns bytes of at most one packet, so the caller
+// sees a short read at every packet end. A Read error, e.g. in the
+// middle of a packet, is returned before any of its bytes, which the
+// next Read delivers once the packet is complete. Close closes c.
+//
+// The stream keeps the unread rest of a packet, so its Read must not be
+// called concurrently or mixed with the reads of c.
+func (c *Conn) Stream() io.ReadWriteCloser {
+	return &connStream{c: c}
+}
+
+type connStream struct {
+	c    *Conn
+	rest []byte // unread bytes of the current packet
+}
+
+func (s *connStream) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	for len(s.rest) == 0 {
+		pkt, _, err := s.c.ReadPacket()
+		if err != nil {
+			return 0, err
+		}
+		s.rest = pkt
+	}
+	n := copy(p, s.rest)
+	s.rest = s.rest[n:]
+	return n, nil
+}
+
+func (s *connStream) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if err := s.c.WritePacket(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (s *connStream) Close() error {
+	return s.c.Close()
+}
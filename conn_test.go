@@ -0,0 +1,153 @@
+package slip
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestConn(t *testing.T) {
+	c1, c2 := net.Pipe()
+	a, b := NewConn(c1), NewConn(c2)
+	defer a.Close()
+	defer b.Close()
+
+	// A pending read on a does not block a write on a
+	done := make(chan []byte, 1)
+	go func() {
+		p, _, _ := a.ReadPacket()
+		done <- p
+	}()
+	go b.ReadPacket()
+	time.Sleep(5 * time.Millisecond)
+
+	errc := make(chan error, 1)
+	go func() { errc <- a.WritePacket([]byte{1, END}) }()
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Error("Unexpected error:", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("WritePacket blocked by ReadPacket")
+	}
+
+	if err := b.WritePacket([]byte{2, ESC}); err != nil {
+		t.Error("Unexpected error:", err)
+	}
+	if p := <-done; !eqBytes(p, []byte{2, ESC}) {
+		t.Error("Expected data", []byte{2, ESC}, "but got", p)
+	}
+
+	if a.LocalAddr() == nil || a.RemoteAddr() == nil {
+		t.Error("Expected addresses of the net.Conn")
+	}
+	if err := a.SetDeadline(time.Now().Add(time.Second)); err != nil {
+		t.Error("Unexpected error:", err)
+	}
+}
+
+func TestConnWithoutNetConn(t *testing.T) {
+	c := NewConn(&closeRecorder{})
+	if err := c.SetDeadline(time.Now()); err != ErrDeadlineNotSupported {
+		t.Error("Expected error", ErrDeadlineNotSupported, "but got", err)
+	}
+	if c.LocalAddr() != nil || c.RemoteAddr() != nil {
+		t.Error("Expected no addresses")
+	}
+}
+
+func TestConnClose(t *testing.T) {
+	rw := &closeRecorder{}
+	c := NewConn(rw)
+	if err := c.Close(); err != nil {
+		t.Error("Unexpected error:", err)
+	}
+	if rw.closed != 1 {
+		t.Error("Expected stream to be closed once but got", rw.closed)
+	}
+	if err := c.WritePacket([]byte{1}); err != ErrClosed {
+		t.Error("Expected error", ErrClosed, "but got", err)
+	}
+	if _, _, err := c.ReadPacket(); err != ErrClosed {
+		t.Error("Expected error", ErrClosed, "but got", err)
+	}
+}
+
+func TestConnBuffered(t *testing.T) {
+	rw := &closeRecorder{}
+	c := NewConn(rw)
+	c.Writer = NewWriter(rw, WithWriteBuffering(16))
+	c.WritePacket([]byte{1})
+	if n, m := c.ReadBuffered(), c.WriteBuffered(); n != 0 || m != 3 {
+		t.Error("Expected 0 bytes read ahead and 3 pending but got", n, m)
+	}
+	c.Flush()
+	rw.Write([]byte{2, END})
+	c.Peek(1)
+	if n, m := c.ReadBuffered(), c.WriteBuffered(); n == 0 || m != 0 {
+		t.Error("Expected bytes read ahead and 0 pending but got", n, m)
+	}
+}
+
+func TestConnCloseFlushError(t *testing.T) {
+	rw := &closeRecorder{}
+	c := NewConn(rw)
+	c.Writer = NewWriter(&limitedWriter{}, WithWriteBuffering(16))
+	c.WritePacket([]byte{1})
+	if err := c.Close(); err != errLimit {
+		t.Error("Expected error", errLimit, "but got", err)
+	}
+	if rw.closed != 1 {
+		t.Error("Expected stream to be closed once but got", rw.closed)
+	}
+	if err := c.Close(); err != ErrClosed {
+		t.Error("Expected error", ErrClosed, "but got", err)
+	}
+}
+
+func TestConnOptions(t *testing.T) {
+	rw := &closeRecorder{}
+	c := NewConn(rw, WithControlBytes(0x7e, 0x7d, 0x5e, 0x5d))
+	c.WritePacket([]byte{0x7e})
+	if !eqBytes(rw.Bytes(), []byte{0x7e, 0x7d, 0x5e, 0x7e}) {
+		t.Error("Expected data", []byte{0x7e, 0x7d, 0x5e, 0x7e}, "but got", rw.Bytes())
+	}
+	p, _, _ := c.ReadPacket()
+	if !eqBytes(p, []byte{0x7e}) {
+		t.Error("Expected data", []byte{0x7e}, "but got", p)
+	}
+}
+
+func TestConnStream(t *testing.T) {
+	c1, c2 := net.Pipe()
+	a, b := NewConn(c1), NewConn(c2)
+	defer a.Close()
+	sa, sb := a.Stream(), b.Stream()
+
+	go func() {
+		sa.Write([]byte("hello"))
+		sa.Write(nil)
+		sa.Write([]byte{END, '!'})
+	}()
+	buf := make([]byte, 3)
+	for _, expected := range []string{"hel", "lo", "\xc0!"} {
+		n, err := sb.Read(buf)
+		if err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		if string(buf[:n]) != expected {
+			t.Error("Expected data", []byte(expected), "but got", buf[:n])
+		}
+	}
+
+	if err := sb.Close(); err != nil {
+		t.Error("Unexpected error:", err)
+	}
+	if _, err := sb.Read(buf); err != ErrClosed {
+		t.Error("Expected error", ErrClosed, "but got", err)
+	}
+	if _, err := sb.Write([]byte{1}); err != ErrClosed {
+		t.Error("Expected error", ErrClosed, "but got", err)
+	}
+}
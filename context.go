@@ -0,0 +1,198 @@
+package slip
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+type readResult struct {
+	p   []byte
+	err error
+}
+
+// ReadPacketContext reads the next complete packet like ReadPacket but
+// returns ctx.Err() as soon as ctx is done. The packet is always newly
+// allocated, regardless of WithCopyOnRead.
+//
+// An io.Reader cannot be interrupted, so the read runs in a separate
+// goroutine. If the underlying reader has a SetReadDeadline method, as
+// net.Conn does, the deadline is moved to the past on cancellation
+// and the call returns once the blocked Read failed; bytes of a packet
+// received so far are kept for the next read. Otherwise the read is
+// abandoned and keeps running in the background. The packet it
+// eventually reads is returned by the next call to ReadPacketContext,
+// but it is lost for ReadPacket, which blocks until the abandoned read
+// completed.
+func (s *Reader) ReadPacketContext(ctx context.Context) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.pmu.Lock()
+	res := s.pending
+	s.pending = nil
+	s.pmu.Unlock()
+
+	if res == nil {
+		res = make(chan readResult, 1)
+		go func() {
+			p, _, err := s.ReadPacket()
+			if s.reuse {
+				// the result may be kept past the next read
+				p = append([]byte(nil), p...)
+			}
+			res <- readResult{p: p, err: err}
+		}()
+	}
+
+	select {
+	case r := <-res:
+		return r.p, r.err
+	case <-ctx.Done():
+	}
+
+	select {
+	case r := <-res:
+		// The read completed as well, keep its result
+		if r.err == nil {
+			s.unread(r.p)
+			return nil, ctx.Err()
+		}
+		res <- r
+	default:
+		if s.SetReadDeadline(time.Unix(1, 0)) == nil {
+			r := <-res
+			s.SetReadDeadline(time.Time{})
+			if r.err == nil {
+				// The packet completed before the deadline hit, keep it
+				s.unread(r.p)
+			}
+			return nil, ctx.Err()
+		}
+	}
+	s.pmu.Lock()
+	s.pending = res
+	s.pmu.Unlock()
+	return nil, ctx.Err()
+}
+
+// WritePacketContext writes p as one packet like WritePacket but
+// returns ctx.Err() as soon as ctx is done, e.g. to shut down a Writer
+// stuck on a full socket buffer. p is copied, so it may be reused once
+// the call returned.
+//
+// An io.Writer cannot be interrupted, so the write runs in a separate
+// goroutine. If the underlying writer has a SetWriteDeadline method, as
+// net.Conn does, the deadline is moved to the past on cancellation and
+// the call returns once the blocked Write failed. Otherwise the write
+// is abandoned and keeps running in the background, holding the lock
+// of the Writer until it completes. Either way part of the packet may
+// have been written to the stream; the leading END of the next packet
+// makes the peer drop it as noise.
+func (s *Writer) WritePacketContext(ctx context.Context, p []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	p = append([]byte(nil), p...)
+	res := make(chan error, 1)
+	go func() {
+		res <- s.WritePacket(p)
+	}()
+
+	select {
+	case err := <-res:
+		return err
+	case <-ctx.Done():
+	}
+
+	if d, ok := s.w.(writeDeadliner); ok && d.SetWriteDeadline(time.Unix(1, 0)) == nil {
+		err := <-res
+		d.SetWriteDeadline(time.Time{})
+		if err == nil {
+			// The packet was written before the deadline hit
+			return nil
+		}
+	}
+	return ctx.Err()
+}
+
+// unread hands the packet p back to s like a peeked packet, so the
+// next read of any kind returns it first, without passing it through
+// the hooks of the Reader again.
+func (s *Reader) unread(p []byte) {
+	s.lock()
+	defer s.unlock()
+	s.partial = append(s.partial[:0], p...)
+	s.complete = true
+	s.redeliver = true
+}
+
+// Packets starts a goroutine that reads packets from s with
+// ReadPacketContext and sends them on the returned packet channel.
+// Every packet is a newly allocated slice the consumer may keep.
+//
+// The packet channel is closed at the end of the stream, when ctx is
+// done, after StopPackets or after an error. The error channel then
+// receives the error, if a read failed for another reason than io.EOF
+// or the shutdown, and is closed, so a consumer ranges over the packets
+// and reads the error channel afterwards. A packet read but not
+// delivered before the shutdown is handed back to s like a peeked
+// packet: the next read of any kind returns it first, and Discard and
+// Resync drop it.
+// Exactly one goroutine should consume the channels, and s must not be
+// read otherwise until the packet channel is closed.
+//
+// A consumer that stops ranging before the packet channel is closed
+// must cancel ctx or call StopPackets, or the goroutine leaks. A Read
+// blocked on the underlying reader is interrupted as described for
+// ReadPacketContext: without a SetReadDeadline method it keeps running
+// in the background until it returns, and only the next call to
+// ReadPacketContext returns its packet.
+func (s *Reader) Packets(ctx context.Context) (<-chan []byte, <-chan error) {
+	ctx, cancel := context.WithCancel(ctx)
+	packets := make(chan []byte)
+	errc := make(chan error, 1)
+	done := make(chan struct{})
+	s.pmu.Lock()
+	s.stopPackets, s.packetsDone = cancel, done
+	s.pmu.Unlock()
+	go func() {
+		defer close(done)
+		defer cancel()
+		defer close(packets)
+		defer close(errc)
+		for {
+			p, err := s.ReadPacketContext(ctx)
+			if err != nil {
+				if err != io.EOF && ctx.Err() == nil {
+					errc <- err
+				}
+				return
+			}
+			select {
+			case packets <- p:
+			case <-ctx.Done():
+				s.unread(p)
+				return
+			}
+		}
+	}()
+	return packets, errc
+}
+
+// StopPackets shuts down the goroutine of the last call to Packets, as
+// if its context was done, and waits until it closed the channels. It
+// does nothing if Packets was not called or the goroutine is done.
+func (s *Reader) StopPackets() {
+	s.pmu.Lock()
+	cancel, done := s.stopPackets, s.packetsDone
+	s.stopPackets, s.packetsDone = nil, nil
+	s.pmu.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+}
@@ -0,0 +1,320 @@
+package slip
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestReadPacketContextCancel(t *testing.T) {
+	pr, pw := io.Pipe()
+	r := NewReader(pr)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	p, err := r.ReadPacketContext(ctx)
+	if err != context.DeadlineExceeded {
+		t.Error("Expected error", context.DeadlineExceeded, "but got", err)
+	}
+	if p != nil {
+		t.Error("Expected no data but got", p)
+	}
+
+	// The abandoned read is picked up by the next call
+	go pw.Write([]byte{1, 2, END})
+	p, err = r.ReadPacketContext(context.Background())
+	if err != nil {
+		t.Error("Unexpected error:", err)
+	}
+	if !eqBytes(p, []byte{1, 2}) {
+		t.Error("Expected data", []byte{1, 2}, "but got", p)
+	}
+}
+
+func TestReadPacketContextDeadline(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	r := NewReader(c1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	_, err := r.ReadPacketContext(ctx)
+	if err != context.Canceled {
+		t.Error("Expected error", context.Canceled, "but got", err)
+	}
+
+	// The blocked Read was interrupted and the reader is usable again
+	go c2.Write([]byte{3, END})
+	p, _, err := r.ReadPacket()
+	if err != nil {
+		t.Error("Unexpected error:", err)
+	}
+	if !eqBytes(p, []byte{3}) {
+		t.Error("Expected data", []byte{3}, "but got", p)
+	}
+}
+
+func TestReadPacketContextDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r := NewReader(eofReader{})
+	if _, err := r.ReadPacketContext(ctx); err != context.Canceled {
+		t.Error("Expected error", context.Canceled, "but got", err)
+	}
+}
+
+func TestReadPacketContextZeroRead(t *testing.T) {
+	// Empty reads end the read instead of retrying forever
+	r := NewReader(zeroReader{}, WithZeroReadRetryLimit(3))
+	done := make(chan error, 1)
+	go func() {
+		_, err := r.ReadPacketContext(context.Background())
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != io.ErrNoProgress {
+			t.Error("Expected error", io.ErrNoProgress, "but got", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected", io.ErrNoProgress, "but the read did not return")
+	}
+}
+
+func TestWritePacketContext(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	if err := w.WritePacketContext(context.Background(), []byte{1, END}); err != nil {
+		t.Error("Unexpected error:", err)
+	}
+	if expected := []byte{END, 1, ESC, ESC_END, END}; !eqBytes(expected, buf.Bytes()) {
+		t.Error("Expected data", expected, "but got", buf.Bytes())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := w.WritePacketContext(ctx, []byte{2}); err != context.Canceled {
+		t.Error("Expected error", context.Canceled, "but got", err)
+	}
+}
+
+func TestWritePacketContextCancel(t *testing.T) {
+	pr, pw := io.Pipe()
+	w := NewWriter(pw)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	p := []byte{1, 2}
+	if err := w.WritePacketContext(ctx, p); err != context.DeadlineExceeded {
+		t.Error("Expected error", context.DeadlineExceeded, "but got", err)
+	}
+	p[0] = 9
+
+	// The abandoned write completes in the background with the copy
+	r := NewReader(pr)
+	got, _, err := r.ReadPacket()
+	if err != nil || !eqBytes([]byte{1, 2}, got) {
+		t.Error("Expected data", []byte{1, 2}, "but got", got, err)
+	}
+}
+
+func TestWritePacketContextDeadline(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	w := NewWriter(c1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	if err := w.WritePacketContext(ctx, []byte{1}); err != context.Canceled {
+		t.Error("Expected error", context.Canceled, "but got", err)
+	}
+
+	// The blocked Write was interrupted and the writer is usable again
+	go func() {
+		if err := w.WritePacket([]byte{3}); err != nil {
+			t.Error("Unexpected error:", err)
+		}
+	}()
+	p, _, err := NewReader(c2).ReadPacket()
+	if err != nil || !eqBytes([]byte{3}, p) {
+		t.Error("Expected data", []byte{3}, "but got", p, err)
+	}
+}
+
+type eofReader struct{}
+
+func (eofReader) Read(p []byte) (int, error) {
+	return 0, io.EOF
+}
+
+func TestCloseUnblocksRead(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	r := NewReader(c1)
+
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := r.ReadPacket()
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	if err := r.Close(); err != nil {
+		t.Error("Unexpected error:", err)
+	}
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("Expected error from blocked read")
+		}
+	case <-time.After(time.Second):
+		t.Error("Close did not unblock ReadPacket")
+	}
+}
+
+func TestPackets(t *testing.T) {
+	r := NewReader(bytes.NewReader([]byte{END, 1, END, 2, 3, END, ESC, 1, END}), WithStrictDecoding(true))
+	packets, errc := r.Packets(context.Background())
+
+	var got [][]byte
+	for p := range packets {
+		got = append(got, p)
+	}
+	if len(got) != 2 || !eqBytes(got[0], []byte{1}) || !eqBytes(got[1], []byte{2, 3}) {
+		t.Error("Expected packets [1] [2 3] but got", got)
+	}
+	if err := <-errc; !errors.Is(err, ErrInvalidEscape) {
+		t.Error("Expected error", ErrInvalidEscape, "but got", err)
+	}
+
+	// EOF closes the channels without error
+	packets, errc = NewReader(bytes.NewReader([]byte{END, 1, END})).Packets(context.Background())
+	for range packets {
+	}
+	if err := <-errc; err != nil {
+		t.Error("Unexpected error:", err)
+	}
+}
+
+func TestPacketsCancel(t *testing.T) {
+	r := NewReader(bytes.NewReader([]byte{END, 1, END, 2, END}))
+	ctx, cancel := context.WithCancel(context.Background())
+	packets, errc := r.Packets(ctx)
+
+	if p := <-packets; !eqBytes(p, []byte{1}) {
+		t.Error("Expected data", []byte{1}, "but got", p)
+	}
+	cancel()
+	var rest [][]byte
+	for p := range packets {
+		rest = append(rest, p)
+	}
+	if err := <-errc; err != nil {
+		t.Error("Unexpected error:", err)
+	}
+
+	// The second packet was either delivered before the cancellation
+	// was noticed or is kept for the next read
+	if len(rest) == 0 {
+		p, err := r.ReadPacketContext(context.Background())
+		if err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		rest = append(rest, p)
+	}
+	if len(rest) != 1 || !eqBytes(rest[0], []byte{2}) {
+		t.Error("Expected data", []byte{2}, "but got", rest)
+	}
+}
+
+// waitGoroutines waits up to a second for the number of goroutines to
+// drop to n, for tests that shut down background goroutines.
+func waitGoroutines(t *testing.T, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > n {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected", n, "goroutines but got", runtime.NumGoroutine())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestStopPackets(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	n := runtime.NumGoroutine()
+
+	hooked := 0
+	r := NewReader(c1, WithReadHook(func([]byte, error) { hooked++ }))
+	packets, errc := r.Packets(context.Background())
+	go c2.Write([]byte{END, 1, END, 2, END})
+	if p := <-packets; !eqBytes(p, []byte{1}) {
+		t.Error("Expected data", []byte{1}, "but got", p)
+	}
+
+	// The consumer abandons the channels, the goroutine is blocked on
+	// sending the second packet
+	time.Sleep(10 * time.Millisecond)
+	r.StopPackets()
+	if _, ok := <-packets; ok {
+		t.Error("Expected packet channel to be closed")
+	}
+	if err := <-errc; err != nil {
+		t.Error("Unexpected error:", err)
+	}
+	r.StopPackets()
+	waitGoroutines(t, n)
+
+	// The undelivered packet is kept for every kind of read
+	buf := make([]byte, 4)
+	n2, err := r.ReadPacketInto(buf)
+	if err != nil || !eqBytes(buf[:n2], []byte{2}) {
+		t.Error("Expected data", []byte{2}, "but got", buf[:n2], err)
+	}
+	if hooked != 2 {
+		t.Error("Expected the hook to see 2 packets but got", hooked)
+	}
+
+	// A blocked Read is interrupted by the deadline
+	packets, _ = r.Packets(context.Background())
+	time.Sleep(10 * time.Millisecond)
+	r.StopPackets()
+	for range packets {
+	}
+	waitGoroutines(t, n)
+}
+
+func TestPacketsCancelNoDeadline(t *testing.T) {
+	n := runtime.NumGoroutine()
+	pr, pw := io.Pipe()
+	r := NewReader(pr)
+	ctx, cancel := context.WithCancel(context.Background())
+	packets, _ := r.Packets(ctx)
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	for range packets {
+	}
+
+	// The abandoned Read keeps one goroutine until it returns
+	pw.Write([]byte{1, END})
+	waitGoroutines(t, n)
+	p, err := r.ReadPacketContext(context.Background())
+	if err != nil || !eqBytes(p, []byte{1}) {
+		t.Error("Expected data", []byte{1}, "but got", p, err)
+	}
+}
@@ -0,0 +1,157 @@
+package slip
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrChecksumMismatch matches every ChecksumError with errors.Is.
+var ErrChecksumMismatch = errors.New("slip: checksum mismatch")
+
+// ChecksumError is returned by a Reader configured with WithCRC16 or
+// WithWireChecksum when the trailer of a packet does not match its
+// payload. The packet is dropped.
+type ChecksumError struct {
+	Got  uint16 // checksum received in the trailer
+	Want uint16 // checksum computed over the payload or its wire bytes
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("slip: checksum mismatch: got %#04x, want %#04x", e.Got, e.Want)
+}
+
+// Is reports whether target is ErrChecksumMismatch.
+func (e *ChecksumError) Is(target error) bool {
+	return target == ErrChecksumMismatch
+}
+
+// CRC16 describes a CRC-16 variant. Reflected variants process the
+// bits of each byte LSB first and send the trailer least significant
+// byte first, the others MSB first and most significant byte first.
+type CRC16 struct {
+	Poly      uint16 // polynomial in normal, non reflected notation
+	Init      uint16
+	Reflected bool
+	XorOut    uint16
+}
+
+var (
+	// CRC16CCITT is CRC-16/CCITT-FALSE, used by many serial protocols.
+	CRC16CCITT = CRC16{Poly: 0x1021, Init: 0xffff}
+
+	// CRC16X25 is the FCS-16 of PPP and HDLC (RFC 1662), see CalcFcs16.
+	CRC16X25 = CRC16{Poly: 0x1021, Init: 0xffff, Reflected: true, XorOut: 0xffff}
+
+	// CRC16Kermit is CRC-16/KERMIT.
+	CRC16Kermit = CRC16{Poly: 0x1021, Reflected: true}
+)
+
+// crc16Table is a CRC16 with its precomputed lookup table.
+type crc16Table struct {
+	CRC16
+	tab [256]uint16
+}
+
+func newCRC16Table(c CRC16) *crc16Table {
+	t := &crc16Table{CRC16: c}
+	if c.Reflected {
+		poly := reverse16(c.Poly)
+		for i := range t.tab {
+			crc := uint16(i)
+			for j := 0; j < 8; j++ {
+				if crc&1 != 0 {
+					crc = crc>>1 ^ poly
+				} else {
+					crc >>= 1
+				}
+			}
+			t.tab[i] = crc
+		}
+	} else {
+		for i := range t.tab {
+			crc := uint16(i) << 8
+			for j := 0; j < 8; j++ {
+				if crc&0x8000 != 0 {
+					crc = crc<<1 ^ c.Poly
+				} else {
+					crc <<= 1
+				}
+			}
+			t.tab[i] = crc
+		}
+	}
+	return t
+}
+
+func reverse16(v uint16) uint16 {
+	var r uint16
+	for i := 0; i < 16; i++ {
+		r = r<<1 | v&1
+		v >>= 1
+	}
+	return r
+}
+
+func (t *crc16Table) update(crc uint16, b byte) uint16 {
+	if t.Reflected {
+		return crc>>8 ^ t.tab[byte(crc)^b]
+	}
+	return crc<<8 ^ t.tab[byte(crc>>8)^b]
+}
+
+// Checksum returns the CRC of p.
+func (c CRC16) Checksum(p []byte) uint16 {
+	return newCRC16Table(c).checksum(p)
+}
+
+func (t *crc16Table) checksum(p []byte) uint16 {
+	crc := t.Init
+	for _, b := range p {
+		crc = t.update(crc, b)
+	}
+	return crc ^ t.XorOut
+}
+
+// checksumv returns the CRC of the concatenation of ps.
+func (t *crc16Table) checksumv(ps [][]byte) uint16 {
+	crc := t.Init
+	for _, p := range ps {
+		for _, b := range p {
+			crc = t.update(crc, b)
+		}
+	}
+	return crc ^ t.XorOut
+}
+
+func (t *crc16Table) checksumString(str string) uint16 {
+	crc := t.Init
+	for i := 0; i < len(str); i++ {
+		crc = t.update(crc, str[i])
+	}
+	return crc ^ t.XorOut
+}
+
+// trailer returns the bytes of crc in the order they are sent.
+func (t *crc16Table) trailer(crc uint16) [2]byte {
+	if t.Reflected {
+		return [2]byte{byte(crc), byte(crc >> 8)}
+	}
+	return [2]byte{byte(crc >> 8), byte(crc)}
+}
+
+// verify strips and checks the trailer of the decoded packet p.
+func (t *crc16Table) verify(p []byte) ([]byte, error) {
+	if len(p) < 2 {
+		return p[:0], &ChecksumError{Want: t.checksum(nil)}
+	}
+	payload, tr := p[:len(p)-2], p[len(p)-2:]
+	want := t.checksum(payload)
+	if w := t.trailer(want); w[0] != tr[0] || w[1] != tr[1] {
+		got := uint16(tr[0])<<8 | uint16(tr[1])
+		if t.Reflected {
+			got = uint16(tr[1])<<8 | uint16(tr[0])
+		}
+		return p[:0], &ChecksumError{Got: got, Want: want}
+	}
+	return payload, nil
+}
@@ -0,0 +1,123 @@
+package slip
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"testing"
+)
+
+var crcCheck = []struct {
+	crc      CRC16
+	expected uint16 // checksum of "123456789"
+}{
+	{CRC16CCITT, 0x29b1},
+	{CRC16X25, 0x906e},
+	{CRC16Kermit, 0x2189},
+	{CRC16{Poly: 0x8005}, 0xfee8}, // CRC-16/UMTS
+}
+
+func TestCRC16Check(t *testing.T) {
+	for i, test := range crcCheck {
+		if sum := test.crc.Checksum([]byte("123456789")); sum != test.expected {
+			t.Errorf("%d Expected checksum %#04x but got %#04x", i, test.expected, sum)
+		}
+	}
+}
+
+func TestCRC16X25IsFcs16(t *testing.T) {
+	data := []byte{FRAME_COAP, 1, 2, 3, 4}
+	if sum, fcs := CRC16X25.Checksum(data), CalcFcs16(data)^0xffff; sum != fcs {
+		t.Errorf("Expected checksum %#04x but got %#04x", fcs, sum)
+	}
+	p := append(append([]byte(nil), data...), 0, 0)
+	tr := newCRC16Table(CRC16X25).trailer(CRC16X25.Checksum(data))
+	copy(p[len(data):], tr[:])
+	if !CheckFsc16(p) {
+		t.Error("Expected trailer to be a valid FCS16")
+	}
+}
+
+var crcPackets = [][]byte{
+	{1, 2, 3},
+	{END, ESC, END},
+	{},
+	{0x29, 0xb1},
+	[]byte("x"),
+}
+
+func TestCRC16Roundtrip(t *testing.T) {
+	for _, crc := range []CRC16{CRC16CCITT, CRC16X25} {
+		buf := &bytes.Buffer{}
+		w := NewWriter(buf, WithCRC16(crc))
+		for _, p := range crcPackets {
+			if err := w.WritePacket(p); err != nil {
+				t.Fatal("Unexpected error", err)
+			}
+		}
+		w.WriteString("str")
+
+		r := NewReader(buf, WithCRC16(crc))
+		for i, expected := range append(crcPackets, []byte("str")) {
+			if len(expected) == 0 {
+				// the trailer of an empty packet decodes to an empty frame
+				continue
+			}
+			p, _, err := r.ReadPacket()
+			if err != nil {
+				t.Fatal(strconv.Itoa(i), "Unexpected error", err)
+			}
+			if !eqBytes(expected, p) {
+				t.Error(strconv.Itoa(i), "Expected data", expected, "but got", p)
+			}
+		}
+		if s := r.Stats(); s.Errors != 0 {
+			t.Error("Expected no errors but got", s.Errors)
+		}
+	}
+}
+
+func TestCRC16OnTheWire(t *testing.T) {
+	buf := &bytes.Buffer{}
+	NewWriter(buf, WithCRC16(CRC16CCITT)).WritePacket([]byte("123456789"))
+	// A plain reader sees the trailer
+	p, _, err := NewReader(buf).ReadPacket()
+	expected := append([]byte("123456789"), 0x29, 0xb1)
+	if err != nil || !eqBytes(expected, p) {
+		t.Error("Expected data", expected, "but got", p, err)
+	}
+}
+
+func TestCRC16Mismatch(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf, WithCRC16(CRC16CCITT))
+	w.WritePacket([]byte("123456789"))
+	w.WritePacket([]byte{7})
+	data := buf.Bytes()
+	data[3] = 'x'
+
+	r := NewReader(bytes.NewReader(data), WithCRC16(CRC16CCITT))
+	p, _, err := r.ReadPacket()
+	if !errors.Is(err, ErrChecksumMismatch) || len(p) != 0 {
+		t.Fatal("Expected error", ErrChecksumMismatch, "but got", p, err)
+	}
+	var cerr *ChecksumError
+	if !errors.As(err, &cerr) || cerr.Got != 0x29b1 {
+		t.Error("Expected ChecksumError with Got 0x29b1 but got", err)
+	}
+
+	// The next packet is not affected
+	p, _, err = r.ReadPacket()
+	if err != nil || !eqBytes([]byte{7}, p) {
+		t.Error("Expected data", []byte{7}, "but got", p, err)
+	}
+	if s := r.Stats(); s.Errors != 1 || s.Packets != 1 {
+		t.Error("Expected 1 error and 1 packet but got", s)
+	}
+
+	// Too short for a trailer
+	r = NewReader(bytes.NewReader([]byte{END, 1, END}), WithCRC16(CRC16CCITT))
+	if _, _, err = r.ReadPacket(); !errors.Is(err, ErrChecksumMismatch) {
+		t.Error("Expected error", ErrChecksumMismatch, "but got", err)
+	}
+}
@@ -0,0 +1,528 @@
+package slip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+)
+
+// CSLIP packet types, carried in the upper bits of the first byte of
+// every packet (RFC 1144)
+const (
+	TYPE_IP               = 0x40
+	TYPE_UNCOMPRESSED_TCP = 0x70
+	TYPE_COMPRESSED_TCP   = 0x80
+)
+
+// Bits of the change mask of a compressed TCP packet
+const (
+	cslipNewC = 0x40
+	cslipNewI = 0x20
+	cslipPush = 0x10
+	cslipNewS = 0x08
+	cslipNewA = 0x04
+	cslipNewW = 0x02
+	cslipNewU = 0x01
+
+	// reserved, special-case values of the above
+	cslipSpecialI     = cslipNewS | cslipNewW | cslipNewU             // echoed interactive traffic
+	cslipSpecialD     = cslipNewS | cslipNewA | cslipNewW | cslipNewU // unidirectional data
+	cslipSpecialsMask = cslipNewS | cslipNewA | cslipNewW | cslipNewU
+)
+
+const (
+	cslipMaxStates = 16  // connection state slots, must be > 2 and < 255
+	cslipMaxHeader = 128 // max TCP+IP header length
+)
+
+const (
+	ipProtoTCP = 6
+
+	tcpFin  = 0x01
+	tcpSyn  = 0x02
+	tcpRst  = 0x04
+	tcpPush = 0x08
+	tcpAck  = 0x10
+	tcpUrg  = 0x20
+)
+
+// ErrBadCompressedPacket is returned by CSLIPReader for packets that
+// cannot be decompressed. Compressed packets are dropped from then on
+// until the peer sends a packet that sets the connection again.
+var ErrBadCompressedPacket = errors.New("slip: bad CSLIP packet")
+
+// cstate is the saved TCP/IP header of one connection
+type cstate struct {
+	id   byte
+	hlen int // zero while unused
+	hdr  [cslipMaxHeader]byte
+}
+
+// compressor keeps the connection state of the sender
+type compressor struct {
+	states   [cslipMaxStates]cstate
+	lru      []int // state indices, most recently used first
+	lastXmit int   // connection of the last packet sent
+}
+
+func newCompressor() *compressor {
+	c := &compressor{lastXmit: -1}
+	for i := range c.states {
+		c.states[i].id = byte(i)
+		c.lru = append(c.lru, i)
+	}
+	return c
+}
+
+// ENCODE of RFC 1144, for non zero deltas
+func encodeDelta(dst []byte, n uint16) []byte {
+	if n >= 256 {
+		return append(dst, 0, byte(n>>8), byte(n))
+	}
+	return append(dst, byte(n))
+}
+
+// ENCODEZ of RFC 1144, for deltas that may be zero
+func encodeDeltaZ(dst []byte, n uint16) []byte {
+	if n == 0 {
+		return append(dst, 0, 0, 0)
+	}
+	return encodeDelta(dst, n)
+}
+
+// compress returns the packet to send for the IPv4 datagram ip: either
+// ip itself (TYPE_IP), a copy with the connection number in the
+// protocol field (TYPE_UNCOMPRESSED_TCP) or the compressed packet
+// (TYPE_COMPRESSED_TCP). The packet type is ORed into the first byte.
+func (c *compressor) compress(ip []byte) []byte {
+	/* Bail if this is not TCP, an IP fragment or if the TCP packet
+	 * isn't 'compressible' (i.e., ACK isn't set or some other
+	 * control bit is set).
+	 */
+	if len(ip) < 40 || ip[0]>>4 != 4 || ip[9] != ipProtoTCP {
+		return ip
+	}
+	ipHL := int(ip[0]&0x0f) << 2
+	if ipHL < 20 || binary.BigEndian.Uint16(ip[6:])&0x3fff != 0 || len(ip) < ipHL+20 {
+		return ip
+	}
+	th := ip[ipHL:]
+	tcpHL := int(th[12]>>4) << 2
+	hlen := ipHL + tcpHL
+	if tcpHL < 20 || hlen > len(ip) || hlen > cslipMaxHeader {
+		return ip
+	}
+	if th[13]&(tcpSyn|tcpFin|tcpRst|tcpAck) != tcpAck {
+		return ip
+	}
+
+	/* Packet is compressible -- we're going to send either a
+	 * COMPRESSED_TCP or UNCOMPRESSED_TCP packet. Either way we
+	 * need to locate (or create) the connection state.
+	 */
+	pos := -1
+	for i, idx := range c.lru {
+		cs := &c.states[idx]
+		if cs.hlen > 0 && bytes.Equal(ip[12:20], cs.hdr[12:20]) &&
+			bytes.Equal(th[0:4], cs.hdr[int(cs.hdr[0]&0x0f)<<2:][0:4]) {
+			pos = i
+			break
+		}
+	}
+	if pos < 0 {
+		/* Didn't find it -- re-use oldest cstate. Send an
+		 * uncompressed packet that tells the other side what
+		 * connection number we're using for this conversation.
+		 */
+		pos = len(c.lru) - 1
+		cs := c.use(pos)
+		return c.uncompressed(cs, ip, hlen)
+	}
+	cs := c.use(pos)
+	old := cs.hdr[:cs.hlen]
+
+	/* Make sure that only what we expect to change changed:
+	 * version, header length & type of service, the "Don't
+	 * fragment" bit, time-to-live and protocol, the TCP header
+	 * length, IP options and TCP options.
+	 */
+	if !bytes.Equal(ip[0:2], old[0:2]) || !bytes.Equal(ip[6:10], old[6:10]) {
+		return c.uncompressed(cs, ip, hlen)
+	}
+	oth := old[ipHL:]
+	if th[12]>>4 != oth[12]>>4 ||
+		!bytes.Equal(ip[20:ipHL], old[20:ipHL]) ||
+		!bytes.Equal(th[20:tcpHL], oth[20:tcpHL]) {
+		return c.uncompressed(cs, ip, hlen)
+	}
+
+	/* Figure out which of the changing fields changed. The receiver
+	 * expects changes in the order: urgent, window, ack, seq.
+	 */
+	var changes byte
+	deltas := make([]byte, 0, 16)
+	if th[13]&tcpUrg != 0 {
+		deltas = encodeDeltaZ(deltas, binary.BigEndian.Uint16(th[18:]))
+		changes |= cslipNewU
+	} else if !bytes.Equal(th[18:20], oth[18:20]) || oth[13]&tcpUrg != 0 {
+		/* URG not set but urp changed, or URG cleared which the
+		 * special case encodings cannot tell the receiver
+		 */
+		return c.uncompressed(cs, ip, hlen)
+	}
+	if d := binary.BigEndian.Uint16(th[14:]) - binary.BigEndian.Uint16(oth[14:]); d != 0 {
+		deltas = encodeDelta(deltas, d)
+		changes |= cslipNewW
+	}
+	deltaA := binary.BigEndian.Uint32(th[8:]) - binary.BigEndian.Uint32(oth[8:])
+	if deltaA != 0 {
+		if deltaA > 0xffff {
+			return c.uncompressed(cs, ip, hlen)
+		}
+		deltas = encodeDelta(deltas, uint16(deltaA))
+		changes |= cslipNewA
+	}
+	deltaS := binary.BigEndian.Uint32(th[4:]) - binary.BigEndian.Uint32(oth[4:])
+	if deltaS != 0 {
+		if deltaS > 0xffff {
+			return c.uncompressed(cs, ip, hlen)
+		}
+		deltas = encodeDelta(deltas, uint16(deltaS))
+		changes |= cslipNewS
+	}
+
+	/* Look for the special-case encodings.
+	 */
+	oldData := uint32(binary.BigEndian.Uint16(old[2:])) - uint32(hlen)
+	switch changes {
+	case 0:
+		/* Nothing changed. If this packet contains data and the
+		 * last one didn't, this is probably a data packet following
+		 * an ack and we send it compressed. Otherwise it's probably
+		 * a retransmit, retransmitted ack or window probe. Send it
+		 * uncompressed in case the other side missed the compressed
+		 * version.
+		 */
+		if !bytes.Equal(ip[2:4], old[2:4]) && oldData == 0 {
+			break
+		}
+		return c.uncompressed(cs, ip, hlen)
+
+	case cslipSpecialI, cslipSpecialD:
+		/* Actual changes match one of our special case encodings
+		 * -- send packet uncompressed.
+		 */
+		return c.uncompressed(cs, ip, hlen)
+
+	case cslipNewS | cslipNewA:
+		if deltaS == deltaA && deltaS == oldData {
+			/* special case for echoed terminal traffic */
+			changes = cslipSpecialI
+			deltas = deltas[:0]
+		}
+
+	case cslipNewS:
+		if deltaS == oldData {
+			/* special case for data xfer */
+			changes = cslipSpecialD
+			deltas = deltas[:0]
+		}
+	}
+
+	if d := binary.BigEndian.Uint16(ip[4:]) - binary.BigEndian.Uint16(old[4:]); d != 1 {
+		deltas = encodeDeltaZ(deltas, d)
+		changes |= cslipNewI
+	}
+	if th[13]&tcpPush != 0 {
+		changes |= cslipPush
+	}
+
+	/* Grab the checksum before we update our state with this
+	 * packet's header.
+	 */
+	out := make([]byte, 0, 4+len(deltas)+len(ip)-hlen)
+	if c.lastXmit != int(cs.id) {
+		c.lastXmit = int(cs.id)
+		out = append(out, TYPE_COMPRESSED_TCP|changes|cslipNewC, cs.id)
+	} else {
+		out = append(out, TYPE_COMPRESSED_TCP|changes)
+	}
+	out = append(out, th[16], th[17])
+	out = append(out, deltas...)
+	out = append(out, ip[hlen:]...)
+	cs.hlen = copy(cs.hdr[:], ip[:hlen])
+	return out
+}
+
+// use moves the state at position pos of the LRU list to the front.
+func (c *compressor) use(pos int) *cstate {
+	idx := c.lru[pos]
+	copy(c.lru[1:pos+1], c.lru[:pos])
+	c.lru[0] = idx
+	return &c.states[idx]
+}
+
+// uncompressed updates the connection state cs and returns a regular
+// IP/TCP packet with the connection number in the protocol field.
+func (c *compressor) uncompressed(cs *cstate, ip []byte, hlen int) []byte {
+	cs.hlen = copy(cs.hdr[:], ip[:hlen])
+	out := append([]byte(nil), ip...)
+	out[9] = cs.id
+	out[0] |= TYPE_UNCOMPRESSED_TCP
+	c.lastXmit = int(cs.id)
+	return out
+}
+
+// decompressor keeps the connection state of the receiver
+type decompressor struct {
+	states   [cslipMaxStates]cstate
+	lastRecv int
+	toss     bool // drop compressed packets until the connection is set again
+}
+
+// decodeDelta reads a delta encoded with encodeDelta or encodeDeltaZ.
+func decodeDelta(p []byte, cp int) (uint16, int, bool) {
+	if cp >= len(p) {
+		return 0, cp, false
+	}
+	if p[cp] == 0 {
+		if cp+3 > len(p) {
+			return 0, cp, false
+		}
+		return binary.BigEndian.Uint16(p[cp+1:]), cp + 3, true
+	}
+	return uint16(p[cp]), cp + 1, true
+}
+
+// ipChecksum returns the internet checksum of hdr.
+func ipChecksum(hdr []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(hdr); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(hdr[i:]))
+	}
+	sum = (sum & 0xffff) + (sum >> 16)
+	sum = (sum & 0xffff) + (sum >> 16)
+	return ^uint16(sum)
+}
+
+// errTossed is returned for compressed packets dropped after an error
+var errTossed = errors.New("slip: CSLIP packet tossed")
+
+// uncompress reconstructs the IPv4 datagram of packet p. As in RFC
+// 1144 every first byte below TYPE_UNCOMPRESSED_TCP is TYPE_IP, so
+// datagrams the compressor sent unchanged, e.g. IPv6, pass through
+// without touching the connection state.
+func (d *decompressor) uncompress(p []byte) ([]byte, error) {
+	if len(p) == 0 {
+		return nil, d.bad()
+	}
+	switch {
+	case p[0]&TYPE_COMPRESSED_TCP != 0:
+		return d.uncompressCompressed(p)
+	case p[0] >= TYPE_UNCOMPRESSED_TCP:
+		return d.uncompressUncompressed(p)
+	}
+	return p, nil
+}
+
+func (d *decompressor) bad() error {
+	d.toss = true
+	return ErrBadCompressedPacket
+}
+
+func (d *decompressor) uncompressUncompressed(p []byte) ([]byte, error) {
+	ip := append([]byte(nil), p...)
+	ip[0] &= 0x4f
+	if len(ip) < 40 {
+		return nil, d.bad()
+	}
+	ipHL := int(ip[0]&0x0f) << 2
+	if ipHL < 20 || len(ip) < ipHL+20 {
+		return nil, d.bad()
+	}
+	hlen := ipHL + int(ip[ipHL+12]>>4)<<2
+	if hlen > len(ip) || hlen > cslipMaxHeader || ip[9] >= cslipMaxStates {
+		return nil, d.bad()
+	}
+
+	cs := &d.states[ip[9]]
+	d.lastRecv = int(ip[9])
+	d.toss = false
+	ip[9] = ipProtoTCP
+	cs.hlen = copy(cs.hdr[:], ip[:hlen])
+	cs.hdr[10], cs.hdr[11] = 0, 0
+	return ip, nil
+}
+
+func (d *decompressor) uncompressCompressed(p []byte) ([]byte, error) {
+	changes := p[0]
+	cp := 1
+	if changes&cslipNewC != 0 {
+		if len(p) < 2 || p[1] >= cslipMaxStates {
+			return nil, d.bad()
+		}
+		d.toss = false
+		d.lastRecv = int(p[1])
+		cp++
+	} else if d.toss {
+		return nil, errTossed
+	}
+
+	cs := &d.states[d.lastRecv]
+	if cs.hlen == 0 || len(p) < cp+2 {
+		return nil, d.bad()
+	}
+	hdr := cs.hdr[:cs.hlen]
+	ipHL := int(hdr[0]&0x0f) << 2
+	th := hdr[ipHL:]
+
+	// Work on a copy so a bad packet leaves the state untouched
+	var tmp [cslipMaxHeader]byte
+	copy(tmp[:], hdr)
+	nhdr := tmp[:cs.hlen]
+	nth := nhdr[ipHL:]
+
+	copy(nth[16:18], p[cp:cp+2])
+	cp += 2
+	if changes&cslipPush != 0 {
+		nth[13] |= tcpPush
+	} else {
+		nth[13] &^= tcpPush
+	}
+
+	data := uint32(binary.BigEndian.Uint16(hdr[2:])) - uint32(cs.hlen)
+	var v uint16
+	var ok bool
+	switch changes & cslipSpecialsMask {
+	case cslipSpecialI:
+		binary.BigEndian.PutUint32(nth[8:], binary.BigEndian.Uint32(th[8:])+data)
+		binary.BigEndian.PutUint32(nth[4:], binary.BigEndian.Uint32(th[4:])+data)
+	case cslipSpecialD:
+		binary.BigEndian.PutUint32(nth[4:], binary.BigEndian.Uint32(th[4:])+data)
+	default:
+		if changes&cslipNewU != 0 {
+			nth[13] |= tcpUrg
+			if v, cp, ok = decodeDelta(p, cp); !ok {
+				return nil, d.bad()
+			}
+			binary.BigEndian.PutUint16(nth[18:], v)
+		} else {
+			nth[13] &^= tcpUrg
+		}
+		if changes&cslipNewW != 0 {
+			if v, cp, ok = decodeDelta(p, cp); !ok {
+				return nil, d.bad()
+			}
+			binary.BigEndian.PutUint16(nth[14:], binary.BigEndian.Uint16(th[14:])+v)
+		}
+		if changes&cslipNewA != 0 {
+			if v, cp, ok = decodeDelta(p, cp); !ok {
+				return nil, d.bad()
+			}
+			binary.BigEndian.PutUint32(nth[8:], binary.BigEndian.Uint32(th[8:])+uint32(v))
+		}
+		if changes&cslipNewS != 0 {
+			if v, cp, ok = decodeDelta(p, cp); !ok {
+				return nil, d.bad()
+			}
+			binary.BigEndian.PutUint32(nth[4:], binary.BigEndian.Uint32(th[4:])+uint32(v))
+		}
+	}
+	if changes&cslipNewI != 0 {
+		if v, cp, ok = decodeDelta(p, cp); !ok {
+			return nil, d.bad()
+		}
+	} else {
+		v = 1
+	}
+	binary.BigEndian.PutUint16(nhdr[4:], binary.BigEndian.Uint16(hdr[4:])+v)
+
+	/* At this point, cp points to the first byte of data in the
+	 * packet. Put the reconstructed header in front of it and
+	 * recompute the IP header checksum.
+	 */
+	total := cs.hlen + len(p) - cp
+	binary.BigEndian.PutUint16(nhdr[2:], uint16(total))
+	copy(hdr, nhdr)
+
+	ip := make([]byte, total)
+	copy(ip, hdr)
+	copy(ip[cs.hlen:], p[cp:])
+	binary.BigEndian.PutUint16(ip[10:], ipChecksum(ip[:ipHL]))
+	return ip, nil
+}
+
+// CSLIPWriter writes IPv4 datagrams over SLIP with Van Jacobson TCP/IP
+// header compression (CSLIP, RFC 1144). TCP headers are compressed
+// against the state of up to 16 connections, all other datagrams are
+// sent unchanged.
+type CSLIPWriter struct {
+	mu   sync.Mutex
+	w    *Writer
+	comp *compressor
+}
+
+// NewCSLIPWriter returns a new CSLIPWriter writing to writer. It keeps
+// the last header sent on each of 16 connection slots and the slot
+// used last; opts are the options of NewWriter and apply to the
+// underlying SLIP framing.
+func NewCSLIPWriter(writer io.Writer, opts ...WriterOption) *CSLIPWriter {
+	return &CSLIPWriter{
+		w:    NewWriter(writer, opts...),
+		comp: newCompressor(),
+	}
+}
+
+// WritePacket compresses the IPv4 datagram ip and writes it as one
+// SLIP packet. ip is not modified.
+func (s *CSLIPWriter) WritePacket(ip []byte) error {
+	// The connection state must follow the order on the wire
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.WritePacket(s.comp.compress(ip))
+}
+
+// CSLIPReader reads IPv4 datagrams written by a CSLIPWriter and
+// reconstructs the compressed TCP/IP headers.
+type CSLIPReader struct {
+	mu     sync.Mutex
+	r      *Reader
+	decomp decompressor
+}
+
+// NewCSLIPReader returns a new CSLIPReader reading from reader. It
+// keeps the last header received on each connection slot, the slot
+// used last and whether packets are tossed after an error; opts are
+// the options of NewReader and apply to the underlying SLIP framing.
+func NewCSLIPReader(reader io.Reader, opts ...ReaderOption) *CSLIPReader {
+	return &CSLIPReader{
+		r: NewReader(reader, opts...),
+	}
+}
+
+// ReadPacket reads the next datagram. Compressed packets which follow
+// a lost or damaged packet of the same connection cannot be
+// reconstructed; they are skipped until the sender transmits the
+// connection state again.
+func (s *CSLIPReader) ReadPacket() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for {
+		p, _, err := s.r.ReadPacket()
+		if err != nil {
+			if errors.Is(err, ErrPacketTooLarge) || errors.Is(err, ErrInvalidEscape) {
+				// A packet was lost, the next deltas would be wrong
+				s.decomp.toss = true
+			}
+			return nil, err
+		}
+
+		ip, err := s.decomp.uncompress(p)
+		if err == errTossed {
+			continue
+		}
+		return ip, err
+	}
+}
@@ -0,0 +1,208 @@
+package slip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strconv"
+	"testing"
+)
+
+// tcpPacket builds an IPv4/TCP datagram with a valid IP header checksum.
+func tcpPacket(id uint16, srcPort uint16, seq, ack uint32, flags byte, win uint16, data []byte) []byte {
+	p := make([]byte, 40+len(data))
+	p[0] = 0x45
+	binary.BigEndian.PutUint16(p[2:], uint16(len(p)))
+	binary.BigEndian.PutUint16(p[4:], id)
+	p[6] = 0x40 // Don't fragment
+	p[8] = 64
+	p[9] = ipProtoTCP
+	copy(p[12:16], []byte{10, 0, 0, 1})
+	copy(p[16:20], []byte{10, 0, 0, 2})
+	binary.BigEndian.PutUint16(p[10:], ipChecksum(p[:20]))
+
+	th := p[20:]
+	binary.BigEndian.PutUint16(th[0:], srcPort)
+	binary.BigEndian.PutUint16(th[2:], 23)
+	binary.BigEndian.PutUint32(th[4:], seq)
+	binary.BigEndian.PutUint32(th[8:], ack)
+	th[12] = 5 << 4
+	th[13] = flags
+	binary.BigEndian.PutUint16(th[14:], win)
+	binary.BigEndian.PutUint16(th[16:], 0xbeef) // not verified
+	copy(p[40:], data)
+	return p
+}
+
+var cslipData = []struct {
+	packet []byte
+	typ    byte // expected packet type on the wire
+	size   int  // expected size on the wire, 0 to not check
+}{
+	// A new connection is sent uncompressed
+	{tcpPacket(1, 1000, 100, 500, tcpAck, 4096, []byte("a")), TYPE_UNCOMPRESSED_TCP, 41},
+	// Unidirectional data: change byte and checksum only, the
+	// connection was set by the uncompressed packet
+	{tcpPacket(2, 1000, 101, 500, tcpAck, 4096, []byte("bc")), TYPE_COMPRESSED_TCP, 3 + 2},
+	{tcpPacket(3, 1000, 103, 500, tcpAck|tcpPush, 4096, []byte("d")), TYPE_COMPRESSED_TCP, 3 + 1},
+	// Ack and window changed
+	{tcpPacket(4, 1000, 104, 510, tcpAck, 4000, nil), TYPE_COMPRESSED_TCP, 0},
+	// Different IP ID increment
+	{tcpPacket(10, 1000, 104, 510, tcpAck, 4000, []byte("e")), TYPE_COMPRESSED_TCP, 0},
+	// Large deltas
+	{tcpPacket(11, 1000, 1104, 1510, tcpAck, 1000, []byte("f")), TYPE_COMPRESSED_TCP, 0},
+	// Urgent data
+	{tcpPacket(12, 1000, 1105, 1510, tcpAck|tcpUrg, 1000, []byte("g")), TYPE_COMPRESSED_TCP, 0},
+	// A second connection gets its own slot
+	{tcpPacket(1, 2000, 7, 9, tcpAck, 512, []byte("x")), TYPE_UNCOMPRESSED_TCP, 0},
+	{tcpPacket(2, 2000, 8, 9, tcpAck, 512, []byte("y")), TYPE_COMPRESSED_TCP, 0},
+	// Back to the first connection, the connection number is sent again
+	{tcpPacket(13, 1000, 1106, 1510, tcpAck|tcpUrg, 1000, []byte("h")), TYPE_COMPRESSED_TCP, 0},
+	// Clearing URG is sent uncompressed
+	{tcpPacket(14, 1000, 1107, 1510, tcpAck, 1000, []byte("i")), TYPE_UNCOMPRESSED_TCP, 0},
+	{tcpPacket(15, 1000, 1108, 1510, tcpAck, 1000, []byte("j")), TYPE_COMPRESSED_TCP, 4},
+	// SYN packets are not compressed
+	{tcpPacket(1, 3000, 0, 0, tcpSyn, 512, nil), TYPE_IP, 40},
+	// Neither are other protocols
+	{[]byte{0x45, 0, 0, 20, 0, 0, 0, 0, 64, 17, 0, 0, 10, 0, 0, 1, 10, 0, 0, 2}, TYPE_IP, 20},
+	// Retransmissions are sent uncompressed
+	{tcpPacket(15, 1000, 1108, 1510, tcpAck, 1000, []byte("j")), TYPE_UNCOMPRESSED_TCP, 0},
+}
+
+func cslipType(p []byte) byte {
+	switch {
+	case p[0]&TYPE_COMPRESSED_TCP != 0:
+		return TYPE_COMPRESSED_TCP
+	case p[0]&0xf0 == TYPE_UNCOMPRESSED_TCP:
+		return TYPE_UNCOMPRESSED_TCP
+	}
+	return TYPE_IP
+}
+
+func TestCSLIPRoundtrip(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := NewCSLIPWriter(buf)
+	r := NewCSLIPReader(buf)
+	raw := NewReader(bytes.NewReader(nil))
+
+	for i, test := range cslipData {
+		orig := append([]byte(nil), test.packet...)
+		if err := w.WritePacket(test.packet); err != nil {
+			t.Fatal(strconv.Itoa(i), "Unexpected error", err)
+		}
+		if !eqBytes(orig, test.packet) {
+			t.Error(strconv.Itoa(i), "Expected input to be unchanged but got", test.packet)
+		}
+
+		raw.Reset(bytes.NewReader(buf.Bytes()))
+		wire, _, err := raw.ReadPacket()
+		if err != nil {
+			t.Fatal(strconv.Itoa(i), "Unexpected error", err)
+		}
+		if typ := cslipType(wire); typ != test.typ {
+			t.Error(strconv.Itoa(i), "Expected type", test.typ, "but got", typ)
+		}
+		if test.size > 0 && len(wire) != test.size {
+			t.Error(strconv.Itoa(i), "Expected wire size", test.size, "but got", len(wire), wire)
+		}
+
+		p, err := r.ReadPacket()
+		if err != nil {
+			t.Fatal(strconv.Itoa(i), "Unexpected error", err)
+		}
+		if test.typ == TYPE_COMPRESSED_TCP {
+			// The TCP checksum is carried, the IP checksum recomputed
+			if ipChecksum(p[:20]) != 0 {
+				t.Error(strconv.Itoa(i), "Expected valid IP header checksum")
+			}
+		}
+		if !eqBytes(test.packet, p) {
+			t.Error(strconv.Itoa(i), "Expected data", test.packet, "but got", p)
+		}
+	}
+}
+
+func TestCSLIPToss(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := NewCSLIPWriter(buf)
+
+	p1 := tcpPacket(1, 1000, 100, 500, tcpAck, 4096, []byte("a"))
+	p2 := tcpPacket(2, 1000, 101, 500, tcpAck, 4096, []byte("b"))
+	p3 := tcpPacket(3, 1000, 102, 500, tcpAck, 4096, []byte("c"))
+	for _, p := range [][]byte{p1, p2, p3} {
+		if err := w.WritePacket(p); err != nil {
+			t.Fatal("Unexpected error", err)
+		}
+	}
+	other := tcpPacket(1, 2000, 7, 9, tcpAck, 512, nil)
+	w.WritePacket(other)
+
+	// Damage the second packet with an invalid escape
+	wire := buf.Bytes()
+	first := bytes.IndexByte(wire[1:], END) + 1
+	data := append(append(append([]byte(nil), wire[:first+2]...), ESC, 1), wire[first+2:]...)
+
+	r := NewCSLIPReader(bytes.NewReader(data), WithStrictDecoding(true))
+	p, err := r.ReadPacket()
+	if err != nil || !eqBytes(p1, p) {
+		t.Fatal("Expected", p1, "but got", p, err)
+	}
+	if _, err = r.ReadPacket(); !errors.Is(err, ErrInvalidEscape) {
+		t.Fatal("Expected error", ErrInvalidEscape, "but got", err)
+	}
+	// p3 is tossed, its deltas are relative to the lost p2
+	p, err = r.ReadPacket()
+	if err != nil || !eqBytes(other, p) {
+		t.Fatal("Expected", other, "but got", p, err)
+	}
+}
+
+func TestCSLIPOtherDatagrams(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := NewCSLIPWriter(buf)
+	ipv6 := make([]byte, 48)
+	ipv6[0] = 0x60
+	ipv6[6] = 17
+	packets := [][]byte{
+		tcpPacket(1, 1000, 100, 500, tcpAck, 4096, []byte("a")),
+		ipv6,
+		// Still compressed against the first packet
+		tcpPacket(2, 1000, 101, 500, tcpAck, 4096, []byte("b")),
+		{0x10, 1, 2},
+		tcpPacket(3, 1000, 102, 500, tcpAck, 4096, []byte("c")),
+	}
+	for i, p := range packets {
+		if err := w.WritePacket(p); err != nil {
+			t.Fatal(strconv.Itoa(i), "Unexpected error", err)
+		}
+	}
+
+	r := NewCSLIPReader(buf)
+	for i, expected := range packets {
+		p, err := r.ReadPacket()
+		if err != nil || !eqBytes(expected, p) {
+			t.Error(strconv.Itoa(i), "Expected data", expected, "but got", p, err)
+		}
+	}
+}
+
+func TestCSLIPBadPacket(t *testing.T) {
+	data := [][]byte{
+		// Unknown connection
+		{TYPE_COMPRESSED_TCP | cslipNewC, cslipMaxStates, 0, 0},
+		// Connection without state
+		{TYPE_COMPRESSED_TCP | cslipNewC, 3, 0, 0},
+		// Truncated
+		{TYPE_COMPRESSED_TCP | cslipNewC},
+		// Uncompressed TCP too short
+		{TYPE_UNCOMPRESSED_TCP | 0x05, 0, 0, 20},
+	}
+	for i, d := range data {
+		buf := &bytes.Buffer{}
+		NewWriter(buf).WritePacket(d)
+		r := NewCSLIPReader(buf)
+		if _, err := r.ReadPacket(); err != ErrBadCompressedPacket {
+			t.Error(strconv.Itoa(i), "Expected error", ErrBadCompressedPacket, "but got", err)
+		}
+	}
+}
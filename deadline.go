@@ -0,0 +1,169 @@
+package slip
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// ErrDeadlineNotSupported is returned when setting a deadline on a
+// stream that has no deadlines.
+var ErrDeadlineNotSupported = errors.New("slip: deadlines not supported by the underlying stream")
+
+// readDeadliner is implemented by readers like net.Conn and os.File
+// that can interrupt a blocked Read.
+type readDeadliner interface {
+	SetReadDeadline(t time.Time) error
+}
+
+// writeDeadliner is implemented by writers like net.Conn and os.File
+// that can interrupt a blocked Write.
+type writeDeadliner interface {
+	SetWriteDeadline(t time.Time) error
+}
+
+// ErrFrameTimeout is returned by a Reader with WithInterByteTimeout
+// when a packet stalled for longer than the timeout. The bytes received
+// so far are dropped.
+var ErrFrameTimeout = errors.New("slip: packet timed out between bytes")
+
+// extendDeadline moves the read deadline of the underlying reader
+// before a Read, see WithInterByteTimeout. A deadline of
+// ReadPacketTimeout, SetReadDeadline or a cancelled ReadPacketContext
+// that comes earlier is kept.
+func (s *Reader) extendDeadline() {
+	if s.byteTimeout <= 0 {
+		return
+	}
+	if d, ok := s.r.(readDeadliner); ok {
+		s.byteDeadline = time.Now().Add(s.byteTimeout)
+		deadline := s.byteDeadline
+		if !s.timeout.IsZero() && s.timeout.Before(deadline) {
+			deadline = s.timeout
+		}
+		s.setDeadline(d, deadline)
+	}
+}
+
+// setDeadline sets the read deadline of d to t, or to the deadline
+// recorded by SetReadDeadline if that is earlier. It sets it again if
+// SetReadDeadline was called in the meantime, so a cancellation is
+// never overwritten.
+func (s *Reader) setDeadline(d readDeadliner, t time.Time) {
+	for {
+		u := atomic.LoadInt64(&s.rdeadline)
+		deadline := t
+		if u != 0 && (t.IsZero() || time.Unix(0, u).Before(t)) {
+			deadline = time.Unix(0, u)
+		}
+		d.SetReadDeadline(deadline)
+		if atomic.LoadInt64(&s.rdeadline) == u {
+			return
+		}
+	}
+}
+
+// frameTimedOut reports whether err is the timeout of the deadline set
+// by extendDeadline, not of one set by SetReadDeadline or a cancelled
+// ReadPacketContext.
+func (s *Reader) frameTimedOut(err error) bool {
+	if s.byteTimeout <= 0 || s.byteDeadline.IsZero() {
+		return false
+	}
+	return isTimeout(err) && !time.Now().Before(s.byteDeadline)
+}
+
+func isTimeout(err error) bool {
+	t, ok := err.(interface{ Timeout() bool })
+	return ok && t.Timeout()
+}
+
+// ErrTimeout is returned by ReadPacketTimeout when no packet arrived in
+// time.
+var ErrTimeout = errors.New("slip: read timed out")
+
+// ReadPacketTimeout reads the next complete packet like ReadPacket but
+// returns ErrTimeout if it did not arrive within d. The packet is nil
+// on an error.
+//
+// If the underlying reader has a SetReadDeadline method, as net.Conn
+// does, the read deadline is set to d from now for the call and cleared
+// afterwards, replacing one set with SetReadDeadline; the bytes of a
+// packet received in time are kept for the next read, and the packet is
+// newly allocated unless WithCopyOnRead(false) is set. Otherwise the
+// read runs in a separate goroutine like ReadPacketContext and the
+// packet is always newly allocated. A read that timed out there, or in
+// ReadPacketContext, is abandoned and completed by the next call to
+// ReadPacketContext or ReadPacketTimeout before anything else is read.
+func (s *Reader) ReadPacketTimeout(d time.Duration) ([]byte, error) {
+	s.pmu.Lock()
+	abandoned := s.pending != nil
+	s.pmu.Unlock()
+
+	dl, ok := s.r.(readDeadliner)
+	if !ok || abandoned {
+		ctx, cancel := context.WithTimeout(context.Background(), d)
+		defer cancel()
+		p, err := s.ReadPacketContext(ctx)
+		if err == context.DeadlineExceeded {
+			err = ErrTimeout
+		}
+		return p, err
+	}
+
+	s.lock()
+	defer s.unlock()
+	if s.closed {
+		return nil, ErrClosed
+	}
+	atomic.StoreInt64(&s.rdeadline, 0)
+	s.timeout = time.Now().Add(d)
+	dl.SetReadDeadline(s.timeout)
+	p, _, err := s.read(s.packetBuffer(), s.maxPacketSize())
+	s.timeout = time.Time{}
+	dl.SetReadDeadline(time.Time{})
+	p = s.keepPacket(p, err)
+	if err != nil {
+		if isTimeout(err) {
+			err = ErrTimeout
+		}
+		return nil, err
+	}
+	return p, nil
+}
+
+// SetReadDeadline sets the read deadline of the underlying reader.
+// It does not take the lock of the Reader, so it can interrupt a
+// blocked ReadPacket. It returns ErrDeadlineNotSupported if the
+// underlying reader has no SetReadDeadline method.
+//
+// The deadline is recorded, so the deadline of WithInterByteTimeout and
+// WithCoalesceWindow does not move it to later.
+func (s *Reader) SetReadDeadline(t time.Time) error {
+	d, ok := s.r.(readDeadliner)
+	if !ok {
+		return ErrDeadlineNotSupported
+	}
+	var n int64
+	if !t.IsZero() {
+		n = t.UnixNano()
+	}
+	old := atomic.SwapInt64(&s.rdeadline, n)
+	err := d.SetReadDeadline(t)
+	if err != nil {
+		atomic.CompareAndSwapInt64(&s.rdeadline, n, old)
+	}
+	return err
+}
+
+// SetWriteDeadline sets the write deadline of the underlying writer.
+// It does not take the lock of the Writer, so it can interrupt a
+// blocked WritePacket. It returns ErrDeadlineNotSupported if the
+// underlying writer has no SetWriteDeadline method.
+func (s *Writer) SetWriteDeadline(t time.Time) error {
+	if d, ok := s.w.(writeDeadliner); ok {
+		return d.SetWriteDeadline(t)
+	}
+	return ErrDeadlineNotSupported
+}
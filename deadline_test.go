@@ -0,0 +1,280 @@
+package slip
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func TestSetReadDeadline(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+
+	r := NewReader(c1)
+	if err := r.SetReadDeadline(time.Now().Add(10 * time.Millisecond)); err != nil {
+		t.Error("Unexpected error:", err)
+	}
+	_, _, err := r.ReadPacket()
+	if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+		t.Error("Expected timeout but got", err)
+	}
+}
+
+func TestSetWriteDeadline(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+
+	// Nobody reads from c2, so the write blocks until the deadline
+	w := NewWriter(c1)
+	if err := w.SetWriteDeadline(time.Now().Add(10 * time.Millisecond)); err != nil {
+		t.Error("Unexpected error:", err)
+	}
+	err := w.WritePacket([]byte{1})
+	if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+		t.Error("Expected timeout but got", err)
+	}
+}
+
+func TestDeadlineNotSupported(t *testing.T) {
+	if err := NewReader(&bytes.Buffer{}).SetReadDeadline(time.Now()); err != ErrDeadlineNotSupported {
+		t.Error("Expected error", ErrDeadlineNotSupported, "but got", err)
+	}
+	if err := NewWriter(&bytes.Buffer{}).SetWriteDeadline(time.Now()); err != ErrDeadlineNotSupported {
+		t.Error("Expected error", ErrDeadlineNotSupported, "but got", err)
+	}
+}
+
+func TestInterByteTimeout(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	r := NewReader(c1, WithInterByteTimeout(50*time.Millisecond))
+
+	// A packet trickling in slower than the timeout in total is read
+	go func() {
+		for _, b := range []byte{END, 1, 2, 3, 4, END} {
+			time.Sleep(20 * time.Millisecond)
+			c2.Write([]byte{b})
+		}
+	}()
+	p, _, err := r.ReadPacket()
+	if err != nil || !eqBytes([]byte{1, 2, 3, 4}, p) {
+		t.Error("Expected data", []byte{1, 2, 3, 4}, "but got", p, err)
+	}
+
+	// A stalled packet is dropped
+	go c2.Write([]byte{END, 5, 6})
+	if p, _, err = r.ReadPacket(); err != ErrFrameTimeout || len(p) != 0 {
+		t.Error("Expected error", ErrFrameTimeout, "but got", p, err)
+	}
+	if s := r.Stats(); s.Errors != 1 {
+		t.Error("Expected 1 error but got", s.Errors)
+	}
+
+	// An idle link times out between packets
+	_, _, err = r.ReadPacket()
+	if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+		t.Error("Expected timeout but got", err)
+	}
+
+	go c2.Write([]byte{7, END})
+	if p, _, err = r.ReadPacket(); err != nil || !eqBytes([]byte{7}, p) {
+		t.Error("Expected data", []byte{7}, "but got", p, err)
+	}
+}
+
+func TestInterByteTimeoutKeepsDeadline(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	r := NewReader(c1, WithInterByteTimeout(time.Second))
+
+	// Data keeps arriving, so only an earlier deadline ends the read
+	go func() {
+		c2.Write([]byte{END})
+		for {
+			time.Sleep(2 * time.Millisecond)
+			if _, err := c2.Write([]byte{1}); err != nil {
+				return
+			}
+		}
+	}()
+	r.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	start := time.Now()
+	if _, _, err := r.ReadPacket(); !isTimeout(err) {
+		t.Error("Expected timeout but got", err)
+	}
+	r.SetReadDeadline(time.Time{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := r.ReadPacketContext(ctx); err != context.DeadlineExceeded {
+		t.Error("Expected error", context.DeadlineExceeded, "but got", err)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Error("Expected the deadlines to end the reads but they took", d)
+	}
+}
+
+func TestReadPacketTimeout(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	r := NewReader(c1)
+
+	// The bytes received in time are kept
+	go c2.Write([]byte{END, 1})
+	if p, err := r.ReadPacketTimeout(20 * time.Millisecond); err != ErrTimeout || p != nil {
+		t.Error("Expected error", ErrTimeout, "but got", p, err)
+	}
+	go c2.Write([]byte{2, END})
+	p, err := r.ReadPacketTimeout(time.Second)
+	if err != nil || !eqBytes([]byte{1, 2}, p) {
+		t.Error("Expected data", []byte{1, 2}, "but got", p, err)
+	}
+
+	// The deadline is cleared afterwards
+	go func() {
+		time.Sleep(30 * time.Millisecond)
+		c2.Write([]byte{3, END})
+	}()
+	if p, _, err = r.ReadPacket(); err != nil || !eqBytes([]byte{3}, p) {
+		t.Error("Expected data", []byte{3}, "but got", p, err)
+	}
+}
+
+func TestReadPacketTimeoutGoroutine(t *testing.T) {
+	pr, pw := io.Pipe()
+	r := NewReader(pr)
+	if p, err := r.ReadPacketTimeout(10 * time.Millisecond); err != ErrTimeout || p != nil {
+		t.Error("Expected error", ErrTimeout, "but got", p, err)
+	}
+
+	// The abandoned read is picked up by the next call
+	go pw.Write([]byte{4, END})
+	p, err := r.ReadPacketTimeout(time.Second)
+	if err != nil || !eqBytes([]byte{4}, p) {
+		t.Error("Expected data", []byte{4}, "but got", p, err)
+	}
+}
+
+// stuckDeadlineReader has a SetReadDeadline method that fails, so a
+// cancelled ReadPacketContext abandons its read
+type stuckDeadlineReader struct {
+	io.Reader
+}
+
+func (stuckDeadlineReader) SetReadDeadline(time.Time) error {
+	return ErrDeadlineNotSupported
+}
+
+func TestReadPacketTimeoutAbandoned(t *testing.T) {
+	pr, pw := io.Pipe()
+	r := NewReader(stuckDeadlineReader{pr})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := r.ReadPacketContext(ctx); err != context.DeadlineExceeded {
+		t.Fatal("Expected error", context.DeadlineExceeded, "but got", err)
+	}
+
+	// The abandoned read comes first
+	go func() {
+		pw.Write([]byte{5, END, 6, END})
+		pw.Close()
+	}()
+	for _, expected := range [][]byte{{5}, {6}} {
+		p, err := r.ReadPacketTimeout(time.Second)
+		if err != nil || !eqBytes(expected, p) {
+			t.Error("Expected data", expected, "but got", p, err)
+		}
+	}
+}
+
+func TestCoalesceWindow(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+
+	var hooked []error
+	r := NewReader(c1, WithCoalesceWindow(50*time.Millisecond), WithReadHook(func(_ []byte, err error) {
+		hooked = append(hooked, err)
+	}))
+	go func() {
+		c2.Write([]byte{END, 1, END})
+		c2.Write([]byte{2, ESC})
+		c2.Write([]byte{ESC_END, END})
+		time.Sleep(200 * time.Millisecond)
+		c2.Write([]byte{3, END})
+	}()
+	for i, expected := range [][]byte{{1, 2, END}, {3}} {
+		p, _, err := r.ReadPacket()
+		if err != nil || !eqBytes(expected, p) {
+			t.Error(strconv.Itoa(i), "Expected data", expected, "but got", p, err)
+		}
+	}
+	// The hook sees the frames, not the end of the window
+	if len(hooked) != 3 || hooked[0] != nil || hooked[1] != nil || hooked[2] != nil {
+		t.Error("Expected 3 hook calls without error but got", hooked)
+	}
+
+	// The deadline is cleared again
+	go c2.Write([]byte{4, END})
+	time.Sleep(100 * time.Millisecond)
+	if p, _, err := r.ReadPacket(); err != nil || !eqBytes([]byte{4}, p) {
+		t.Error("Expected data", []byte{4}, "but got", p, err)
+	}
+}
+
+func TestCoalesceWindowBuffered(t *testing.T) {
+	data := []byte{END, 1, END, 2, END, 3, END}
+	r := NewReader(bytes.NewReader(data), WithCoalesceWindow(time.Second))
+	if p, _, err := r.ReadPacket(); err != nil || !eqBytes([]byte{1, 2, 3}, p) {
+		t.Error("Expected data", []byte{1, 2, 3}, "but got", p, err)
+	}
+
+	// Packets of separate Reads are not joined without deadlines
+	r = NewReader(iotest.OneByteReader(bytes.NewReader(data)), WithCoalesceWindow(time.Second))
+	if p, _, err := r.ReadPacket(); err != nil || !eqBytes([]byte{1}, p) {
+		t.Error("Expected data", []byte{1}, "but got", p, err)
+	}
+
+	// A packet exceeding the limit and errors come on their own
+	data = []byte{1, END, 2, END, 3, 4, END, ESC, 5, END, 6, END}
+	r = NewReader(bytes.NewReader(data), WithCoalesceWindow(time.Second), WithStrictDecoding(true), WithMaxPacketSize(3))
+	for i, expected := range []struct {
+		p   []byte
+		err error
+	}{
+		{[]byte{1, 2}, nil},
+		{[]byte{3, 4}, nil},
+		{nil, ErrInvalidEscape},
+		{[]byte{6}, nil},
+		{nil, io.EOF},
+	} {
+		p, _, err := r.ReadPacket()
+		if !errors.Is(err, expected.err) || !eqBytes(expected.p, p) {
+			t.Error(strconv.Itoa(i), "Expected data", expected.p, expected.err, "but got", p, err)
+		}
+	}
+}
+
+func TestCoalesceWindowWriteTo(t *testing.T) {
+	data := []byte{END, 1, END, 2, END, 3, 4, END, ESC, 5, END, 6, END}
+	r := NewReader(bytes.NewReader(data), WithCoalesceWindow(time.Second),
+		WithStrictDecoding(true), WithMaxPacketSize(3), WithPacketSeparator([]byte{'|'}))
+	buf := &bytes.Buffer{}
+	if _, err := r.WriteTo(buf); !errors.Is(err, ErrInvalidEscape) {
+		t.Error("Expected error", ErrInvalidEscape, "but got", err)
+	}
+	if expected := []byte{1, 2, '|', 3, 4, '|'}; !eqBytes(expected, buf.Bytes()) {
+		t.Error("Expected data", expected, "but got", buf.Bytes())
+	}
+}
@@ -0,0 +1,152 @@
+package slip
+
+import "fmt"
+
+// DecoderState is the state of a Decoder.
+type DecoderState int
+
+const (
+	StateIdle       DecoderState = iota // between packets
+	StateData                           // inside a packet
+	StateEscape                         // inside a packet, right after an ESC
+	StateSkip                           // dropping the rest of a bad packet
+	StateSkipEscape                     // dropping, right after an ESC
+)
+
+var decoderStateNames = [...]string{"Idle", "Data", "Escape", "Skip", "SkipEscape"}
+
+func (st DecoderState) String() string {
+	if st >= 0 && int(st) < len(decoderStateNames) {
+		return decoderStateNames[st]
+	}
+	return fmt.Sprintf("DecoderState(%d)", int(st))
+}
+
+// Decoder decodes SLIP one byte at a time, for callers that run their
+// own read loop or event loop instead of handing an io.Reader to a
+// Reader. It decodes exactly like a Reader with the same options.
+type Decoder struct {
+	codec
+	max    int  // maximum packet size, zero for no limit
+	strict bool // report invalid escape sequences
+	empty  bool // return empty packets
+
+	state DecoderState
+	frame []byte
+}
+
+// NewDecoder returns a Decoder configured by the Reader options that
+// apply to decoding: WithControlBytes, WithCRC16, WithMaxPacketSize,
+// WithStrictDecoding and WithKeepEmptyFrames. Other options are
+// ignored, except for WithWireChecksum: its checksum covers the whole
+// stuffed packet, which a Decoder does not keep, so NewDecoder panics.
+func NewDecoder(opts ...ReaderOption) *Decoder {
+	s := &Reader{codec: defaultCodec}
+	for _, opt := range opts {
+		opt.applyReader(s)
+	}
+	s.validate()
+	if s.wire != nil {
+		panic("slip: WithWireChecksum is not supported by Decoder")
+	}
+	return &Decoder{
+		codec:  s.codec,
+		max:    s.MaxPacketSize,
+		strict: s.strict,
+		empty:  s.empty,
+	}
+}
+
+// Feed advances the decoder by the wire byte b. When b completes a
+// packet, Feed returns it with complete set; the packet aliases the
+// buffer of d and is overwritten by the following calls, so callers
+// who keep it must copy it. A bad packet is reported once with
+// ErrPacketTooLarge, an InvalidEscapeError or a ChecksumError, and
+// the rest of it is dropped up to its END.
+func (d *Decoder) Feed(b byte) (frame []byte, complete bool, err error) {
+	switch d.state {
+	case StateSkip:
+		switch b {
+		case d.end:
+			d.state = StateIdle
+		case d.esc:
+			d.state = StateSkipEscape
+		}
+		return nil, false, nil
+
+	case StateSkipEscape:
+		d.state = StateSkip
+		if b == d.end && !d.strict {
+			d.state = StateIdle
+		}
+		return nil, false, nil
+
+	case StateEscape:
+		d.state = StateData
+		if c, ok := d.unescape(b); ok {
+			b = c
+		} else if d.strict {
+			err = &InvalidEscapeError{Byte: b, Offset: len(d.frame)}
+			return nil, false, d.drop(b != d.end, err)
+		} else if b == d.end {
+			return d.finish()
+		}
+
+	default:
+		switch b {
+		case d.end:
+			return d.finish()
+		case d.esc:
+			d.state = StateEscape
+			return nil, false, nil
+		}
+		d.state = StateData
+	}
+
+	if d.max > 0 && len(d.frame) >= d.max {
+		return nil, false, d.drop(true, ErrPacketTooLarge)
+	}
+	d.frame = append(d.frame, b)
+	return nil, false, nil
+}
+
+// finish ends the current packet at an END.
+func (d *Decoder) finish() ([]byte, bool, error) {
+	d.state = StateIdle
+	p := d.frame
+	d.frame = d.frame[:0]
+	if len(p) > 0 && d.crc != nil {
+		var err error
+		if p, err = d.crc.verify(p); err != nil {
+			return nil, false, err
+		}
+	}
+	if len(p) == 0 && !d.empty {
+		return nil, false, nil
+	}
+	return p, true, nil
+}
+
+// drop discards the current packet and reports err, skipping to its
+// END if skip is set.
+func (d *Decoder) drop(skip bool, err error) error {
+	d.frame = d.frame[:0]
+	d.state = StateIdle
+	if skip {
+		d.state = StateSkip
+	}
+	return err
+}
+
+// State returns the current state of d, e.g. to detect an unfinished
+// packet at the end of the input.
+func (d *Decoder) State() DecoderState {
+	return d.state
+}
+
+// Reset drops the unfinished packet, if any, and returns d to
+// StateIdle.
+func (d *Decoder) Reset() {
+	d.frame = d.frame[:0]
+	d.state = StateIdle
+}
@@ -0,0 +1,121 @@
+package slip
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"testing"
+)
+
+// feed passes data to d and collects the complete packets.
+func feed(d *Decoder, data []byte) (packets [][]byte, errs []error) {
+	for _, b := range data {
+		p, complete, err := d.Feed(b)
+		if complete {
+			packets = append(packets, append([]byte(nil), p...))
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return
+}
+
+func TestDecoder(t *testing.T) {
+	for i, d := range readData {
+		dec := NewDecoder()
+		packets, errs := feed(dec, d.data)
+		if len(errs) != 0 {
+			t.Error(strconv.Itoa(i), "Unexpected errors", errs)
+		}
+		if d.err == nil {
+			if len(packets) != 1 || !eqBytes(packets[0], d.expected) {
+				t.Error(strconv.Itoa(i), "Expected data", d.expected, "but got", packets)
+			}
+		} else if len(packets) != 0 {
+			t.Error(strconv.Itoa(i), "Expected no packets but got", packets)
+		}
+		if d.isPrefix && dec.State() == StateIdle {
+			t.Error(strconv.Itoa(i), "Expected an unfinished packet but got state", dec.State())
+		}
+	}
+}
+
+func TestDecoderMatchesReader(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf, WithCRC16(CRC16CCITT))
+	for _, p := range crcPackets {
+		w.WritePacket(p)
+	}
+	data := append(buf.Bytes(), END, 1, ESC, 2, END, 1, 2, 3, 4, 5, 6, 7, 8, END)
+
+	opts := []ReaderOption{WithCRC16(CRC16CCITT), WithStrictDecoding(true), WithMaxPacketSize(6)}
+	packets, errs := feed(NewDecoder(opts...), data)
+
+	r := NewReader(bytes.NewReader(data), opts...)
+	var rpackets [][]byte
+	var rerrs []error
+	for {
+		p, _, err := r.ReadPacket()
+		if err == nil {
+			rpackets = append(rpackets, p)
+		} else if isProtocolError(err) {
+			rerrs = append(rerrs, err)
+		} else {
+			break
+		}
+	}
+
+	if len(packets) != len(rpackets) {
+		t.Fatal("Expected packets", rpackets, "but got", packets)
+	}
+	for i := range packets {
+		if !eqBytes(packets[i], rpackets[i]) {
+			t.Error(strconv.Itoa(i), "Expected data", rpackets[i], "but got", packets[i])
+		}
+	}
+	if len(errs) != len(rerrs) {
+		t.Fatal("Expected errors", rerrs, "but got", errs)
+	}
+	for i := range errs {
+		if errs[i].Error() != rerrs[i].Error() {
+			t.Error(strconv.Itoa(i), "Expected error", rerrs[i], "but got", errs[i])
+		}
+	}
+}
+
+func TestDecoderStates(t *testing.T) {
+	d := NewDecoder(WithStrictDecoding(true))
+	steps := []struct {
+		b     byte
+		state DecoderState
+	}{
+		{END, StateIdle},
+		{1, StateData},
+		{ESC, StateEscape},
+		{ESC_END, StateData},
+		{ESC, StateEscape},
+		{3, StateSkip},
+		{ESC, StateSkipEscape},
+		{END, StateSkip},
+		{END, StateIdle},
+	}
+	for i, step := range steps {
+		_, _, err := d.Feed(step.b)
+		if st := d.State(); st != step.state {
+			t.Error(strconv.Itoa(i), "Expected state", step.state, "but got", st)
+		}
+		if i == 5 && !errors.Is(err, ErrInvalidEscape) {
+			t.Error(strconv.Itoa(i), "Expected error", ErrInvalidEscape, "but got", err)
+		}
+	}
+
+	d.Feed(1)
+	d.Reset()
+	if p, complete, _ := d.Feed(END); complete || d.State() != StateIdle {
+		t.Error("Expected the packet to be dropped but got", p, complete)
+	}
+	if s := DecoderState(7).String(); s != "DecoderState(7)" {
+		t.Error("Expected DecoderState(7) but got", s)
+	}
+}
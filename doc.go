@@ -0,0 +1,43 @@
+// Package slip implements the framing of RFC 1055, a Nonstandard for
+// transmission of IP datagrams over serial lines, and SlipMux on top
+// of it.
+//
+// Every payload, including one made of END, ESC, ESC_END and ESC_ESC
+// bytes only, is read back byte for byte by ReadPacket, Decode and a
+// Decoder after WritePacket, Encode or an Encoder: only END and ESC are
+// escaped, ESC_END and ESC_ESC are passed through as they are and only
+// have a meaning right after an ESC.
+//
+// # Errors
+//
+// The decoder is total: every input either yields packets or one of
+// the following errors, and a Reader never loops without consuming
+// input, only retrying empty reads a bounded number of times.
+//
+//   - io.EOF: the stream ended between packets.
+//   - io.ErrUnexpectedEOF: the stream ended inside a packet.
+//   - ErrIncompleteEscape: the stream ended right after an ESC.
+//   - ErrPacketTooLarge: a packet exceeded MaxPacketSize, the rest of
+//     it is dropped. Without a limit a frame never terminated by an END
+//     grows without bound, so a Reader on untrusted input should set one.
+//   - InvalidEscapeError, matching ErrInvalidEscape: an ESC followed by
+//     anything but ESC_END or ESC_ESC, including a raw END, in strict
+//     mode or from Decode. Without WithStrictDecoding the byte is stored
+//     as is, and a raw END after the ESC ends the packet without it.
+//   - ChecksumError, matching ErrChecksumMismatch: see WithCRC16 and
+//     WithWireChecksum.
+//   - ErrSuspiciousFrame: see WithMaxEscapeRatio.
+//   - FrameError, matching ErrInvalidFrame: see WithFrameValidator.
+//   - ErrFrameTimeout: see WithInterByteTimeout.
+//   - ErrMissingLeadingEnd: see WithRequireLeadingEnd.
+//   - ErrReadLimitExceeded: see WithReadLimit.
+//   - ErrBufferTooSmall: the packet did not fit into ReadPacketInto's
+//     destination.
+//
+// ErrPacketTooLarge, ErrInvalidEscape, ErrChecksumMismatch,
+// ErrSuspiciousFrame, ErrInvalidFrame, ErrFrameTimeout and
+// ErrMissingLeadingEnd are protocol errors: the packet is lost but the
+// stream can be read on, see WithAutoResync. The others are errors of
+// the underlying reader, after which a Reader resumes the unfinished
+// packet once more data is available.
+package slip
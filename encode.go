@@ -0,0 +1,652 @@
+package slip
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+var (
+	// ErrInvalidEscape matches every InvalidEscapeError with errors.Is.
+	ErrInvalidEscape = errors.New("slip: invalid escape sequence")
+
+	// ErrIncompleteEscape is returned when the input ends right after an ESC.
+	ErrIncompleteEscape = errors.New("slip: incomplete escape sequence")
+)
+
+// InvalidEscapeError is returned when an ESC is followed by a byte
+// other than ESC_END or ESC_ESC.
+type InvalidEscapeError struct {
+	Byte   byte // the byte following the ESC
+	Offset int  // offset within the decoded packet
+}
+
+func (e *InvalidEscapeError) Error() string {
+	return fmt.Sprintf("slip: invalid escape sequence ESC %#02x at packet offset %d", e.Byte, e.Offset)
+}
+
+// Is reports whether target is ErrInvalidEscape.
+func (e *InvalidEscapeError) Is(target error) bool {
+	return target == ErrInvalidEscape
+}
+
+// codec holds the control bytes used for framing and byte stuffing.
+type codec struct {
+	end, esc, escEnd, escEsc byte
+
+	crc     *crc16Table // checksum trailer of every packet, nil for none
+	noLead  bool        // do not write the leading END of a packet
+	noFrame bool        // no END bytes at all, see WithFramingDisabled
+	flag    []byte      // sent for every END, see WithFlagSequence
+
+	wire func(p []byte) uint16 // checksum of the stuffed bytes, see WithWireChecksum
+
+	extra []byte       // bytes escaped in addition to END and ESC
+	xesc  *escapeTable // escape codes of extra, built by validate
+}
+
+// escapeTable maps the extra bytes of WithExtraEscapedBytes to their
+// escape codes and back.
+type escapeTable struct {
+	special [256]bool // END, ESC and the extra bytes
+	hasCode [256]bool // the extra bytes
+	code    [256]byte // escape code of an extra byte
+	isCode  [256]bool
+	data    [256]byte // extra byte of an escape code
+}
+
+// defaultCodec uses the control bytes of RFC 1055.
+var defaultCodec = codec{end: END, esc: ESC, escEnd: ESC_END, escEsc: ESC_ESC}
+
+// validate panics if the control bytes are not mutually distinct.
+func (c *codec) validate() {
+	if len(c.flag) > 0 {
+		c.end = c.flag[0]
+	}
+	b := []byte{c.end, c.esc, c.escEnd, c.escEsc}
+	for i := range b {
+		for j := i + 1; j < len(b); j++ {
+			if b[i] == b[j] {
+				panic(fmt.Sprintf("slip: control bytes END %#02x, ESC %#02x, ESC_END %#02x, ESC_ESC %#02x are not distinct",
+					c.end, c.esc, c.escEnd, c.escEsc))
+			}
+		}
+	}
+	if len(c.extra) > 0 {
+		c.xesc = c.newEscapeTable()
+	}
+}
+
+// newEscapeTable assigns an escape code to every extra byte. The codes
+// are taken in order from the bytes after ESC_ESC, skipping the control
+// bytes and the extra bytes themselves, which must not appear on the
+// wire, so both peers derive the same codes from the same options.
+// It panics if an extra byte is a control byte or no code is left.
+func (c *codec) newEscapeTable() *escapeTable {
+	t := &escapeTable{}
+	used := [256]bool{}
+	for _, b := range []byte{c.end, c.esc, c.escEnd, c.escEsc} {
+		t.special[b] = b == c.end || b == c.esc
+		used[b] = true
+	}
+	for _, b := range c.extra {
+		if b == c.end || b == c.esc || b == c.escEnd || b == c.escEsc {
+			panic(fmt.Sprintf("slip: extra escaped byte %#02x is a control byte", b))
+		}
+		t.special[b] = true
+		t.hasCode[b] = true
+		used[b] = true
+	}
+
+	code := c.escEsc
+	done := [256]bool{}
+	for _, b := range c.extra {
+		if done[b] {
+			continue // listed twice
+		}
+		done[b] = true
+		for n := 0; used[code]; n++ {
+			if n == 256 {
+				panic(fmt.Sprintf("slip: no free escape code for extra escaped byte %#02x", b))
+			}
+			code++
+		}
+		used[code] = true
+		t.code[b] = code
+		t.isCode[code] = true
+		t.data[code] = b
+	}
+	return t
+}
+
+// leadingEnd reports whether packets start with an END.
+func (c *codec) leadingEnd() bool {
+	return !c.noLead && !c.noFrame
+}
+
+// unescape returns the data byte of the escape sequence ESC b.
+// It returns false if b is neither ESC_END nor ESC_ESC.
+func (c *codec) unescape(b byte) (byte, bool) {
+	switch b {
+	case c.escEnd:
+		return c.end, true
+	case c.escEsc:
+		return c.esc, true
+	}
+	if c.xesc != nil && c.xesc.isCode[b] {
+		return c.xesc.data[b], true
+	}
+	return b, false
+}
+
+// unescapeAt decodes the escape sequence whose ESC precedes src[i] as
+// the strict decoders do: it returns ErrIncompleteEscape if src ends
+// after the ESC and an InvalidEscapeError at packet offset off if
+// src[i] is neither ESC_END nor ESC_ESC.
+func (c *codec) unescapeAt(src []byte, i, off int) (byte, error) {
+	if i == len(src) {
+		return 0, ErrIncompleteEscape
+	}
+	b, ok := c.unescape(src[i])
+	if !ok {
+		return 0, &InvalidEscapeError{Byte: src[i], Offset: off}
+	}
+	return b, nil
+}
+
+// encodeBuffer is implemented by bytes.Buffer and bufio.Writer.
+type encodeBuffer interface {
+	io.Writer
+	io.ByteWriter
+	io.StringWriter
+}
+
+// encodePacket writes the stuffed and framed packet p to buf.
+// Errors of buf are left to the caller, bytes.Buffer never fails
+// and bufio.Writer keeps the first error.
+func (c *codec) encodePacket(buf encodeBuffer, p []byte) {
+	if c.wire != nil {
+		buf.Write(c.appendPacket(nil, p))
+		return
+	}
+
+	/* send an initial END character to flush out any data that may
+	* have accumulated in the receiver due to line noise, unless
+	* we have been asked not to
+	 */
+	if c.leadingEnd() {
+		c.writeEnd(buf)
+	}
+
+	/* for each byte in the packet, send the appropriate character
+	 * sequence
+	 */
+	c.encodeStuffed(buf, p)
+	if c.crc != nil {
+		c.encodeTrailer(buf, c.crc.checksum(p))
+	}
+
+	/* tell the receiver that we're done sending the packet
+	 */
+	if !c.noFrame {
+		c.writeEnd(buf)
+	}
+}
+
+// writeEnd writes an END, or the flag sequence replacing it, to buf.
+func (c *codec) writeEnd(buf encodeBuffer) {
+	if c.flag != nil {
+		buf.Write(c.flag)
+		return
+	}
+	buf.WriteByte(c.end)
+}
+
+// appendEnd is writeEnd for a slice.
+func (c *codec) appendEnd(dst []byte) []byte {
+	if c.flag != nil {
+		return append(dst, c.flag...)
+	}
+	return append(dst, c.end)
+}
+
+// encodeStuffed writes the stuffed bytes of p to buf, without END
+// bytes around them. Runs of bytes that need no stuffing are copied as
+// a whole.
+func (c *codec) encodeStuffed(buf encodeBuffer, p []byte) {
+	for len(p) > 0 {
+		i := c.indexSpecial(p)
+		if i < 0 {
+			buf.Write(p)
+			return
+		}
+		buf.Write(p[:i])
+		c.encodeByte(buf, p[i])
+		p = p[i+1:]
+	}
+}
+
+// encodePacketv is encodePacket for the concatenation of ps. Stuffing
+// does not depend on the neighbouring bytes, so the slices are stuffed
+// one after the other.
+func (c *codec) encodePacketv(buf encodeBuffer, ps [][]byte) {
+	if c.wire != nil {
+		buf.Write(c.appendPacketv(nil, ps))
+		return
+	}
+	if c.leadingEnd() {
+		c.writeEnd(buf)
+	}
+	for _, p := range ps {
+		c.encodeStuffed(buf, p)
+	}
+	if c.crc != nil {
+		c.encodeTrailer(buf, c.crc.checksumv(ps))
+	}
+	if !c.noFrame {
+		c.writeEnd(buf)
+	}
+}
+
+// encodeString is encodePacket for a string packet.
+func (c *codec) encodeString(buf encodeBuffer, str string) {
+	if c.wire != nil {
+		buf.Write(c.appendString(nil, str))
+		return
+	}
+	if c.leadingEnd() {
+		c.writeEnd(buf)
+	}
+	for s := str; len(s) > 0; {
+		i := c.indexSpecialString(s)
+		if i < 0 {
+			buf.WriteString(s)
+			break
+		}
+		buf.WriteString(s[:i])
+		c.encodeByte(buf, s[i])
+		s = s[i+1:]
+	}
+	if c.crc != nil {
+		c.encodeTrailer(buf, c.crc.checksumString(str))
+	}
+	if !c.noFrame {
+		c.writeEnd(buf)
+	}
+}
+
+// encodeTrailer stuffs the checksum crc like payload bytes.
+func (c *codec) encodeTrailer(buf io.ByteWriter, crc uint16) {
+	for _, b := range c.crc.trailer(crc) {
+		c.encodeByte(buf, b)
+	}
+}
+
+// indexSpecial returns the index of the first END or ESC in p, or -1.
+func (c *codec) indexSpecial(p []byte) int {
+	if c.xesc != nil {
+		for i, b := range p {
+			if c.xesc.special[b] {
+				return i
+			}
+		}
+		return -1
+	}
+	return c.indexEndEsc(p)
+}
+
+// indexEndEsc returns the index of the first END or ESC in p, or -1,
+// ignoring the extra escaped bytes.
+func (c *codec) indexEndEsc(p []byte) int {
+	i := bytes.IndexByte(p, c.end)
+	if i < 0 {
+		return bytes.IndexByte(p, c.esc)
+	}
+	if j := bytes.IndexByte(p[:i], c.esc); j >= 0 {
+		return j
+	}
+	return i
+}
+
+// indexSpecialString is indexSpecial for a string.
+func (c *codec) indexSpecialString(s string) int {
+	if c.xesc != nil {
+		for i := 0; i < len(s); i++ {
+			if c.xesc.special[s[i]] {
+				return i
+			}
+		}
+		return -1
+	}
+	i := strings.IndexByte(s, c.end)
+	if i < 0 {
+		return strings.IndexByte(s, c.esc)
+	}
+	if j := strings.IndexByte(s[:i], c.esc); j >= 0 {
+		return j
+	}
+	return i
+}
+
+// encodeByte writes the character sequence for the payload byte b.
+func (c *codec) encodeByte(buf io.ByteWriter, b byte) {
+	switch b {
+	/* if it's the same code as an END character, we send a
+	 * special two character code so as not to make the
+	 * receiver think we sent an END
+	 */
+	case c.end:
+		buf.WriteByte(c.esc)
+		buf.WriteByte(c.escEnd)
+
+	/* if it's the same code as an ESC character,
+	 * we send a special two character code so as not
+	 * to make the receiver think we sent an ESC
+	 */
+	case c.esc:
+		buf.WriteByte(c.esc)
+		buf.WriteByte(c.escEsc)
+
+	/* otherwise, we just send the character. This includes
+	 * ESC_END and ESC_ESC, which only mean something after an
+	 * ESC, so every payload survives unchanged. Extra bytes we
+	 * have been asked to escape get their own code.
+	 */
+	default:
+		if c.xesc != nil && c.xesc.hasCode[b] {
+			buf.WriteByte(c.esc)
+			buf.WriteByte(c.xesc.code[b])
+			return
+		}
+		buf.WriteByte(b)
+	}
+}
+
+// EncodedLen returns the number of bytes Writer.WritePacket emits for
+// p: the two framing END bytes plus one byte for every payload byte and
+// one more for every END or ESC that has to be escaped.
+func EncodedLen(p []byte) int {
+	n := len(p) + 2
+	for _, b := range p {
+		if b == END || b == ESC {
+			n++
+		}
+	}
+	return n
+}
+
+// FramingOverhead returns the length of p and its EncodedLen, the
+// bytes on the wire for p as a single frame, e.g. to compute the
+// expansion of SLIP over a corpus of packets.
+func FramingOverhead(p []byte) (payloadLen, wireLen int) {
+	return len(p), EncodedLen(p)
+}
+
+// MaxDecodedLen returns the maximum length of the packet decoded from
+// n bytes of SLIP encoded data. Every wire byte yields at most one
+// payload byte, so it is a safe size for the dst of Reader.ReadPacketInto.
+func MaxDecodedLen(n int) int {
+	return n
+}
+
+// Encode returns the SLIP encoding of src including the leading and
+// trailing END, exactly as written by Writer.WritePacket.
+// The encoding is stored in dst if it has enough capacity.
+func Encode(dst, src []byte) []byte {
+	return AppendEncode(dst[:0], src)
+}
+
+// AppendEncode appends the SLIP encoding of src including the leading
+// and trailing END to dst and returns the extended buffer, so several
+// packets can be collected in one buffer. dst grows at most once.
+func AppendEncode(dst, src []byte) []byte {
+	if n := len(dst) + EncodedLen(src); n > cap(dst) {
+		dst = append(make([]byte, 0, n), dst...)
+	}
+	return defaultCodec.appendPacket(dst, src)
+}
+
+// appendPacket is encodePacket for a slice, it appends the stuffed and
+// framed packet p to dst without going through an io.ByteWriter.
+func (c *codec) appendPacket(dst, p []byte) []byte {
+	if c.leadingEnd() {
+		dst = c.appendEnd(dst)
+	}
+	return c.appendFrame(dst, p)
+}
+
+// appendFrame is appendPacket without the leading END.
+func (c *codec) appendFrame(dst, p []byte) []byte {
+	start := len(dst)
+	dst = c.appendStuffed(dst, p)
+	if c.crc != nil {
+		for _, b := range c.crc.trailer(c.crc.checksum(p)) {
+			dst = c.appendByte(dst, b)
+		}
+	}
+	if c.wire != nil {
+		dst = c.appendWireTrailer(dst, start)
+	}
+	if !c.noFrame {
+		dst = c.appendEnd(dst)
+	}
+	return dst
+}
+
+// appendPacketv is appendPacket for the concatenation of ps.
+func (c *codec) appendPacketv(dst []byte, ps [][]byte) []byte {
+	if c.leadingEnd() {
+		dst = c.appendEnd(dst)
+	}
+	start := len(dst)
+	for _, p := range ps {
+		dst = c.appendStuffed(dst, p)
+	}
+	if c.crc != nil {
+		for _, b := range c.crc.trailer(c.crc.checksumv(ps)) {
+			dst = c.appendByte(dst, b)
+		}
+	}
+	if c.wire != nil {
+		dst = c.appendWireTrailer(dst, start)
+	}
+	if !c.noFrame {
+		dst = c.appendEnd(dst)
+	}
+	return dst
+}
+
+// appendStuffed appends the stuffed bytes of p to dst, without END
+// bytes around them.
+func (c *codec) appendStuffed(dst, p []byte) []byte {
+	for len(p) > 0 {
+		i := c.indexSpecial(p)
+		if i < 0 {
+			return append(dst, p...)
+		}
+		dst = c.appendByte(append(dst, p[:i]...), p[i])
+		p = p[i+1:]
+	}
+	return dst
+}
+
+// appendString is appendPacket for a string packet.
+func (c *codec) appendString(dst []byte, str string) []byte {
+	if c.leadingEnd() {
+		dst = c.appendEnd(dst)
+	}
+	start := len(dst)
+	for s := str; len(s) > 0; {
+		i := c.indexSpecialString(s)
+		if i < 0 {
+			dst = append(dst, s...)
+			break
+		}
+		dst = c.appendByte(append(dst, s[:i]...), s[i])
+		s = s[i+1:]
+	}
+	if c.crc != nil {
+		for _, b := range c.crc.trailer(c.crc.checksumString(str)) {
+			dst = c.appendByte(dst, b)
+		}
+	}
+	if c.wire != nil {
+		dst = c.appendWireTrailer(dst, start)
+	}
+	if !c.noFrame {
+		dst = c.appendEnd(dst)
+	}
+	return dst
+}
+
+// appendByte is encodeByte for a slice.
+func (c *codec) appendByte(dst []byte, b byte) []byte {
+	switch b {
+	case c.end:
+		return append(dst, c.esc, c.escEnd)
+	case c.esc:
+		return append(dst, c.esc, c.escEsc)
+	}
+	if c.xesc != nil && c.xesc.hasCode[b] {
+		return append(dst, c.esc, c.xesc.code[b])
+	}
+	return append(dst, b)
+}
+
+// Decode decodes the first packet in src as read by Reader.ReadPacket.
+// Leading END bytes are skipped and decoding stops at the END that
+// terminates the packet. A missing terminating END is tolerated.
+// The packet is stored in dst if it has enough capacity.
+//
+// Like a Reader in strict mode, Decode does not accept protocol
+// violations: an ESC followed by anything but ESC_END or ESC_ESC
+// returns an InvalidEscapeError, an ESC at the end of src returns
+// ErrIncompleteEscape.
+func Decode(dst, src []byte) ([]byte, error) {
+	p, _, err := defaultCodec.decode(dst[:0], src)
+	return p, err
+}
+
+// Validate checks that wire holds a sequence of well-formed packets,
+// e.g. a stored capture, without decoding them. It returns the first
+// anomaly a strict Reader reports: an InvalidEscapeError for an ESC
+// followed by anything but ESC_END or ESC_ESC, ErrIncompleteEscape for
+// an ESC at the end of wire, or io.ErrUnexpectedEOF if the last packet
+// is not terminated by an END. Empty packets are fine.
+func Validate(wire []byte) error {
+	return defaultCodec.check(wire)
+}
+
+func (c *codec) check(src []byte) error {
+	n := 0 // length of the current packet
+	for len(src) > 0 {
+		i := c.indexEndEsc(src)
+		if i < 0 {
+			n += len(src)
+			break
+		}
+		n += i
+		if src[i] == c.end {
+			n = 0
+			src = src[i+1:]
+			continue
+		}
+		if _, err := c.unescapeAt(src, i+1, n); err != nil {
+			return err
+		}
+		n++
+		src = src[i+2:]
+	}
+	if n > 0 {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+
+// StuffFrame returns the byte stuffed form of src without the framing
+// END bytes, for callers that delimit frames themselves.
+// The result is stored in dst if it has enough capacity.
+func StuffFrame(dst, src []byte) []byte {
+	return defaultCodec.appendStuffed(dst[:0], src)
+}
+
+// ErrUnescapedEnd is returned by DecodeFrame for an END byte in src.
+var ErrUnescapedEnd = errors.New("slip: unescaped END in frame")
+
+// DecodeFrame reverses StuffFrame: it unstuffs src, the bytes of a
+// single frame without END delimiters. An END in src returns
+// ErrUnescapedEnd, an invalid escape an InvalidEscapeError and an ESC
+// at the end of src ErrIncompleteEscape, together with the bytes
+// decoded before it.
+// The result is stored in dst if it has enough capacity.
+func DecodeFrame(dst, src []byte) ([]byte, error) {
+	return defaultCodec.unstuff(dst[:0], src)
+}
+
+func (c *codec) unstuff(p, src []byte) ([]byte, error) {
+	start := len(p)
+	for i := 0; i < len(src); i++ {
+		switch b := src[i]; b {
+		case c.end:
+			return p, ErrUnescapedEnd
+		case c.esc:
+			i++
+			b, err := c.unescapeAt(src, i, len(p)-start)
+			if err != nil {
+				return p, err
+			}
+			p = append(p, b)
+		default:
+			p = append(p, b)
+		}
+	}
+	return p, nil
+}
+
+// ErrTrailingData is returned by AppendDecode when src holds more than
+// one packet.
+var ErrTrailingData = errors.New("slip: trailing data after packet")
+
+// AppendDecode appends the packet decoded from src to dst and returns
+// the extended buffer. src must hold exactly one packet: like Decode,
+// leading END bytes are skipped and the terminating END may be missing,
+// but anything except END bytes after it returns ErrTrailingData
+// together with the decoded packet.
+func AppendDecode(dst, src []byte) ([]byte, error) {
+	p, n, err := defaultCodec.decode(dst, src)
+	if err != nil {
+		return p, err
+	}
+	for _, b := range src[n:] {
+		if b != END {
+			return p, ErrTrailingData
+		}
+	}
+	return p, nil
+}
+
+// decode appends the first packet in src to p. It returns the number
+// of bytes of src up to and including the terminating END.
+func (c *codec) decode(p, src []byte) ([]byte, int, error) {
+	start := len(p)
+	for i := 0; i < len(src); i++ {
+		switch b := src[i]; b {
+		case c.end:
+			if len(p) > start {
+				return p, i + 1, nil
+			}
+		case c.esc:
+			i++
+			b, err := c.unescapeAt(src, i, len(p)-start)
+			if err != nil {
+				return p, i, err
+			}
+			p = append(p, b)
+		default:
+			p = append(p, b)
+		}
+	}
+	return p, len(src), nil
+}
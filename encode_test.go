@@ -0,0 +1,287 @@
+package slip
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+func TestEncode(t *testing.T) {
+	for i, d := range writeData {
+		p := Encode(nil, d.data)
+		if !eqBytes(p, d.expected) {
+			t.Error(strconv.Itoa(i), "Expected data", d.expected, "but got", p)
+		}
+	}
+}
+
+func TestEncodeReusesDst(t *testing.T) {
+	dst := make([]byte, 0, 16)
+	p := Encode(dst, []byte{1, 2, 3})
+	if &p[0] != &dst[:1][0] {
+		t.Error("Expected dst to be reused")
+	}
+}
+
+func TestEncodedLen(t *testing.T) {
+	for i, d := range writeData {
+		if n := EncodedLen(d.data); n != len(d.expected) {
+			t.Error(strconv.Itoa(i), "Expected length", len(d.expected), "but got", n)
+		}
+	}
+}
+
+func TestFramingOverhead(t *testing.T) {
+	for i, d := range writeData {
+		if n, m := FramingOverhead(d.data); n != len(d.data) || m != len(d.expected) {
+			t.Error(strconv.Itoa(i), "Expected lengths", len(d.data), len(d.expected), "but got", n, m)
+		}
+	}
+}
+
+func TestMaxDecodedLen(t *testing.T) {
+	for i, d := range writeData {
+		if n := MaxDecodedLen(len(d.expected)); n < len(d.data) {
+			t.Error(strconv.Itoa(i), "Expected at least", len(d.data), "but got", n)
+		}
+	}
+}
+
+var decodeData = []struct {
+	data     []byte
+	expected []byte
+	err      error
+}{
+	{[]byte{END, 1, 2, 3, END}, []byte{1, 2, 3}, nil},
+	{[]byte{END, END, 1, END, 2, END}, []byte{1}, nil},
+	{[]byte{1, 2, 3}, []byte{1, 2, 3}, nil},
+	{[]byte{}, []byte{}, nil},
+	{[]byte{ESC, ESC_END, ESC, ESC_ESC, END}, []byte{END, ESC}, nil},
+	{[]byte{ESC_END, ESC_ESC, END}, []byte{ESC_END, ESC_ESC}, nil},
+	// Protocol violations
+	{[]byte{1, ESC}, []byte{1}, ErrIncompleteEscape},
+	{[]byte{1, ESC, 3, END}, []byte{1}, ErrInvalidEscape},
+	{[]byte{1, ESC, END}, []byte{1}, ErrInvalidEscape},
+}
+
+func TestDecode(t *testing.T) {
+	for i, d := range decodeData {
+		p, err := Decode(nil, d.data)
+		if !errors.Is(err, d.err) {
+			t.Error(strconv.Itoa(i), "Expected error", d.err, "but got", err)
+		}
+		if !eqBytes(p, d.expected) {
+			t.Error(strconv.Itoa(i), "Expected data", d.expected, "but got", p)
+		}
+	}
+}
+
+func TestEncodeAndDecode(t *testing.T) {
+	for i, d := range writeData {
+		p, err := Decode(nil, Encode(nil, d.data))
+		if err != nil {
+			t.Error(strconv.Itoa(i), "Unexpected error:", err)
+		}
+		if !eqBytes(p, d.data) {
+			t.Error(strconv.Itoa(i), "Expected data", d.data, "but got", p)
+		}
+	}
+}
+
+func TestAppendEncode(t *testing.T) {
+	var buf []byte
+	var expected []byte
+	for _, d := range writeData {
+		buf = AppendEncode(buf, d.data)
+		expected = append(expected, d.expected...)
+	}
+	if !eqBytes(buf, expected) {
+		t.Error("Expected data", expected, "but got", buf)
+	}
+
+	prefix := []byte{1, 2}
+	dst := make([]byte, 2, 64)
+	copy(dst, prefix)
+	p := AppendEncode(dst, []byte{END})
+	if &p[0] != &dst[0] || !eqBytes(p, []byte{1, 2, END, ESC, ESC_END, END}) {
+		t.Error("Expected dst to be extended in place but got", p)
+	}
+	if n := testing.AllocsPerRun(10, func() { AppendEncode(dst[:0], []byte{1, END, 3}) }); n != 0 {
+		t.Error("Expected no allocations but got", n)
+	}
+}
+
+var appendDecodeData = []struct {
+	data     []byte
+	expected []byte
+	err      error
+}{
+	{[]byte{END, 1, 2, 3, END}, []byte{1, 2, 3}, nil},
+	{[]byte{END, END, 1, END, END}, []byte{1}, nil},
+	{[]byte{1, 2, 3}, []byte{1, 2, 3}, nil},
+	{[]byte{ESC, ESC_END, END}, []byte{END}, nil},
+	{[]byte{END, 1, END, 2, END}, []byte{1}, ErrTrailingData},
+	{[]byte{1, END, ESC}, []byte{1}, ErrTrailingData},
+	{[]byte{1, ESC, 3, END}, []byte{1}, ErrInvalidEscape},
+}
+
+func TestAppendDecode(t *testing.T) {
+	for i, d := range appendDecodeData {
+		p, err := AppendDecode([]byte{9}, d.data)
+		if !errors.Is(err, d.err) {
+			t.Error(strconv.Itoa(i), "Expected error", d.err, "but got", err)
+		}
+		if expected := append([]byte{9}, d.expected...); !eqBytes(p, expected) {
+			t.Error(strconv.Itoa(i), "Expected data", expected, "but got", p)
+		}
+	}
+
+	// Offsets of InvalidEscapeError are relative to the packet
+	_, err := AppendDecode([]byte{9, 9}, []byte{1, ESC, 3})
+	var e *InvalidEscapeError
+	if !errors.As(err, &e) || e.Offset != 1 {
+		t.Error("Expected offset 1 but got", err)
+	}
+}
+
+// encodeReference stuffs p byte by byte like RFC 1055.
+func encodeReference(c codec, p []byte) []byte {
+	out := []byte{c.end}
+	for _, b := range p {
+		switch b {
+		case c.end:
+			out = append(out, c.esc, c.escEnd)
+		case c.esc:
+			out = append(out, c.esc, c.escEsc)
+		default:
+			out = append(out, b)
+		}
+	}
+	return append(out, c.end)
+}
+
+func TestEncodeFastPath(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	custom := codec{end: 0x7e, esc: 0x7d, escEnd: 0x5e, escEsc: 0x5d}
+	for i := 0; i < 200; i++ {
+		p := make([]byte, rnd.Intn(64))
+		for j := range p {
+			switch rnd.Intn(8) {
+			case 0:
+				p[j] = END
+			case 1:
+				p[j] = ESC
+			case 2:
+				p[j] = 0x7e
+			default:
+				p[j] = byte(rnd.Intn(256))
+			}
+		}
+
+		for _, c := range []codec{defaultCodec, custom} {
+			expected := encodeReference(c, p)
+			buf := &bytes.Buffer{}
+			w := NewWriter(buf, WithControlBytes(c.end, c.esc, c.escEnd, c.escEsc))
+			w.WritePacket(p)
+			w.WriteString(string(p))
+			if !eqBytes(buf.Bytes(), append(append([]byte(nil), expected...), expected...)) {
+				t.Fatal(strconv.Itoa(i), "Expected data", expected, "but got", buf.Bytes())
+			}
+			if got := c.appendPacket(nil, p); !eqBytes(got, expected) {
+				t.Fatal(strconv.Itoa(i), "Expected data", expected, "but got", got)
+			}
+		}
+	}
+}
+
+var frameData = []struct {
+	frame   []byte
+	stuffed []byte
+}{
+	{[]byte{1, 2, 3}, []byte{1, 2, 3}},
+	{[]byte{END, ESC}, []byte{ESC, ESC_END, ESC, ESC_ESC}},
+	{[]byte{}, []byte{}},
+	{[]byte{ESC_END, 1, END}, []byte{ESC_END, 1, ESC, ESC_END}},
+}
+
+func TestStuffFrame(t *testing.T) {
+	for i, d := range frameData {
+		if p := StuffFrame(nil, d.frame); !eqBytes(p, d.stuffed) {
+			t.Error(strconv.Itoa(i), "Expected data", d.stuffed, "but got", p)
+		}
+		p, err := DecodeFrame(nil, d.stuffed)
+		if err != nil {
+			t.Error(strconv.Itoa(i), "Unexpected error:", err)
+		}
+		if !eqBytes(p, d.frame) {
+			t.Error(strconv.Itoa(i), "Expected data", d.frame, "but got", p)
+		}
+	}
+}
+
+var decodeFrameErrors = []struct {
+	data     []byte
+	expected []byte
+	err      error
+}{
+	{[]byte{1, END, 2}, []byte{1}, ErrUnescapedEnd},
+	{[]byte{1, ESC, 2}, []byte{1}, ErrInvalidEscape},
+	{[]byte{1, ESC}, []byte{1}, ErrIncompleteEscape},
+}
+
+func TestDecodeFrameErrors(t *testing.T) {
+	for i, d := range decodeFrameErrors {
+		p, err := DecodeFrame(nil, d.data)
+		if !errors.Is(err, d.err) {
+			t.Error(strconv.Itoa(i), "Expected error", d.err, "but got", err)
+		}
+		if !eqBytes(p, d.expected) {
+			t.Error(strconv.Itoa(i), "Expected data", d.expected, "but got", p)
+		}
+	}
+}
+
+var validateData = []struct {
+	data []byte
+	err  error
+}{
+	{nil, nil},
+	{[]byte{END, END}, nil},
+	{[]byte{END, 1, ESC, ESC_END, END, 2, ESC, ESC_ESC, END}, nil},
+	{[]byte{1, 2, END}, nil},
+	{[]byte{END, 1, END, 2}, io.ErrUnexpectedEOF},
+	{[]byte{END, 1, ESC, 3, END}, ErrInvalidEscape},
+	{[]byte{END, 1, ESC, END}, ErrInvalidEscape},
+	{[]byte{END, 1, ESC}, ErrIncompleteEscape},
+}
+
+func TestValidate(t *testing.T) {
+	for i, d := range validateData {
+		if err := Validate(d.data); !errors.Is(err, d.err) || (d.err == nil && err != nil) {
+			t.Error(strconv.Itoa(i), "Expected error", d.err, "but got", err)
+		}
+	}
+	var ierr *InvalidEscapeError
+	if err := Validate([]byte{END, 1, END, 1, 2, ESC, 3, END}); !errors.As(err, &ierr) || ierr.Offset != 2 || ierr.Byte != 3 {
+		t.Error("Expected invalid escape of 3 at offset 2 but got", err)
+	}
+
+	// Validate agrees with a strict Reader
+	for i, seq := range controlSequences(4) {
+		wire := append(append([]byte(nil), seq...), END)
+		r := NewReader(bytes.NewReader(wire), WithStrictDecoding(true))
+		var rerr error
+		for rerr == nil {
+			_, _, rerr = r.ReadPacket()
+		}
+		if rerr == io.EOF {
+			rerr = nil
+		}
+		if err := Validate(wire); (err == nil) != (rerr == nil) {
+			t.Error(strconv.Itoa(i), wire, "Expected error", rerr, "but got", err)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package slip
+
+// Encoder encodes SLIP one byte at a time, for callers that push the
+// output into a ring buffer or a FIFO and decide themselves when it is
+// produced, without a buffer for the whole packet. It holds at most a
+// few bytes of output: every call that produces output reports false
+// instead if the pending output has to be drained with Next first.
+//
+// A packet is encoded with Begin, one Feed per payload byte and End,
+// exactly like Writer.WritePacket with the same options would.
+type Encoder struct {
+	codec
+	sum uint16 // running checksum of the packet, with WithCRC16
+
+	out  [8]byte // pending output, a ring buffer
+	head int
+	n    int
+}
+
+// NewEncoder returns an Encoder configured by the Writer options that
+// apply to encoding: WithControlBytes, WithCRC16, WithLeadingEnd
+// and WithFramingDisabled. Other options are ignored, except for
+// WithWireChecksum: its checksum covers the whole stuffed packet, which
+// an Encoder does not keep, so NewEncoder panics.
+func NewEncoder(opts ...WriterOption) *Encoder {
+	s := &Writer{codec: defaultCodec}
+	for _, opt := range opts {
+		opt.applyWriter(s)
+	}
+	s.validate()
+	if s.wire != nil {
+		panic("slip: WithWireChecksum is not supported by Encoder")
+	}
+	return &Encoder{codec: s.codec}
+}
+
+// Begin starts a packet and produces its leading END.
+func (e *Encoder) Begin() bool {
+	if !e.room(1) {
+		return false
+	}
+	if e.crc != nil {
+		e.sum = e.crc.Init
+	}
+	if e.leadingEnd() {
+		e.push(e.end)
+	}
+	return true
+}
+
+// Feed produces the stuffed form of the payload byte b.
+func (e *Encoder) Feed(b byte) bool {
+	if !e.room(2) {
+		return false
+	}
+	if e.crc != nil {
+		e.sum = e.crc.update(e.sum, b)
+	}
+	e.stuff(b)
+	return true
+}
+
+// End finishes the packet and produces the checksum trailer, if any,
+// and the terminating END.
+func (e *Encoder) End() bool {
+	if !e.room(5) {
+		return false
+	}
+	if e.crc != nil {
+		for _, b := range e.crc.trailer(e.sum ^ e.crc.XorOut) {
+			e.stuff(b)
+		}
+	}
+	if !e.noFrame {
+		e.push(e.end)
+	}
+	return true
+}
+
+// Next returns the next byte of output. It returns false if there is
+// none.
+func (e *Encoder) Next() (byte, bool) {
+	if e.n == 0 {
+		return 0, false
+	}
+	b := e.out[e.head]
+	e.head = (e.head + 1) % len(e.out)
+	e.n--
+	return b, true
+}
+
+// Pending returns the number of bytes of output Next has not returned
+// yet.
+func (e *Encoder) Pending() int {
+	return e.n
+}
+
+// Reset drops the pending output and the packet being encoded.
+func (e *Encoder) Reset() {
+	e.head, e.n = 0, 0
+}
+
+func (e *Encoder) room(n int) bool {
+	return len(e.out)-e.n >= n
+}
+
+func (e *Encoder) push(b byte) {
+	e.out[(e.head+e.n)%len(e.out)] = b
+	e.n++
+}
+
+// stuff pushes the character sequence for the payload byte b.
+func (e *Encoder) stuff(b byte) {
+	switch b {
+	case e.end:
+		e.push(e.esc)
+		e.push(e.escEnd)
+	case e.esc:
+		e.push(e.esc)
+		e.push(e.escEsc)
+	default:
+		if e.xesc != nil && e.xesc.hasCode[b] {
+			e.push(e.esc)
+			e.push(e.xesc.code[b])
+			return
+		}
+		e.push(b)
+	}
+}
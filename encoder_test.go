@@ -0,0 +1,79 @@
+package slip
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+)
+
+// encode encodes p with e, draining the output as late as possible.
+func encode(e *Encoder, p []byte) []byte {
+	var out []byte
+	drain := func() {
+		for b, ok := e.Next(); ok; b, ok = e.Next() {
+			out = append(out, b)
+		}
+	}
+	for !e.Begin() {
+		drain()
+	}
+	for _, b := range p {
+		for !e.Feed(b) {
+			drain()
+		}
+	}
+	for !e.End() {
+		drain()
+	}
+	drain()
+	return out
+}
+
+func TestEncoder(t *testing.T) {
+	payloads := append([][]byte{{END, END, END, ESC, ESC}}, crcPackets...)
+	for _, d := range writeData {
+		payloads = append(payloads, d.data)
+	}
+	optSets := [][]WriterOption{
+		nil,
+		{WithCRC16(CRC16CCITT)},
+		{WithCRC16(CRC16X25), WithLeadingEnd(false)},
+		{WithFramingDisabled(true)},
+		{WithControlBytes('#', '\\', 'e', 'x')},
+	}
+	for i, opts := range optSets {
+		e := NewEncoder(opts...)
+		for j, p := range payloads {
+			buf := &bytes.Buffer{}
+			NewWriter(buf, opts...).WritePacket(p)
+			if out := encode(e, p); !eqBytes(buf.Bytes(), out) {
+				t.Error(strconv.Itoa(i), strconv.Itoa(j), "Expected data", buf.Bytes(), "but got", out)
+			}
+		}
+	}
+}
+
+func TestEncoderBackpressure(t *testing.T) {
+	e := NewEncoder()
+	e.Begin()
+	n := 1
+	for e.Feed(END) {
+		n += 2
+	}
+	if e.Pending() != n || n > 8 {
+		t.Error("Expected", n, "pending bytes but got", e.Pending())
+	}
+	if b, _ := e.Next(); b != END {
+		t.Error("Expected END but got", b)
+	}
+	if b, _ := e.Next(); b != ESC {
+		t.Error("Expected ESC but got", b)
+	}
+	if !e.Feed(1) {
+		t.Error("Expected Feed to succeed after Next")
+	}
+	e.Reset()
+	if _, ok := e.Next(); ok || e.Pending() != 0 {
+		t.Error("Expected no pending output after Reset")
+	}
+}
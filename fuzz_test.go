@@ -0,0 +1,77 @@
+//go:build go1.18
+
+package slip
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+func FuzzReadPacket(f *testing.F) {
+	for _, d := range readData {
+		f.Add(d.data, false, uint8(0))
+	}
+	f.Add([]byte{END, 1, ESC, END, 2, END}, true, uint8(0))
+	f.Add([]byte{1, 2, 3, 4, END, ESC}, true, uint8(2))
+	f.Fuzz(func(t *testing.T, data []byte, strict bool, max uint8) {
+		r := NewReader(iotest.OneByteReader(bytes.NewReader(data)),
+			WithStrictDecoding(strict), WithMaxPacketSize(int(max)))
+		read := 0
+		for i := 0; ; i++ {
+			if i > len(data)+1 {
+				t.Fatal("Expected the reader to terminate")
+			}
+			p, _, err := r.ReadPacket()
+			if max > 0 && len(p) > int(max) {
+				t.Fatal("Expected at most", max, "bytes but got", len(p))
+			}
+			read += len(p)
+			if read > len(data) {
+				t.Fatal("Expected at most", len(data), "bytes but got", read)
+			}
+			switch {
+			case err == nil:
+				if len(p) == 0 {
+					t.Fatal("Expected no empty packets")
+				}
+			case errors.Is(err, ErrInvalidEscape):
+				if !strict {
+					t.Fatal("Unexpected error", err)
+				}
+			case err == ErrPacketTooLarge:
+				if max == 0 {
+					t.Fatal("Unexpected error", err)
+				}
+			case err == io.EOF, err == io.ErrUnexpectedEOF, err == ErrIncompleteEscape:
+				return
+			default:
+				t.Fatal("Unexpected error", err)
+			}
+		}
+	})
+}
+
+func FuzzDecode(f *testing.F) {
+	for _, d := range readData {
+		f.Add(d.data)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		p, err := Decode(nil, data)
+		r := NewReader(bytes.NewReader(data), WithStrictDecoding(true))
+		q, _, rerr := r.ReadPacket()
+		if err == nil && rerr == nil && !eqBytes(p, q) {
+			t.Fatal("Expected data", p, "but got", q)
+		}
+		if errors.Is(err, ErrInvalidEscape) != errors.Is(rerr, ErrInvalidEscape) {
+			t.Fatal("Expected error", err, "but got", rerr)
+		}
+		if err == nil {
+			if again, derr := Decode(nil, Encode(nil, p)); derr != nil || !eqBytes(p, again) {
+				t.Fatal("Expected data", p, "but got", again, derr)
+			}
+		}
+	})
+}
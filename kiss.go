@@ -0,0 +1,79 @@
+package slip
+
+import (
+	"errors"
+	"io"
+)
+
+// KISS commands, carried in the low nibble of the type byte that starts
+// every KISS frame; the high nibble is the port of the TNC. The frame
+// delimiters and escapes of KISS are the ones of SLIP.
+const (
+	KISS_DATA       = 0x00 // data frame for the radio
+	KISS_TXDELAY    = 0x01
+	KISS_PERSIST    = 0x02
+	KISS_SLOTTIME   = 0x03
+	KISS_TXTAIL     = 0x04
+	KISS_FULLDUPLEX = 0x05
+	KISS_SETHW      = 0x06
+	KISS_RETURN     = 0x0f // with port 15, leaves KISS mode
+)
+
+// ErrKISSType is returned by KISSWriter for a port or command that does
+// not fit into a nibble.
+var ErrKISSType = errors.New("slip: KISS port or command out of range")
+
+// KISSWriter writes KISS frames to the host port of a TNC, as used in
+// amateur radio.
+type KISSWriter struct {
+	w *Writer
+}
+
+// NewKISSWriter returns a new KISSWriter writing to writer. Every frame
+// starts with the type byte port<<4|cmd, followed by the payload with
+// the escapes of SLIP; a port or command above 15 is rejected with
+// ErrKISSType. opts are the options of NewWriter.
+func NewKISSWriter(writer io.Writer, opts ...WriterOption) *KISSWriter {
+	return &KISSWriter{
+		w: NewWriter(writer, opts...),
+	}
+}
+
+// WritePacket writes p as one frame for the given port and command,
+// e.g. KISS_DATA. Port and command must be below 16. p is not copied.
+func (s *KISSWriter) WritePacket(port, cmd byte, p []byte) error {
+	if port > 0x0f || cmd > 0x0f {
+		return ErrKISSType
+	}
+	return s.w.WritePacketv([]byte{port<<4 | cmd}, p)
+}
+
+// KISSReader reads KISS frames written by a TNC or a KISSWriter.
+type KISSReader struct {
+	r *Reader
+}
+
+// NewKISSReader returns a new KISSReader reading from reader. The first
+// byte of every frame is split into the port, its high nibble, and the
+// command, its low nibble; frames without a type byte are skipped.
+// opts are the options of NewReader.
+func NewKISSReader(reader io.Reader, opts ...ReaderOption) *KISSReader {
+	return &KISSReader{
+		r: NewReader(reader, opts...),
+	}
+}
+
+// ReadPacket reads the next frame and returns its payload with the
+// port and command of its type byte. Errors are returned as by
+// Reader.ReadPacket, empty frames are skipped.
+func (s *KISSReader) ReadPacket() (p []byte, port, cmd byte, err error) {
+	for {
+		p, _, err = s.r.ReadPacket()
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		if len(p) > 0 {
+			return p[1:], p[0] >> 4, p[0] & 0x0f, nil
+		}
+	}
+}
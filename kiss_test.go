@@ -0,0 +1,47 @@
+package slip
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+)
+
+var kissData = []struct {
+	port, cmd byte
+	data      []byte
+}{
+	{0, KISS_DATA, []byte{0x82, 0xa0, END, ESC}},
+	{1, KISS_TXDELAY, []byte{50}},
+	{12, KISS_DATA, []byte("x")}, // type byte END
+	{15, KISS_RETURN, nil},
+}
+
+func TestKISSRoundtrip(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := NewKISSWriter(buf)
+	for i, d := range kissData {
+		if err := w.WritePacket(d.port, d.cmd, d.data); err != nil {
+			t.Fatal(strconv.Itoa(i), "Unexpected error", err)
+		}
+	}
+
+	r := NewKISSReader(buf)
+	for i, d := range kissData {
+		p, port, cmd, err := r.ReadPacket()
+		if err != nil || port != d.port || cmd != d.cmd || !eqBytes(d.data, p) {
+			t.Error(strconv.Itoa(i), "Expected", d.port, d.cmd, d.data, "but got", port, cmd, p, err)
+		}
+	}
+}
+
+func TestKISSOnTheWire(t *testing.T) {
+	buf := &bytes.Buffer{}
+	NewKISSWriter(buf).WritePacket(12, KISS_DATA, []byte{1})
+	if expected := []byte{END, ESC, ESC_END, 1, END}; !eqBytes(expected, buf.Bytes()) {
+		t.Error("Expected data", expected, "but got", buf.Bytes())
+	}
+
+	if err := NewKISSWriter(buf).WritePacket(16, KISS_DATA, nil); err != ErrKISSType {
+		t.Error("Expected error", ErrKISSType, "but got", err)
+	}
+}
@@ -0,0 +1,42 @@
+package slip
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// ErrLengthMismatch is returned by ReadLengthPrefixed when the length
+// prefix of a packet does not match the length of its payload.
+var ErrLengthMismatch = errors.New("slip: length prefix does not match payload")
+
+// maxLengthPrefixed is the largest payload a 2 byte prefix can describe.
+const maxLengthPrefixed = 1<<16 - 1
+
+// WriteLengthPrefixed writes p as one packet preceded by its length as
+// a 2 byte integer in the given byte order, a common convention inside
+// SLIP packets. p is not copied. A p longer than 65535 bytes returns
+// ErrPacketTooLarge and nothing is written.
+func (s *Writer) WriteLengthPrefixed(p []byte, order binary.ByteOrder) error {
+	if len(p) > maxLengthPrefixed {
+		return ErrPacketTooLarge
+	}
+	var prefix [2]byte
+	order.PutUint16(prefix[:], uint16(len(p)))
+	return s.WritePacketv(prefix[:], p)
+}
+
+// ReadLengthPrefixed reads the next packet like ReadPacket and returns
+// its payload after the 2 byte length prefix written by
+// WriteLengthPrefixed. A packet shorter than the prefix or whose prefix
+// does not match the length of the payload is dropped with
+// ErrLengthMismatch. Read errors are returned as by ReadPacket.
+func (s *Reader) ReadLengthPrefixed(order binary.ByteOrder) ([]byte, error) {
+	p, _, err := s.ReadPacket()
+	if err != nil {
+		return p, err
+	}
+	if len(p) < 2 || int(order.Uint16(p)) != len(p)-2 {
+		return nil, ErrLengthMismatch
+	}
+	return p[2:], nil
+}
@@ -0,0 +1,58 @@
+package slip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strconv"
+	"testing"
+)
+
+func TestLengthPrefixed(t *testing.T) {
+	payloads := [][]byte{{1, 2, 3}, {}, {END, ESC}, bytes.Repeat([]byte{END}, 300)}
+	for _, order := range []binary.ByteOrder{binary.BigEndian, binary.LittleEndian} {
+		buf := &bytes.Buffer{}
+		w := NewWriter(buf)
+		for _, p := range payloads {
+			if err := w.WriteLengthPrefixed(p, order); err != nil {
+				t.Fatal("Unexpected error", err)
+			}
+		}
+
+		r := NewReader(bytes.NewReader(buf.Bytes()))
+		for i, expected := range payloads {
+			p, err := r.ReadLengthPrefixed(order)
+			if err != nil || !eqBytes(expected, p) {
+				t.Error(strconv.Itoa(i), "Expected data", expected, "but got", p, err)
+			}
+		}
+	}
+
+	// The prefix is sent in the given order
+	buf := &bytes.Buffer{}
+	NewWriter(buf).WriteLengthPrefixed([]byte{9}, binary.LittleEndian)
+	if expected := []byte{END, 1, 0, 9, END}; !eqBytes(expected, buf.Bytes()) {
+		t.Error("Expected data", expected, "but got", buf.Bytes())
+	}
+
+	if err := NewWriter(buf).WriteLengthPrefixed(make([]byte, 1<<16), binary.BigEndian); err != ErrPacketTooLarge {
+		t.Error("Expected error", ErrPacketTooLarge, "but got", err)
+	}
+}
+
+func TestLengthPrefixedMismatch(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	w.WritePacket([]byte{0, 3, 1, 2})
+	w.WritePacket([]byte{1})
+	w.WriteLengthPrefixed([]byte{7}, binary.BigEndian)
+
+	r := NewReader(buf)
+	for i := 0; i < 2; i++ {
+		if p, err := r.ReadLengthPrefixed(binary.BigEndian); err != ErrLengthMismatch {
+			t.Error(strconv.Itoa(i), "Expected error", ErrLengthMismatch, "but got", p, err)
+		}
+	}
+	if p, err := r.ReadLengthPrefixed(binary.BigEndian); err != nil || !eqBytes([]byte{7}, p) {
+		t.Error("Expected data", []byte{7}, "but got", p, err)
+	}
+}
@@ -0,0 +1,26 @@
+package slip
+
+// Logger receives the messages of WithErrorLogger. *log.Logger
+// implements it.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// maxLoggedBytes is the number of decoded bytes logged with a frame.
+const maxLoggedBytes = 16
+
+// offset returns the position in the stream of the next byte to be
+// decoded, counted from NewReader or Reset.
+func (s *Reader) offset() int64 {
+	return s.nread - int64(s.wr-s.rd)
+}
+
+// logFrame logs the frame p that failed with the protocol error err.
+func (s *Reader) logFrame(p []byte, err error) {
+	head := p
+	if len(head) > maxLoggedBytes {
+		head = head[:maxLoggedBytes]
+	}
+	s.logger.Printf("slip: frame dropped at stream offset %d after %d bytes [% x]: %v",
+		s.offset(), len(p), head, err)
+}
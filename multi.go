@@ -0,0 +1,48 @@
+package slip
+
+import (
+	"fmt"
+	"io"
+)
+
+// MultiWriteError is returned by a Writer created with NewMultiWriter
+// when one of its writers failed. The writers before Index received
+// the whole write, the ones after it nothing.
+type MultiWriteError struct {
+	Index int // position of the failed writer in the arguments
+	Err   error
+}
+
+func (e *MultiWriteError) Error() string {
+	return fmt.Sprintf("slip: write to writer %d: %v", e.Index, e.Err)
+}
+
+func (e *MultiWriteError) Unwrap() error {
+	return e.Err
+}
+
+// multiWriter duplicates every write to all of its writers.
+type multiWriter []io.Writer
+
+func (ws multiWriter) Write(p []byte) (int, error) {
+	for i, w := range ws {
+		n, err := w.Write(p)
+		if err == nil && n < len(p) {
+			err = io.ErrShortWrite
+		}
+		if err != nil {
+			return n, &MultiWriteError{Index: i, Err: err}
+		}
+	}
+	return len(p), nil
+}
+
+// NewMultiWriter returns a Writer that stuffs every packet once and
+// writes the same framed bytes to each of ws in turn, like
+// io.MultiWriter, e.g. to mirror a link to a log. The first writer that
+// fails or writes short stops the write, which returns a
+// MultiWriteError naming it. So list the primary link first, a failing
+// mirror behind it then only loses its own copy of the packet.
+func NewMultiWriter(ws ...io.Writer) *Writer {
+	return NewWriter(multiWriter(append([]io.Writer(nil), ws...)))
+}
@@ -0,0 +1,39 @@
+package slip
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestMultiWriter(t *testing.T) {
+	a, b := &bytes.Buffer{}, &bytes.Buffer{}
+	counter := &countingWriter{w: b}
+	w := NewMultiWriter(a, counter)
+	if err := w.WritePacket([]byte{1, END, 2}); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	expected := []byte{END, 1, ESC, ESC_END, 2, END}
+	if !eqBytes(expected, a.Bytes()) || !eqBytes(expected, b.Bytes()) {
+		t.Error("Expected data", expected, "but got", a.Bytes(), b.Bytes())
+	}
+	if counter.calls != 1 {
+		t.Error("Expected 1 write but got", counter.calls)
+	}
+	if s := w.Stats(); s.Bytes != uint64(len(expected)) {
+		t.Error("Expected", len(expected), "bytes but got", s.Bytes)
+	}
+}
+
+func TestMultiWriterError(t *testing.T) {
+	a, c := &bytes.Buffer{}, &bytes.Buffer{}
+	w := NewMultiWriter(a, &limitedWriter{n: 2}, c)
+	err := w.WritePacket([]byte{1, 2, 3})
+	var merr *MultiWriteError
+	if !errors.As(err, &merr) || merr.Index != 1 || !errors.Is(err, errLimit) {
+		t.Fatal("Expected MultiWriteError of writer 1 but got", err)
+	}
+	if a.Len() != 5 || c.Len() != 0 {
+		t.Error("Expected 5 and 0 bytes but got", a.Len(), c.Len())
+	}
+}
@@ -0,0 +1,518 @@
+package slip
+
+import (
+	"io"
+	"time"
+)
+
+// ReaderOption configures a Reader created by NewReader.
+type ReaderOption interface {
+	applyReader(*Reader)
+}
+
+// WriterOption configures a Writer created by NewWriter.
+type WriterOption interface {
+	applyWriter(*Writer)
+}
+
+// Option configures both a Reader and a Writer.
+type Option interface {
+	ReaderOption
+	WriterOption
+}
+
+type codecOption func(*codec)
+
+func (f codecOption) applyReader(s *Reader) {
+	f(&s.codec)
+}
+
+func (f codecOption) applyWriter(s *Writer) {
+	f(&s.codec)
+}
+
+type writerOptionFunc func(*Writer)
+
+func (f writerOptionFunc) applyWriter(s *Writer) {
+	f(s)
+}
+
+type readerOptionFunc func(*Reader)
+
+func (f readerOptionFunc) applyReader(s *Reader) {
+	f(s)
+}
+
+// WithMaxPacketSize sets Reader.MaxPacketSize.
+func WithMaxPacketSize(n int) ReaderOption {
+	return readerOptionFunc(func(s *Reader) {
+		s.MaxPacketSize = n
+	})
+}
+
+// WithReadBufferSize sets the size of the internal read buffer.
+// A size <= 0 keeps the default size of 4096 bytes.
+func WithReadBufferSize(n int) ReaderOption {
+	return readerOptionFunc(func(s *Reader) {
+		if n > 0 {
+			s.buf = make([]byte, n)
+		}
+	})
+}
+
+// WithStrictDecoding makes ReadPacket return an InvalidEscapeError
+// when an ESC is followed by a byte other than ESC_END or ESC_ESC.
+// The rest of the packet is dropped; after ESC END that is nothing, the
+// END still ends the packet. By default such a byte is stored in the
+// packet as is, except an END, which ends the packet without the ESC.
+func WithStrictDecoding(strict bool) ReaderOption {
+	return readerOptionFunc(func(s *Reader) {
+		s.strict = strict
+	})
+}
+
+// WithSkipToFirstEnd makes the Reader drop everything up to and
+// including the first END of the stream, e.g. when it taps into a
+// running stream whose first bytes are the tail of a packet, so the
+// first packet returned is complete. It applies once at the start of
+// the stream and again after Reset. LastOverflowBytes of the first read
+// counts the dropped bytes. It has no effect with WithFramingDisabled.
+func WithSkipToFirstEnd(skip bool) ReaderOption {
+	return readerOptionFunc(func(s *Reader) {
+		s.skipInit = skip
+	})
+}
+
+// WithRequireLeadingEnd makes the first packet after NewReader or
+// Reset fail with ErrMissingLeadingEnd if the stream does not start
+// with an END, to catch a peer that does not frame its packets as
+// RFC 1055 recommends. Later packets only need the END of the one
+// before, so peers sending a trailing END only pass once the first END
+// was seen. The bytes up to the next END are dropped. By default data
+// before the first END is read as a packet. It has no effect with
+// WithFramingDisabled.
+func WithRequireLeadingEnd(require bool) ReaderOption {
+	return readerOptionFunc(func(s *Reader) {
+		s.leadReq = require
+	})
+}
+
+// WithControlBytes replaces the RFC 1055 control bytes END, ESC,
+// ESC_END and ESC_ESC, e.g. to talk to a device that frames with a
+// different END byte. Reader and Writer must use the same values.
+// NewReader and NewWriter panic if the bytes are not mutually distinct.
+func WithControlBytes(end, esc, escEnd, escEsc byte) Option {
+	return codecOption(func(c *codec) {
+		c.end, c.esc, c.escEnd, c.escEsc = end, esc, escEnd, escEsc
+	})
+}
+
+// WithFlagSequence frames packets with the given flag sequence instead
+// of a single END, e.g. 0x7E 0x7E for a device with HDLC-like framing.
+// The first byte of the flag takes the role of END, also over one set
+// with WithControlBytes: it is escaped wherever it appears in a payload,
+// so the flag never appears inside a frame. The Reader ends a frame at
+// that byte and drops the rest of the flag after it; a byte that does
+// not continue the flag starts the next frame, so a damaged flag costs
+// no more than the frame it ends. ReadPacketRaw returns the first byte
+// of every flag only. Encoder and Decoder do not support flag
+// sequences. WithFlagSequence panics if flag is empty.
+func WithFlagSequence(flag []byte) Option {
+	if len(flag) == 0 {
+		panic("slip: empty flag sequence")
+	}
+	f := append([]byte(nil), flag...)
+	return codecOption(func(c *codec) {
+		c.flag = f
+	})
+}
+
+// WithExtraEscapedBytes escapes the given bytes in addition to END and
+// ESC, e.g. the XON and XOFF bytes 0x11 and 0x13 of a serial line with
+// software flow control, so they never appear on the wire. Every byte
+// is sent as ESC followed by a code of its own; the codes are assigned
+// in order from the bytes following ESC_ESC, 0xDE, 0xDF and so on,
+// skipping the control bytes and the listed bytes, so both peers must
+// list the same bytes in the same order. Plain SLIP peers reject or
+// misread the codes.
+// NewReader and NewWriter panic if a listed byte is a control byte or
+// no code is left.
+func WithExtraEscapedBytes(bytes ...byte) Option {
+	extra := append([]byte(nil), bytes...)
+	return codecOption(func(c *codec) {
+		c.extra = extra
+	})
+}
+
+// WithStreamingWrites makes WritePacket stream the stuffed bytes to the
+// underlying writer through a reusable buffer of the given size instead
+// of building the whole packet in memory first. A packet larger than
+// the buffer is written with several calls to Write, which is not
+// atomic on packet sockets. Callers who need every packet in a single
+// Write must keep the default. After a failed Write the rest of the
+// packet is dropped and the following packets are written as usual.
+// A size <= 0 keeps the default.
+func WithStreamingWrites(size int) WriterOption {
+	return writerOptionFunc(func(s *Writer) {
+		s.streamSize = size
+	})
+}
+
+// WithKeepEmptyFrames makes ReadPacket return an empty, non-nil packet
+// for every END that ends no packet, e.g. line noise flushes or the
+// leading END of each packet, instead of skipping it.
+// ReaderStats.EmptyFrames counts them in either case.
+func WithKeepEmptyFrames(keep bool) ReaderOption {
+	return readerOptionFunc(func(s *Reader) {
+		s.empty = keep
+	})
+}
+
+// WithCRC16 appends a CRC-16 of the given variant to the payload of
+// every packet written, before stuffing and framing, and makes
+// ReadPacket verify and strip it. A packet with a wrong checksum is
+// dropped with a ChecksumError. Both peers must use the same variant;
+// plain SLIP peers do not understand the trailer.
+// The limit of ReadPacketInto and MaxPacketSize include the two
+// trailer bytes, and DecodedStream returns them as data.
+func WithCRC16(crc CRC16) Option {
+	t := newCRC16Table(crc)
+	return codecOption(func(c *codec) {
+		c.crc = t
+	})
+}
+
+// WithWireChecksum appends the checksum sum of the stuffed bytes of
+// every packet written, as they go out between the END bytes, and makes
+// ReadPacket verify and strip it. Fletcher16 is the common choice.
+// Unlike WithCRC16 the checksum covers the bytes after stuffing, and the
+// CRC trailer too if both are set. The two checksum bytes follow most
+// significant byte first and are stuffed themselves, but not covered.
+// A packet with a wrong checksum is dropped with a ChecksumError.
+// The limit of ReadPacketInto and MaxPacketSize include the two
+// trailer bytes. Encoder and Decoder do not support it.
+func WithWireChecksum(sum func(wire []byte) uint16) Option {
+	return codecOption(func(c *codec) {
+		c.wire = sum
+	})
+}
+
+// WithAutoResync makes the reads drop packets with a protocol error,
+// see the package documentation, and return the next good packet
+// instead, e.g. for telemetry over a noisy line. The dropped bytes are
+// not reported, ReaderStats.FramesDropped counts the packets.
+// ErrBufferTooSmall and errors of the underlying reader are still
+// returned.
+func WithAutoResync(resync bool) ReaderOption {
+	return readerOptionFunc(func(s *Reader) {
+		s.resync = resync
+	})
+}
+
+// WithPacketSeparator sets the bytes Reader.WriteTo writes after the
+// payload of every packet, e.g. a newline for text protocols. By
+// default packets are written back to back.
+func WithPacketSeparator(sep []byte) ReaderOption {
+	sep = append([]byte(nil), sep...)
+	return readerOptionFunc(func(s *Reader) {
+		s.sep = sep
+	})
+}
+
+// WithFrameDelimiter makes Writer.ReadFrom write one packet per
+// delim-terminated chunk of the source, without the delimiter, instead
+// of one packet per Read. It is the counterpart of WithPacketSeparator.
+func WithFrameDelimiter(delim byte) WriterOption {
+	return writerOptionFunc(func(s *Writer) {
+		s.split = true
+		s.delim = delim
+	})
+}
+
+// WithCopyOnRead controls whether ReadPacket returns a newly allocated
+// slice for every packet, which is the default and safe to keep. With
+// false ReadPacket reuses one buffer and does not allocate once it has
+// grown: the returned slice is only valid until the next read, callers
+// who keep a packet must copy it. ReadPacketContext and Packets always
+// copy.
+func WithCopyOnRead(enabled bool) ReaderOption {
+	return readerOptionFunc(func(s *Reader) {
+		s.reuse = !enabled
+	})
+}
+
+// WithInitialReadCapacity makes ReadPacket decode into a buffer of
+// capacity n that is kept across reads, so packets up to n bytes, e.g.
+// large firmware images, are decoded without growing the buffer again
+// and again. The packet is then copied into a slice of the exact size
+// for the caller, unless WithCopyOnRead(false) is set, where the buffer
+// itself is returned. A packet larger than n still grows the buffer,
+// which is released afterwards so a single huge packet does not pin its
+// memory. An n <= 0 keeps the default.
+func WithInitialReadCapacity(n int) ReaderOption {
+	return readerOptionFunc(func(s *Reader) {
+		if n > 0 {
+			s.rcap = n
+		}
+	})
+}
+
+// WithWriteBuffering makes WritePacket collect the encoded packets in a
+// buffer of the given size and write them to the underlying writer
+// with a single Write once the buffer is full, or on Flush or Close, to
+// coalesce small packets into fewer syscalls. Packets are never split
+// across writes: a packet that does not fit anymore triggers a write
+// of the packets before it, and a packet larger than the buffer is
+// written on its own. Callers must Flush before relying on the data
+// being on the wire. A size <= 0 keeps one Write per packet.
+func WithWriteBuffering(size int) WriterOption {
+	return writerOptionFunc(func(s *Writer) {
+		s.wsize = size
+	})
+}
+
+// WithoutLocking removes the mutex from the hot path of a Reader or
+// Writer for callers who use it from a single goroutine or synchronize
+// all calls themselves, including Close and Reset; concurrent calls are
+// a data race then. Stats stays safe to call concurrently.
+// ReadPacketContext and Packets read from another goroutine and must
+// not be mixed with other reads.
+func WithoutLocking() Option {
+	return nolockOption{}
+}
+
+type nolockOption struct{}
+
+func (nolockOption) applyReader(s *Reader) {
+	s.nolock = true
+}
+
+func (nolockOption) applyWriter(s *Writer) {
+	s.nolock = true
+}
+
+// WithLeadingEnd controls the END byte written before every packet to
+// flush out line noise, as RFC 1055 recommends. It is written by
+// default; on a reliable stream it can be dropped to save a byte per
+// packet, so packets are only terminated by an END. Readers skip the
+// empty packets either way.
+func WithLeadingEnd(lead bool) WriterOption {
+	return writerOptionFunc(func(s *Writer) {
+		s.noLead = !lead
+	})
+}
+
+// WithLeadingEndOnce writes the leading END only before the first
+// packet after NewWriter or Reset, to flush out line noise once; later
+// packets rely on the trailing END of the previous one. This saves a
+// byte per packet on long-lived streams. The END is written again after
+// a failed write, so the peer drops a packet cut short.
+func WithLeadingEndOnce() WriterOption {
+	return writerOptionFunc(func(s *Writer) {
+		s.noLead = false
+		s.leadOnce = true
+	})
+}
+
+// WithFramingDisabled turns off the END delimiters, for transports that
+// frame the data themselves, e.g. with a length prefix, or to measure
+// the cost of stuffing alone. WritePacket then writes only the stuffed
+// payload, without a leading or trailing END.
+// The stream no longer tells where a frame ends, so the Reader needs
+// the length of every frame, the limit of the read becomes the frame
+// length: ReadPacketInto decodes exactly len(dst) bytes,
+// ReadPacketLimited exactly max bytes and ReadPacket exactly
+// MaxPacketSize bytes, where a smaller MaxPacketSize wins as usual.
+// Reads without a length return ErrNoFrameLength, an END in the stream
+// returns ErrUnescapedEnd. Both peers must use the option.
+func WithFramingDisabled(disabled bool) Option {
+	return codecOption(func(c *codec) {
+		c.noFrame = disabled
+	})
+}
+
+// WithMaxEscapeRatio makes ReadPacket drop packets in which more than
+// the fraction r of the decoded bytes were escaped on the wire, e.g. a
+// packet consisting of ESC sequences only, with ErrSuspiciousFrame.
+// It is a cheap guard against peers that waste bandwidth and CPU with
+// stuffing on untrusted links. The ratio is checked once the packet is
+// complete; an r <= 0 disables the check, which is the default.
+// ErrSuspiciousFrame is a protocol error, see WithAutoResync.
+func WithMaxEscapeRatio(r float64) ReaderOption {
+	return readerOptionFunc(func(s *Reader) {
+		s.ratio = r
+	})
+}
+
+// WithZeroReadRetry controls what a Read on the underlying reader that
+// returns neither data nor an error means. io.Reader discourages it but
+// allows it as "nothing happened", so by default such a Read is
+// retried, up to 100 times in a row before io.ErrNoProgress is returned,
+// so a reader that never delivers cannot make ReadPacket spin forever.
+// With false the first empty Read returns io.ErrNoProgress together
+// with the unfinished packet, which the next read resumes, for readers
+// that use it as a soft "no data yet". See WithZeroReadRetryLimit to
+// change the bound.
+func WithZeroReadRetry(retry bool) ReaderOption {
+	return readerOptionFunc(func(s *Reader) {
+		if retry {
+			s.reads = maxConsecutiveEmptyReads
+		} else {
+			s.reads = 1
+		}
+	})
+}
+
+// WithZeroReadRetryLimit sets the number of empty Reads in a row after
+// which io.ErrNoProgress is returned, see WithZeroReadRetry. A limit
+// <= 0 keeps the default of 100.
+func WithZeroReadRetryLimit(n int) ReaderOption {
+	return readerOptionFunc(func(s *Reader) {
+		if n > 0 {
+			s.reads = n
+		}
+	})
+}
+
+// WithReadLimit stops reading from the underlying reader once n bytes
+// have been read from it in total, across packets and regardless of
+// frame boundaries, e.g. as a last defense against an untrusted peer
+// that never stops sending. The bytes read before are decoded as usual,
+// then the reads return ErrReadLimitExceeded, with the unfinished
+// packet if the limit was hit inside one. Reset starts counting anew.
+// A limit <= 0 means no limit.
+func WithReadLimit(n int64) ReaderOption {
+	return readerOptionFunc(func(s *Reader) {
+		if n > 0 {
+			s.readLimit = n
+		}
+	})
+}
+
+// WithInterByteTimeout limits the time between two Reads of a packet,
+// the inter-character timeout of serial framing. If the underlying
+// reader has a SetReadDeadline method, as net.Conn does, the deadline
+// is moved to d from now before every Read, so a packet that trickles
+// in slowly does not time out. A packet that stalls for longer is
+// dropped with ErrFrameTimeout, a protocol error that WithAutoResync
+// skips; an idle link returns the timeout error of the underlying
+// reader between packets. An earlier deadline set with SetReadDeadline
+// or by a cancelled ReadPacketContext is kept. Zero, the default,
+// disables it.
+func WithInterByteTimeout(d time.Duration) ReaderOption {
+	return readerOptionFunc(func(s *Reader) {
+		s.byteTimeout = d
+	})
+}
+
+// WithCoalesceWindow makes ReadPacket return the concatenation of all
+// packets that follow each other within d, for a peer that splits a
+// message into several frames without marking the continuation. After
+// every packet the Reader waits up to d for the next one to complete.
+// This needs an underlying reader with a SetReadDeadline method, as
+// net.Conn has, whose deadline each read then moves and restores,
+// keeping an earlier one set with SetReadDeadline; otherwise only
+// packets already received with the same Read are joined. A packet that would make the message exceed the limit of the
+// read is returned on its own by the next read, and so is the error of
+// a packet that failed. The read hook and the error logger do not see
+// the timeouts that end the window. WriteTo coalesces like ReadPacket,
+// ReadPacketInto and Peek do not, and ReadPacketRaw returns the wire
+// bytes of the last packet only. A d <= 0 turns coalescing off.
+func WithCoalesceWindow(d time.Duration) ReaderOption {
+	return readerOptionFunc(func(s *Reader) {
+		s.window = d
+	})
+}
+
+// WithEmptyFrameCallback registers fn to be called for every END that
+// ends no packet, the frames counted by ReaderStats.EmptyFrames, e.g.
+// to reset an idle timer on peers that send bare END bytes as a
+// keepalive. The empty frames are still skipped unless
+// WithKeepEmptyFrames is set. fn runs while the Reader's mutex is held
+// and must not call back into the Reader.
+func WithEmptyFrameCallback(fn func()) ReaderOption {
+	return readerOptionFunc(func(s *Reader) {
+		s.onIdle = fn
+	})
+}
+
+// WithLargeFrameWarning registers fn to be called with the size of
+// every packet longer than threshold bytes once it is complete, e.g. to
+// log unusually large packets. Unlike MaxPacketSize it does not reject
+// them: the packet is returned as usual. fn runs while the Reader's
+// mutex is held and must not call back into the Reader.
+func WithLargeFrameWarning(threshold int, fn func(size int)) ReaderOption {
+	return readerOptionFunc(func(s *Reader) {
+		s.warnSize = threshold
+		s.onLarge = fn
+	})
+}
+
+// WithTap makes every packet read by ReadPacket, ReadPacketInto and the
+// other read methods be encoded again and written to tap, like
+// io.TeeReader, e.g. to record the traffic to a file while it is
+// processed. Errors of tap do not affect reading: by default they are
+// ignored. If onError is set, it is called with every error of tap and
+// decides: it may log it and return nil to go on, or return an error,
+// which the read returns as a *TapError together with the packet,
+// which was read completely. Empty frames are not written.
+func WithTap(tap io.Writer, onError func(err error) error) ReaderOption {
+	return readerOptionFunc(func(s *Reader) {
+		s.tap = tap
+		s.tapError = onError
+	})
+}
+
+// WithFrameValidator registers fn to check every decoded frame before
+// it is returned, e.g. with utf8.Valid for a text channel. If fn
+// returns an error, the frame is dropped and the read returns a
+// *FrameError wrapping it, a protocol error that WithAutoResync skips.
+// fn also sees the empty frames of WithKeepEmptyFrames, not the bytes
+// of unfinished packets. The frame must not be kept after fn returns.
+// By default frames are not validated.
+func WithFrameValidator(fn func(frame []byte) error) ReaderOption {
+	return readerOptionFunc(func(s *Reader) {
+		s.validator = fn
+	})
+}
+
+// WithReadHook registers fn to be called with every frame ReadPacket,
+// ReadPacketInto and WriteTo decode, before it is returned: good
+// packets with a nil error, and the bytes of failed or unfinished
+// packets with their error, also when WithAutoResync drops them. A
+// clean end of the stream is not reported. frame aliases the caller's
+// or an internal buffer and must not be kept after fn returns.
+// fn runs while the Reader's mutex is held and must not call back into
+// the Reader.
+func WithReadHook(fn func(frame []byte, err error)) ReaderOption {
+	return readerOptionFunc(func(s *Reader) {
+		s.hook = fn
+	})
+}
+
+// WithErrorLogger makes the Reader log every protocol error, see the
+// package documentation, and every invalid escape sequence stored as is
+// without WithStrictDecoding, to l with the position in the stream and
+// the start of the frame, e.g. for passive diagnostics of a lossy link
+// with WithAutoResync. The errors are still returned as usual. Good
+// packets are never logged.
+func WithErrorLogger(l Logger) ReaderOption {
+	return readerOptionFunc(func(s *Reader) {
+		s.logger = l
+	})
+}
+
+// WithWriteHook registers fn to be called with the payload of every
+// packet written and the error of writing it, before the call returns:
+// packets of WritePacket and the methods built on it, WriteString,
+// WritePacketv, WritePackets and the frames of a batch, which EndBatch
+// passes to fn. The END of WriteEnd is not a packet. With
+// WithWriteBuffering a nil error means the packet was buffered. frame must not be kept or
+// modified after fn returns.
+// fn runs while the Writer's mutex is held and must not call back into
+// the Writer.
+func WithWriteHook(fn func(frame []byte, err error)) WriterOption {
+	return writerOptionFunc(func(s *Writer) {
+		s.hook = fn
+	})
+}
@@ -0,0 +1,749 @@
+package slip
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"testing"
+	"testing/iotest"
+	"unicode/utf8"
+)
+
+func TestReaderOptions(t *testing.T) {
+	r := NewReader(&bytes.Buffer{}, WithMaxPacketSize(10), WithReadBufferSize(16))
+	if r.MaxPacketSize != 10 {
+		t.Error("Expected MaxPacketSize", 10, "but got", r.MaxPacketSize)
+	}
+	if len(r.buf) != 16 {
+		t.Error("Expected buffer size", 16, "but got", len(r.buf))
+	}
+}
+
+func TestReaderDefaults(t *testing.T) {
+	r := NewReader(&bytes.Buffer{}, WithReadBufferSize(0))
+	if r.MaxPacketSize != 0 {
+		t.Error("Expected MaxPacketSize", 0, "but got", r.MaxPacketSize)
+	}
+	if len(r.buf) != defaultBufSize {
+		t.Error("Expected buffer size", defaultBufSize, "but got", len(r.buf))
+	}
+}
+
+func TestControlBytes(t *testing.T) {
+	opt := WithControlBytes(0x7e, 0x7d, 0x5e, 0x5d)
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf, opt)
+	if err := w.WritePacket([]byte{1, 0x7e, 0x7d, END, ESC}); err != nil {
+		t.Error("Unexpected error:", err)
+	}
+
+	expected := []byte{0x7e, 1, 0x7d, 0x5e, 0x7d, 0x5d, END, ESC, 0x7e}
+	if !eqBytes(buf.Bytes(), expected) {
+		t.Error("Expected data", expected, "but got", buf.Bytes())
+	}
+
+	r := NewReader(buf, opt)
+	p, _, err := r.ReadPacket()
+	if err != nil {
+		t.Error("Unexpected error:", err)
+	}
+	if !eqBytes(p, []byte{1, 0x7e, 0x7d, END, ESC}) {
+		t.Error("Expected data", []byte{1, 0x7e, 0x7d, END, ESC}, "but got", p)
+	}
+}
+
+func TestControlBytesNotDistinct(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected panic for duplicate control bytes")
+		}
+	}()
+	NewReader(&bytes.Buffer{}, WithControlBytes(END, ESC, ESC, ESC_ESC))
+}
+
+func TestKeepEmptyFrames(t *testing.T) {
+	r := NewReader(bytes.NewReader([]byte{END, 1, END, END, 2, END}), WithKeepEmptyFrames(true))
+	expected := [][]byte{{}, {1}, {}, {2}}
+	for i, e := range expected {
+		p, _, err := r.ReadPacket()
+		if err != nil {
+			t.Error(i, "Unexpected error:", err)
+		}
+		if p == nil {
+			t.Error(i, "Expected non-nil packet")
+		}
+		if !eqBytes(p, e) {
+			t.Error(i, "Expected data", e, "but got", p)
+		}
+	}
+	if s := r.Stats(); s.EmptyFrames != 2 {
+		t.Error("Expected 2 empty frames but got", s.EmptyFrames)
+	}
+}
+
+func TestEmptyFrameCallback(t *testing.T) {
+	idle := 0
+	r := NewReader(bytes.NewReader([]byte{END, END, 1, END, END, 2, END}),
+		WithEmptyFrameCallback(func() { idle++ }))
+	p, _, err := r.ReadPacket()
+	if err != nil || !eqBytes([]byte{1}, p) || idle != 2 {
+		t.Error("Expected data", []byte{1}, "after 2 empty frames but got", p, err, idle)
+	}
+	p, _, err = r.ReadPacket()
+	if err != nil || !eqBytes([]byte{2}, p) || idle != 3 {
+		t.Error("Expected data", []byte{2}, "after 3 empty frames but got", p, err, idle)
+	}
+}
+
+func TestKeepEmptyFramesMux(t *testing.T) {
+	r := NewSlipMuxReader(bytes.NewReader([]byte{END, FRAME_DIAGNOSTIC, 'x', END}), WithKeepEmptyFrames(true))
+	p, frame, err := r.ReadPacket()
+	if err != nil {
+		t.Error("Unexpected error:", err)
+	}
+	if frame != FRAME_DIAGNOSTIC || !eqBytes(p, []byte{'x'}) {
+		t.Error("Expected diagnostic frame x but got", frame, p)
+	}
+}
+
+func TestAutoResync(t *testing.T) {
+	data := []byte{
+		END, 1, 2, 3, 4, END, // too large
+		END, 1, ESC, 1, 2, END, // invalid escape
+		END, 5, END,
+	}
+	r := NewReader(bytes.NewReader(data), WithMaxPacketSize(3), WithStrictDecoding(true), WithAutoResync(true))
+	p, _, err := r.ReadPacket()
+	if err != nil || !eqBytes([]byte{5}, p) {
+		t.Error("Expected data", []byte{5}, "but got", p, err)
+	}
+	if s := r.Stats(); s.Errors != 2 || s.FramesDropped != 2 {
+		t.Error("Expected 2 errors and dropped packets but got", s)
+	}
+	if _, _, err = r.ReadPacket(); err != io.EOF {
+		t.Error("Expected EOF but got", err)
+	}
+
+	dst := make([]byte, 2)
+	r = NewReader(bytes.NewReader(data), WithAutoResync(true))
+	if _, err = r.ReadPacketInto(dst); err != ErrBufferTooSmall {
+		t.Error("Expected error", ErrBufferTooSmall, "but got", err)
+	}
+	if s := r.Stats(); s.FramesDropped != 0 {
+		t.Error("Expected no dropped packets but got", s.FramesDropped)
+	}
+
+	// Errors are not counted as dropped without WithAutoResync
+	r = NewReader(bytes.NewReader(data), WithMaxPacketSize(3))
+	r.ReadPacket()
+	if s := r.Stats(); s.Errors != 1 || s.FramesDropped != 0 {
+		t.Error("Expected 1 error and no dropped packets but got", s)
+	}
+}
+
+func TestCopyOnRead(t *testing.T) {
+	data := []byte{END, 1, 2, END, 3, 4, END}
+
+	// By default packets may be kept
+	r := NewReader(bytes.NewReader(data), WithCopyOnRead(true))
+	p1, _, _ := r.ReadPacket()
+	p2, _, _ := r.ReadPacket()
+	if !eqBytes(p1, []byte{1, 2}) || !eqBytes(p2, []byte{3, 4}) {
+		t.Error("Expected packets [1 2] [3 4] but got", p1, p2)
+	}
+
+	r = NewReader(bytes.NewReader(data), WithCopyOnRead(false))
+	p1, _, _ = r.ReadPacket()
+	p2, _, _ = r.ReadPacket()
+	if &p1[0] != &p2[0] || !eqBytes(p2, []byte{3, 4}) {
+		t.Error("Expected the buffer to be reused but got", p1, p2)
+	}
+
+	// Packets copies anyway
+	packets, _ := NewReader(bytes.NewReader(data), WithCopyOnRead(false)).Packets(context.Background())
+	p1, p2 = <-packets, <-packets
+	if !eqBytes(p1, []byte{1, 2}) || !eqBytes(p2, []byte{3, 4}) {
+		t.Error("Expected packets [1 2] [3 4] but got", p1, p2)
+	}
+
+	packet := []byte{END, 1, 2, 3, END}
+	br := bytes.NewReader(packet)
+	r.Reset(br)
+	allocs := testing.AllocsPerRun(10, func() {
+		br.Reset(packet)
+		r.ReadPacket()
+	})
+	if allocs != 0 {
+		t.Error("Expected no allocations but got", allocs)
+	}
+}
+
+func TestLeadingEnd(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf, WithLeadingEnd(false))
+	w.WritePacket([]byte{1, END})
+	w.WriteString("a")
+	w.WritePackets([][]byte{{2}, {3}})
+	expected := []byte{1, ESC, ESC_END, END, 'a', END, 2, END, 3, END}
+	if !eqBytes(buf.Bytes(), expected) {
+		t.Error("Expected data", expected, "but got", buf.Bytes())
+	}
+
+	// Buffered writers too
+	buf.Reset()
+	w = NewWriter(buf, WithLeadingEnd(false), WithWriteBuffering(64))
+	w.WritePacket([]byte{1})
+	w.WriteString("a")
+	w.Flush()
+	if expected = []byte{1, END, 'a', END}; !eqBytes(buf.Bytes(), expected) {
+		t.Error("Expected data", expected, "but got", buf.Bytes())
+	}
+
+	// The reader does not need the leading END
+	r := NewReader(bytes.NewReader(buf.Bytes()))
+	for _, e := range [][]byte{{1}, {'a'}} {
+		if p, _, err := r.ReadPacket(); err != nil || !eqBytes(p, e) {
+			t.Error("Expected data", e, "but got", p, err)
+		}
+	}
+
+	buf.Reset()
+	NewWriter(buf, WithLeadingEnd(true)).WritePacket([]byte{1})
+	if expected = []byte{END, 1, END}; !eqBytes(buf.Bytes(), expected) {
+		t.Error("Expected data", expected, "but got", buf.Bytes())
+	}
+}
+
+func TestLeadingEndOnce(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf, WithLeadingEndOnce())
+	w.WritePacket([]byte{1})
+	w.WriteString("a")
+	w.WritePackets([][]byte{{2}, {3}})
+	expected := []byte{END, 1, END, 'a', END, 2, END, 3, END}
+	if !eqBytes(buf.Bytes(), expected) {
+		t.Error("Expected data", expected, "but got", buf.Bytes())
+	}
+
+	// Reset starts over
+	buf.Reset()
+	w.Reset(buf)
+	w.WritePacket([]byte{1})
+	w.WritePacket([]byte{2})
+	if expected = []byte{END, 1, END, 2, END}; !eqBytes(buf.Bytes(), expected) {
+		t.Error("Expected data", expected, "but got", buf.Bytes())
+	}
+
+	// A failed write brings the END back
+	lw := &limitedWriter{n: 4}
+	w = NewWriter(lw, WithLeadingEndOnce())
+	w.WritePacket([]byte{1})
+	if err := w.WritePacket([]byte{2, 3}); err != errLimit {
+		t.Error("Expected error", errLimit, "but got", err)
+	}
+	lw.n = 100
+	w.WritePacket([]byte{4})
+	w.WritePacket([]byte{5})
+	if expected = []byte{END, 1, END, 2, END, 4, END, 5, END}; !eqBytes(lw.Bytes(), expected) {
+		t.Error("Expected data", expected, "but got", lw.Bytes())
+	}
+
+	// Buffered writers too
+	buf.Reset()
+	w = NewWriter(buf, WithLeadingEndOnce(), WithWriteBuffering(64))
+	w.WritePacket([]byte{1})
+	w.WriteString("a")
+	w.Flush()
+	if expected = []byte{END, 1, END, 'a', END}; !eqBytes(buf.Bytes(), expected) {
+		t.Error("Expected data", expected, "but got", buf.Bytes())
+	}
+}
+
+type hookCall struct {
+	frame []byte
+	err   error
+}
+
+func TestReadHook(t *testing.T) {
+	data := []byte{END, 1, 2, END, END, 3, ESC, 4, END, 5, END, 6}
+	var calls []hookCall
+	hook := func(frame []byte, err error) {
+		calls = append(calls, hookCall{append([]byte(nil), frame...), err})
+	}
+	r := NewReader(bytes.NewReader(data), WithReadHook(hook), WithStrictDecoding(true), WithAutoResync(true))
+	for {
+		if _, _, err := r.ReadPacket(); err != nil {
+			break
+		}
+	}
+
+	expected := []hookCall{
+		{[]byte{1, 2}, nil},
+		{[]byte{3}, ErrInvalidEscape}, // dropped by the resync
+		{[]byte{5}, nil},
+		{[]byte{6}, io.ErrUnexpectedEOF},
+	}
+	if len(calls) != len(expected) {
+		t.Fatal("Expected", len(expected), "calls but got", calls)
+	}
+	for i, e := range expected {
+		if !eqBytes(calls[i].frame, e.frame) || !errors.Is(calls[i].err, e.err) {
+			t.Error(strconv.Itoa(i), "Expected call", e, "but got", calls[i])
+		}
+	}
+}
+
+func TestWriteHook(t *testing.T) {
+	var calls []hookCall
+	hook := func(frame []byte, err error) {
+		calls = append(calls, hookCall{append([]byte(nil), frame...), err})
+	}
+	w := NewWriter(&limitedWriter{n: 7}, WithWriteHook(hook))
+	w.WritePacket([]byte{1})
+	w.WriteString("a")
+	w.WritePackets([][]byte{{2}, {3}})
+
+	expected := []hookCall{
+		{[]byte{1}, nil},
+		{[]byte{'a'}, nil},
+		{[]byte{2}, errLimit},
+		{[]byte{3}, errLimit},
+	}
+	if len(calls) != len(expected) {
+		t.Fatal("Expected", len(expected), "calls but got", calls)
+	}
+	for i, e := range expected {
+		if !eqBytes(calls[i].frame, e.frame) || !errors.Is(calls[i].err, e.err) {
+			t.Error(strconv.Itoa(i), "Expected call", e, "but got", calls[i])
+		}
+	}
+}
+
+func TestFramingDisabled(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf, WithFramingDisabled(true))
+	w.WritePacket([]byte{1, END, 2})
+	w.WriteString("abc")
+	w.WritePacketv([]byte{ESC}, []byte{3})
+	expected := []byte{1, ESC, ESC_END, 2, 'a', 'b', 'c', ESC, ESC_ESC, 3}
+	if !eqBytes(buf.Bytes(), expected) {
+		t.Fatal("Expected data", expected, "but got", buf.Bytes())
+	}
+
+	r := NewReader(iotest.OneByteReader(bytes.NewReader(buf.Bytes())), WithFramingDisabled(true), WithMaxPacketSize(3))
+	dst := make([]byte, 3)
+	if n, err := r.ReadPacketInto(dst); err != nil || !eqBytes([]byte{1, END, 2}, dst[:n]) {
+		t.Error("Expected data", []byte{1, END, 2}, "but got", dst[:n], err)
+	}
+	if p, _, err := r.ReadPacket(); err != nil || string(p) != "abc" {
+		t.Error("Expected data abc but got", p, err)
+	}
+	if p, err := r.ReadPacketLimited(2); err != nil || !eqBytes([]byte{ESC, 3}, p) {
+		t.Error("Expected data", []byte{ESC, 3}, "but got", p, err)
+	}
+	if _, _, err := r.ReadPacket(); err != io.EOF {
+		t.Error("Expected error", io.EOF, "but got", err)
+	}
+
+	r = NewReader(bytes.NewReader([]byte{1, END}), WithFramingDisabled(true))
+	if _, err := r.ReadPacketLimited(0); err != ErrNoFrameLength {
+		t.Error("Expected error", ErrNoFrameLength, "but got", err)
+	}
+	if _, err := r.ReadPacketLimited(2); err != ErrUnescapedEnd {
+		t.Error("Expected error", ErrUnescapedEnd, "but got", err)
+	}
+}
+
+func TestMaxEscapeRatio(t *testing.T) {
+	data := []byte{
+		END, ESC, ESC_END, ESC, ESC_ESC, 1, 2, END, // half escaped
+		END, ESC, ESC_END, ESC, ESC_ESC, ESC, ESC_END, 3, END,
+		END, 4, END,
+	}
+	r := NewReader(iotest.OneByteReader(bytes.NewReader(data)), WithMaxEscapeRatio(0.5))
+	if p, _, err := r.ReadPacket(); err != nil || !eqBytes([]byte{END, ESC, 1, 2}, p) {
+		t.Error("Expected data", []byte{END, ESC, 1, 2}, "but got", p, err)
+	}
+	if p, _, err := r.ReadPacket(); err != ErrSuspiciousFrame || len(p) != 0 {
+		t.Error("Expected error", ErrSuspiciousFrame, "but got", p, err)
+	}
+	if p, _, err := r.ReadPacket(); err != nil || !eqBytes([]byte{4}, p) {
+		t.Error("Expected data", []byte{4}, "but got", p, err)
+	}
+
+	// Off by default
+	r = NewReader(bytes.NewReader(data[8:]))
+	if _, _, err := r.ReadPacket(); err != nil {
+		t.Error("Unexpected error", err)
+	}
+}
+
+func TestZeroReadRetry(t *testing.T) {
+	data := []byte{END, 1, ESC, ESC_END, 2, END}
+	r := NewReader(&stutterReader{r: bytes.NewReader(data)}, WithZeroReadRetry(false))
+	var p []byte
+	var err error
+	noProgress := 0
+	for i := 0; i < 100; i++ {
+		if p, _, err = r.ReadPacket(); err != io.ErrNoProgress {
+			break
+		}
+		noProgress++
+	}
+	if err != nil || !eqBytes([]byte{1, END, 2}, p) {
+		t.Error("Expected data", []byte{1, END, 2}, "but got", p, err)
+	}
+	if noProgress != 3*len(data) {
+		t.Error("Expected", 3*len(data), "times", io.ErrNoProgress, "but got", noProgress)
+	}
+
+	// The stutterReader needs 4 reads per byte
+	r = NewReader(&stutterReader{r: bytes.NewReader(data)}, WithZeroReadRetryLimit(3))
+	if _, _, err = r.ReadPacket(); err != io.ErrNoProgress {
+		t.Error("Expected error", io.ErrNoProgress, "but got", err)
+	}
+	r = NewReader(&stutterReader{r: bytes.NewReader(data)}, WithZeroReadRetryLimit(4))
+	if p, _, err = r.ReadPacket(); err != nil || !eqBytes([]byte{1, END, 2}, p) {
+		t.Error("Expected data", []byte{1, END, 2}, "but got", p, err)
+	}
+}
+
+func TestReadLimit(t *testing.T) {
+	data := []byte{END, 1, END, 2, ESC, ESC_END, END, 4, 5, END}
+	src := bytes.NewReader(data)
+	r := NewReader(src, WithReadLimit(9), WithReadBufferSize(4))
+	for i, expected := range [][]byte{{1}, {2, END}} {
+		p, _, err := r.ReadPacket()
+		if err != nil || !eqBytes(expected, p) {
+			t.Error(strconv.Itoa(i), "Expected data", expected, "but got", p, err)
+		}
+	}
+	p, isPrefix, err := r.ReadPacket()
+	if err != ErrReadLimitExceeded || !isPrefix || !eqBytes([]byte{4, 5}, p) {
+		t.Error("Expected partial packet with error", ErrReadLimitExceeded, "but got", p, isPrefix, err)
+	}
+	if _, _, err = r.ReadPacket(); err != ErrReadLimitExceeded {
+		t.Error("Expected error", ErrReadLimitExceeded, "but got", err)
+	}
+	if src.Len() != 1 {
+		t.Error("Expected 1 byte left unread but got", src.Len())
+	}
+
+	r.Reset(bytes.NewReader(data))
+	if p, _, err = r.ReadPacket(); err != nil || !eqBytes([]byte{1}, p) {
+		t.Error("Expected data", []byte{1}, "but got", p, err)
+	}
+}
+
+func TestInitialReadCapacity(t *testing.T) {
+	data := []byte{END, 1, 2, END, 3, 4, 5, 6, 7, END, 8, END}
+	r := NewReader(bytes.NewReader(data), WithInitialReadCapacity(4))
+	p1, _, _ := r.ReadPacket()
+	p2, _, _ := r.ReadPacket()
+	p3, _, _ := r.ReadPacket()
+	if !eqBytes(p1, []byte{1, 2}) || !eqBytes(p2, []byte{3, 4, 5, 6, 7}) || !eqBytes(p3, []byte{8}) {
+		t.Error("Expected packets [1 2] [3 4 5 6 7] [8] but got", p1, p2, p3)
+	}
+	// The caller gets copies of the exact size
+	if cap(p1) != 2 || cap(p3) != 1 {
+		t.Error("Expected exact capacities but got", cap(p1), cap(p3))
+	}
+	if p, _, err := r.ReadPacket(); p != nil || err != io.EOF {
+		t.Error("Expected EOF but got", p, err)
+	}
+
+	r = NewReader(bytes.NewReader(data), WithInitialReadCapacity(4), WithCopyOnRead(false))
+	p1, _, _ = r.ReadPacket()
+	if cap(p1) != 4 {
+		t.Error("Expected capacity 4 but got", cap(p1))
+	}
+	// The grown buffer is released after the large packet
+	p2, _, _ = r.ReadPacket()
+	p3, _, _ = r.ReadPacket()
+	if &p1[0] == &p3[0] || cap(p3) != 4 || !eqBytes(p2, []byte{3, 4, 5, 6, 7}) {
+		t.Error("Expected a new buffer of capacity 4 but got", cap(p3), p2)
+	}
+}
+
+func TestExtraEscapedBytes(t *testing.T) {
+	opt := WithExtraEscapedBytes(0x11, 0x13, 0x11)
+	payload := []byte{0x11, 1, END, 0x13, 0xde, ESC}
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf, opt)
+	w.WritePacket(payload)
+	w.WriteString(string(payload))
+	expected := []byte{END, ESC, 0xde, 1, ESC, ESC_END, ESC, 0xdf, 0xde, ESC, ESC_ESC, END}
+	if !eqBytes(expected, buf.Bytes()[:len(expected)]) {
+		t.Fatal("Expected data", expected, "but got", buf.Bytes())
+	}
+	if bytes.IndexByte(buf.Bytes(), 0x11) >= 0 || bytes.IndexByte(buf.Bytes(), 0x13) >= 0 {
+		t.Error("Expected no extra bytes on the wire but got", buf.Bytes())
+	}
+
+	packets, errs := feed(NewDecoder(opt, WithStrictDecoding(true)), buf.Bytes())
+	r := NewReader(buf, opt, WithStrictDecoding(true))
+	for i := 0; i < 2; i++ {
+		if p, _, err := r.ReadPacket(); err != nil || !eqBytes(payload, p) {
+			t.Error(strconv.Itoa(i), "Expected data", payload, "but got", p, err)
+		}
+		if len(errs) != 0 || !eqBytes(payload, packets[i]) {
+			t.Error(strconv.Itoa(i), "Expected data", payload, "but got", packets[i], errs)
+		}
+	}
+	if out := encode(NewEncoder(opt), payload); !eqBytes(expected, out) {
+		t.Error("Expected data", expected, "but got", out)
+	}
+}
+
+func TestExtraEscapedBytesInvalid(t *testing.T) {
+	var all []byte
+	for b := 0; b < 256; b++ {
+		if b != END && b != ESC && b != ESC_END && b != ESC_ESC {
+			all = append(all, byte(b))
+		}
+	}
+	for i, extra := range [][]byte{{END}, {ESC_ESC}, all} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Error(strconv.Itoa(i), "Expected NewWriter to panic")
+				}
+			}()
+			NewWriter(&bytes.Buffer{}, WithExtraEscapedBytes(extra...))
+		}()
+	}
+}
+
+func TestFlagSequence(t *testing.T) {
+	flag := WithFlagSequence([]byte{0x7e, 0x7e})
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf, flag)
+	w.WritePacket([]byte{1, 0x7e, 2})
+	expected := []byte{0x7e, 0x7e, 1, ESC, ESC_END, 2, 0x7e, 0x7e}
+	if !eqBytes(expected, buf.Bytes()) {
+		t.Error("Expected data", expected, "but got", buf.Bytes())
+	}
+	w.WritePacketv([]byte{0x7e}, []byte{0x7e, END})
+	w.WriteString("ab")
+	w.WriteEnd()
+
+	packets := [][]byte{{1, 0x7e, 2}, {0x7e, 0x7e, END}, []byte("ab")}
+	r := NewReader(iotest.OneByteReader(bytes.NewReader(buf.Bytes())), flag)
+	for i, expected := range packets {
+		p, _, err := r.ReadPacket()
+		if err != nil || !eqBytes(expected, p) {
+			t.Error(strconv.Itoa(i), "Expected data", expected, "but got", p, err)
+		}
+	}
+	if _, _, err := r.ReadPacket(); err != io.EOF {
+		t.Error("Expected error", io.EOF, "but got", err)
+	}
+	if s := r.Stats(); s.EmptyFrames != 4 {
+		t.Error("Expected 4 empty frames but got", s.EmptyFrames)
+	}
+
+	// A damaged flag still ends the frame
+	r = NewReader(bytes.NewReader([]byte{0x7e, 1, 0x7e, 0x7e, 2, 0x7e, 3, 0x7e, 0x7e}), flag)
+	for i, expected := range [][]byte{{1}, {2}, {3}} {
+		p, _, err := r.ReadPacket()
+		if err != nil || !eqBytes(expected, p) {
+			t.Error(strconv.Itoa(i), "Expected data", expected, "but got", p, err)
+		}
+	}
+
+	// The flag wins over WithControlBytes, a longer one works too
+	buf.Reset()
+	flag = WithFlagSequence([]byte{0x7e, 0x81, 0x7e})
+	NewWriter(buf, WithControlBytes(1, 2, 3, 4), flag).WritePacket([]byte{0x7e, 1, 2})
+	expected = []byte{0x7e, 0x81, 0x7e, 2, 3, 1, 2, 4, 0x7e, 0x81, 0x7e}
+	if !eqBytes(expected, buf.Bytes()) {
+		t.Error("Expected data", expected, "but got", buf.Bytes())
+	}
+	r = NewReader(buf, flag, WithControlBytes(1, 2, 3, 4))
+	if p, _, err := r.ReadPacket(); err != nil || !eqBytes([]byte{0x7e, 1, 2}, p) {
+		t.Error("Expected data", []byte{0x7e, 1, 2}, "but got", p, err)
+	}
+}
+
+func TestTap(t *testing.T) {
+	data := []byte{END, 1, END, END, 2, ESC, ESC_END, END, END, 3, END}
+	tap := &bytes.Buffer{}
+	r := NewReader(bytes.NewReader(data), WithTap(tap, nil))
+	packets, err := r.ReadAll()
+	if err != nil || len(packets) != 3 {
+		t.Fatal("Expected 3 packets but got", packets, err)
+	}
+	expected := []byte{END, 1, END, END, 2, ESC, ESC_END, END, END, 3, END}
+	if !eqBytes(expected, tap.Bytes()) {
+		t.Error("Expected data", expected, "but got", tap.Bytes())
+	}
+
+	// Errors are ignored by default
+	r = NewReader(bytes.NewReader(data), WithTap(&limitedWriter{n: 4}, nil))
+	if packets, err = r.ReadAll(); err != nil || len(packets) != 3 {
+		t.Error("Expected 3 packets but got", packets, err)
+	}
+
+	// or passed to the policy
+	var tapErrs []error
+	policy := func(err error) error {
+		tapErrs = append(tapErrs, err)
+		if len(tapErrs) > 1 {
+			return err
+		}
+		return nil
+	}
+	r = NewReader(bytes.NewReader(data), WithTap(&limitedWriter{n: 3}, policy))
+	dst := make([]byte, 4)
+	if n, err := r.ReadPacketInto(dst); err != nil || n != 1 {
+		t.Error("Expected 1 byte but got", n, err)
+	}
+	if n, err := r.ReadPacketInto(dst); err != nil || n != 2 {
+		t.Error("Expected 2 bytes but got", n, err)
+	}
+	n, err := r.ReadPacketInto(dst)
+	var terr *TapError
+	if !errors.As(err, &terr) || !errors.Is(err, errLimit) || n != 1 || dst[0] != 3 {
+		t.Error("Expected TapError wrapping", errLimit, "with the packet but got", dst[:n], err)
+	}
+	if len(tapErrs) != 2 {
+		t.Error("Expected 2 tap errors but got", tapErrs)
+	}
+}
+
+type recordingLogger struct {
+	lines []string
+}
+
+func (l *recordingLogger) Printf(format string, v ...interface{}) {
+	l.lines = append(l.lines, fmt.Sprintf(format, v...))
+}
+
+func TestErrorLogger(t *testing.T) {
+	data := []byte{END, 1, END, 2, ESC, 3, END, 4, 5, 6, END, 7, END}
+	l := &recordingLogger{}
+	r := NewReader(bytes.NewReader(data), WithErrorLogger(l), WithMaxPacketSize(2), WithAutoResync(true))
+	for i, expected := range [][]byte{{1}, {2, 3}, {7}} {
+		p, _, err := r.ReadPacket()
+		if err != nil || !eqBytes(expected, p) {
+			t.Error(strconv.Itoa(i), "Expected data", expected, "but got", p, err)
+		}
+	}
+	expected := []string{
+		"slip: invalid escape 0x03 at stream offset 5, frame offset 1, stored as is",
+		"slip: frame dropped at stream offset 11 after 2 bytes [04 05]: " + ErrPacketTooLarge.Error(),
+	}
+	if len(l.lines) != len(expected) {
+		t.Fatal("Expected", len(expected), "lines but got", l.lines)
+	}
+	for i := range expected {
+		if l.lines[i] != expected[i] {
+			t.Error(strconv.Itoa(i), "Expected", expected[i], "but got", l.lines[i])
+		}
+	}
+}
+
+func TestFrameValidator(t *testing.T) {
+	data := []byte{END, 'o', 'k', END, 0xff, 0xfe, END, 'h', 'i', END}
+	valid := func(frame []byte) error {
+		if !utf8.Valid(frame) {
+			return errLimit
+		}
+		return nil
+	}
+	r := NewReader(bytes.NewReader(data), WithFrameValidator(valid))
+	for i, expected := range []string{"ok", "", "hi"} {
+		p, _, err := r.ReadPacket()
+		if expected == "" {
+			var ferr *FrameError
+			if !errors.As(err, &ferr) || !errors.Is(err, ErrInvalidFrame) || !errors.Is(err, errLimit) || len(p) != 0 {
+				t.Error(strconv.Itoa(i), "Expected FrameError wrapping", errLimit, "but got", p, err)
+			}
+			continue
+		}
+		if err != nil || string(p) != expected {
+			t.Error(strconv.Itoa(i), "Expected data", expected, "but got", p, err)
+		}
+	}
+	// The rejected frame was decoded, so it is counted as a packet too
+	if s := r.Stats(); s.Errors != 1 || s.Packets != 3 {
+		t.Error("Expected 1 error and 3 packets but got", s)
+	}
+
+	// Rejected frames are skipped with WithAutoResync
+	r = NewReader(bytes.NewReader(data), WithFrameValidator(valid), WithAutoResync(true))
+	packets, err := r.ReadAll()
+	if err != nil || len(packets) != 2 || string(packets[1]) != "hi" {
+		t.Error("Expected 2 packets but got", packets, err)
+	}
+}
+
+func TestSkipToFirstEnd(t *testing.T) {
+	data := []byte{3, ESC, ESC_END, 4, END, 1, END, END, 2, END}
+	r := NewReader(iotest.OneByteReader(bytes.NewReader(data)), WithSkipToFirstEnd(true))
+	for i, expected := range [][]byte{{1}, {2}} {
+		p, _, err := r.ReadPacket()
+		if err != nil || !eqBytes(expected, p) {
+			t.Error(strconv.Itoa(i), "Expected data", expected, "but got", p, err)
+		}
+		if n := r.LastOverflowBytes(); i == 0 && n != 5 {
+			t.Error("Expected 5 bytes dropped but got", n)
+		}
+	}
+
+	// Only the start of the stream is skipped, Reset starts anew
+	r.Reset(bytes.NewReader([]byte{END, 5, END}))
+	if p, _, err := r.ReadPacket(); err != nil || !eqBytes([]byte{5}, p) {
+		t.Error("Expected data", []byte{5}, "but got", p, err)
+	}
+	r.Reset(bytes.NewReader([]byte{6, END, 7, END}))
+	if p, _, err := r.ReadPacket(); err != nil || !eqBytes([]byte{7}, p) {
+		t.Error("Expected data", []byte{7}, "but got", p, err)
+	}
+}
+
+func TestRequireLeadingEnd(t *testing.T) {
+	r := NewReader(bytes.NewReader([]byte{1, 2, END, 3, END, 4, END}), WithRequireLeadingEnd(true))
+	if p, _, err := r.ReadPacket(); err != ErrMissingLeadingEnd || len(p) != 0 {
+		t.Error("Expected error", ErrMissingLeadingEnd, "but got", p, err)
+	}
+	// Once framed, a trailing END is enough
+	for i, expected := range [][]byte{{3}, {4}} {
+		if p, _, err := r.ReadPacket(); err != nil || !eqBytes(expected, p) {
+			t.Error(strconv.Itoa(i), "Expected data", expected, "but got", p, err)
+		}
+	}
+
+	r.Reset(bytes.NewReader([]byte{END, 5, END}))
+	if p, _, err := r.ReadPacket(); err != nil || !eqBytes([]byte{5}, p) {
+		t.Error("Expected data", []byte{5}, "but got", p, err)
+	}
+	r.Reset(bytes.NewReader([]byte{6, END}))
+	if _, _, err := r.ReadPacket(); err != ErrMissingLeadingEnd {
+		t.Error("Expected error", ErrMissingLeadingEnd, "but got", err)
+	}
+
+	// By default data before the first END is a packet
+	r = NewReader(bytes.NewReader([]byte{1, 2, END}))
+	if p, _, err := r.ReadPacket(); err != nil || !eqBytes([]byte{1, 2}, p) {
+		t.Error("Expected data", []byte{1, 2}, "but got", p, err)
+	}
+}
+
+func TestLargeFrameWarning(t *testing.T) {
+	data := []byte{END, 1, 2, END, 1, 2, 3, END, 1, ESC, ESC_END, 3, 4, END, 5, END}
+	var sizes []int
+	r := NewReader(bytes.NewReader(data), WithLargeFrameWarning(2, func(size int) {
+		sizes = append(sizes, size)
+	}))
+	packets, err := r.ReadAll()
+	if err != nil || len(packets) != 4 {
+		t.Error("Expected 4 packets but got", packets, err)
+	}
+	if len(sizes) != 2 || sizes[0] != 3 || sizes[1] != 4 {
+		t.Error("Expected warnings for sizes [3 4] but got", sizes)
+	}
+}
@@ -0,0 +1,74 @@
+package slip
+
+import (
+	"net"
+	"time"
+)
+
+// streamAddr is the address of every packet of a PacketConn.
+type streamAddr struct{}
+
+func (streamAddr) Network() string { return "slip" }
+func (streamAddr) String() string  { return "slip" }
+
+// PacketConn implements net.PacketConn on top of a Conn: every packet
+// is one datagram. A SLIP stream has a single peer, so all packets come
+// from and go to the same stub address.
+type PacketConn struct {
+	c *Conn
+}
+
+var _ net.PacketConn = (*PacketConn)(nil)
+
+// NewPacketConn returns a PacketConn sending and receiving the packets
+// of c. Configure c, like c.Reader.MaxPacketSize, before using the
+// PacketConn.
+func NewPacketConn(c *Conn) *PacketConn {
+	return &PacketConn{c: c}
+}
+
+// ReadFrom reads the next packet into p. Like a UDP socket it
+// truncates packets that do not fit into p, but it reports them with
+// ErrBufferTooSmall. Packets larger than Reader.MaxPacketSize are
+// dropped with ErrPacketTooLarge. If the stream fails in the middle of
+// a packet, n is zero and the packet is completed by the next call.
+func (pc *PacketConn) ReadFrom(p []byte) (n int, addr net.Addr, err error) {
+	n, err = pc.c.ReadPacketInto(p)
+	if err != nil && err != ErrBufferTooSmall && err != ErrPacketTooLarge {
+		n = 0
+	}
+	return n, streamAddr{}, err
+}
+
+// WriteTo writes p as one packet. addr is ignored.
+func (pc *PacketConn) WriteTo(p []byte, addr net.Addr) (n int, err error) {
+	if err = pc.c.WritePacket(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close closes the Conn.
+func (pc *PacketConn) Close() error {
+	return pc.c.Close()
+}
+
+// LocalAddr returns the stub address of the stream.
+func (pc *PacketConn) LocalAddr() net.Addr {
+	return streamAddr{}
+}
+
+// SetDeadline calls Conn.SetDeadline.
+func (pc *PacketConn) SetDeadline(t time.Time) error {
+	return pc.c.SetDeadline(t)
+}
+
+// SetReadDeadline calls Reader.SetReadDeadline.
+func (pc *PacketConn) SetReadDeadline(t time.Time) error {
+	return pc.c.SetReadDeadline(t)
+}
+
+// SetWriteDeadline calls Writer.SetWriteDeadline.
+func (pc *PacketConn) SetWriteDeadline(t time.Time) error {
+	return pc.c.SetWriteDeadline(t)
+}
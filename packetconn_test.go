@@ -0,0 +1,55 @@
+package slip
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+// duplex joins a reader and a writer
+type duplex struct {
+	io.Reader
+	io.Writer
+}
+
+func TestPacketConn(t *testing.T) {
+	out := &bytes.Buffer{}
+	in := bytes.NewReader([]byte{END, 1, 2, END, 3, 4, 5, 6, END, END, 7, 8, 9, END, 10, END})
+	c := NewConn(duplex{in, out})
+	c.Reader.MaxPacketSize = 3
+	pc := NewPacketConn(c)
+
+	n, err := pc.WriteTo([]byte{END}, nil)
+	if err != nil || n != 1 {
+		t.Error("Expected 1 byte but got", n, err)
+	}
+	if expected := []byte{END, ESC, ESC_END, END}; !eqBytes(out.Bytes(), expected) {
+		t.Error("Expected data", expected, "but got", out.Bytes())
+	}
+
+	p := make([]byte, 3)
+	n, addr, err := pc.ReadFrom(p)
+	if err != nil || !eqBytes(p[:n], []byte{1, 2}) {
+		t.Error("Expected data", []byte{1, 2}, "but got", p[:n], err)
+	}
+	if addr == nil || addr.Network() != "slip" || pc.LocalAddr().String() != addr.String() {
+		t.Error("Expected stub address but got", addr)
+	}
+
+	// MaxPacketSize is respected
+	if _, _, err = pc.ReadFrom(make([]byte, 10)); err != ErrPacketTooLarge {
+		t.Error("Expected error", ErrPacketTooLarge, "but got", err)
+	}
+
+	// Small buffers truncate the packet
+	n, _, err = pc.ReadFrom(p[:2])
+	if err != ErrBufferTooSmall || !eqBytes(p[:n], []byte{7, 8}) {
+		t.Error("Expected error", ErrBufferTooSmall, "and data", []byte{7, 8}, "but got", p[:n], err)
+	}
+	if n, _, err = pc.ReadFrom(p); err != nil || !eqBytes(p[:n], []byte{10}) {
+		t.Error("Expected data", []byte{10}, "but got", p[:n], err)
+	}
+	if n, _, err = pc.ReadFrom(p); err != io.EOF || n != 0 {
+		t.Error("Expected error", io.EOF, "but got", n, err)
+	}
+}
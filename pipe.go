@@ -0,0 +1,84 @@
+package slip
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// Pipe returns two connected Conns, like net.Pipe: packets written to
+// one are read from the other. Unlike net.Pipe the pipe is buffered
+// without limit, so a write never waits for the reader and a single
+// goroutine can write packets and then read them on the other end.
+// A read blocks until data is available. Closing either Conn makes
+// reads on the other return io.EOF once the buffered packets are
+// drained, and writes on both return io.ErrClosedPipe.
+// The options apply to both Conns.
+func Pipe(opts ...Option) (*Conn, *Conn) {
+	a, b := newPipeBuffer(), newPipeBuffer()
+	return NewConn(&pipeEnd{r: a, w: b}, opts...), NewConn(&pipeEnd{r: b, w: a}, opts...)
+}
+
+// pipeBuffer is one direction of a Pipe.
+type pipeBuffer struct {
+	mu     sync.Mutex
+	cond   sync.Cond
+	buf    bytes.Buffer
+	closed bool
+}
+
+func newPipeBuffer() *pipeBuffer {
+	b := &pipeBuffer{}
+	b.cond.L = &b.mu
+	return b
+}
+
+func (b *pipeBuffer) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for b.buf.Len() == 0 && !b.closed {
+		b.cond.Wait()
+	}
+	if b.buf.Len() == 0 {
+		return 0, io.EOF
+	}
+	return b.buf.Read(p)
+}
+
+func (b *pipeBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return 0, io.ErrClosedPipe
+	}
+	b.cond.Broadcast()
+	return b.buf.Write(p)
+}
+
+func (b *pipeBuffer) close() {
+	b.mu.Lock()
+	b.closed = true
+	b.cond.Broadcast()
+	b.mu.Unlock()
+}
+
+// pipeEnd is the stream of one Conn of a Pipe.
+type pipeEnd struct {
+	r, w *pipeBuffer
+}
+
+func (e *pipeEnd) Read(p []byte) (int, error) {
+	return e.r.Read(p)
+}
+
+func (e *pipeEnd) Write(p []byte) (int, error) {
+	return e.w.Write(p)
+}
+
+// Close closes both directions, so a read blocked on this end returns
+// too.
+func (e *pipeEnd) Close() error {
+	e.r.close()
+	e.w.close()
+	return nil
+}
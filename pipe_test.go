@@ -0,0 +1,79 @@
+package slip
+
+import (
+	"io"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestPipe(t *testing.T) {
+	a, b := Pipe()
+	packets := [][]byte{{1, 2}, {END, ESC}, {3}}
+	for _, p := range packets {
+		if err := a.WritePacket(p); err != nil {
+			t.Fatal("Unexpected error", err)
+		}
+	}
+	b.WriteString("back")
+
+	for i, expected := range packets {
+		p, _, err := b.ReadPacket()
+		if err != nil || !eqBytes(expected, p) {
+			t.Error(strconv.Itoa(i), "Expected data", expected, "but got", p, err)
+		}
+	}
+	if p, _, err := a.ReadPacket(); err != nil || string(p) != "back" {
+		t.Error("Expected data back but got", p, err)
+	}
+}
+
+func TestPipeClose(t *testing.T) {
+	a, b := Pipe()
+	a.WritePacket([]byte{1})
+	if err := a.Close(); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	// Buffered packets are still delivered
+	if p, _, err := b.ReadPacket(); err != nil || !eqBytes([]byte{1}, p) {
+		t.Error("Expected data", []byte{1}, "but got", p, err)
+	}
+	if _, _, err := b.ReadPacket(); err != io.EOF {
+		t.Error("Expected error", io.EOF, "but got", err)
+	}
+	if err := b.WritePacket([]byte{2}); err != io.ErrClosedPipe {
+		t.Error("Expected error", io.ErrClosedPipe, "but got", err)
+	}
+}
+
+func TestPipeBlockedRead(t *testing.T) {
+	a, b := Pipe()
+	done := make(chan error)
+	go func() {
+		_, _, err := b.ReadPacket()
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		t.Fatal("Expected ReadPacket to block but got", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+	a.WritePacket([]byte{1})
+	if err := <-done; err != nil {
+		t.Error("Unexpected error", err)
+	}
+
+	// Close unblocks a read on the same end
+	go func() {
+		_, _, err := b.ReadPacket()
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	b.Close()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected Close to unblock ReadPacket")
+	}
+}
@@ -0,0 +1,106 @@
+package slip
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+)
+
+// ScanPackets is a split function for a bufio.Scanner that returns each
+// decoded SLIP packet as a token. Empty packets between consecutive END
+// bytes are skipped. At EOF a final packet without terminating END is
+// returned as the last token, like bufio.ScanLines does for a last line
+// without newline.
+//
+// Packets are decoded in place, so the token aliases the data of the
+// scanner. Escape sequences are checked as by Decode and the scan stops
+// with ErrInvalidEscape or ErrIncompleteEscape on a protocol violation.
+func ScanPackets(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	// Skip the END bytes in front of the packet
+	start := 0
+	for start < len(data) && data[start] == END {
+		start++
+	}
+
+	if i := bytes.IndexByte(data[start:], END); i >= 0 {
+		token, err = Decode(data[start:start], data[start:start+i])
+		return start + i + 1, token, err
+	}
+
+	if atEOF && start < len(data) {
+		token, err = Decode(data[start:start], data[start:])
+		return len(data), token, err
+	}
+
+	// Request more data
+	return start, nil, nil
+}
+
+// Scanner reads the decoded packets of a SLIP stream one at a time,
+// modeled on bufio.Scanner:
+//
+//	s := slip.NewScanner(r)
+//	for s.Scan() {
+//		handle(s.Bytes())
+//	}
+//	if err := s.Err(); err != nil {
+//		...
+//	}
+//
+// Unlike ScanPackets it decodes with a Reader, so the Reader options
+// apply, and a stream ending inside a packet is an error.
+type Scanner struct {
+	r     *Reader
+	token []byte
+	err   error
+	used  bool // Scan has been called
+}
+
+// NewScanner returns a Scanner reading packets from r, configured by
+// opts like a Reader. Packets longer than bufio.MaxScanTokenSize stop
+// the scan with ErrPacketTooLarge, unless WithMaxPacketSize or
+// MaxTokenSize sets another limit.
+func NewScanner(r io.Reader, opts ...ReaderOption) *Scanner {
+	s := &Scanner{r: NewReader(r, opts...)}
+	if s.r.MaxPacketSize <= 0 {
+		s.r.MaxPacketSize = bufio.MaxScanTokenSize
+	}
+	return s
+}
+
+// MaxTokenSize sets the size of the largest packet the scan accepts.
+// It panics if it is called after scanning has started.
+func (s *Scanner) MaxTokenSize(n int) {
+	if s.used {
+		panic("slip: MaxTokenSize called after Scan")
+	}
+	s.r.MaxPacketSize = n
+}
+
+// Scan advances to the next packet, which is then available through
+// Bytes. It returns false at the end of the stream or on the first
+// error, which Err reports.
+func (s *Scanner) Scan() bool {
+	s.used = true
+	if s.err != nil {
+		s.token = nil
+		return false
+	}
+	s.token, s.err = s.r.NextFrame()
+	return s.err == nil
+}
+
+// Bytes returns the packet of the last successful Scan. It aliases a
+// buffer that the next call to Scan overwrites.
+func (s *Scanner) Bytes() []byte {
+	return s.token
+}
+
+// Err returns the error that stopped the scan, nil if it was the end
+// of the stream.
+func (s *Scanner) Err() error {
+	if s.err == io.EOF {
+		return nil
+	}
+	return s.err
+}
@@ -0,0 +1,107 @@
+package slip
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+	"testing"
+	"testing/iotest"
+)
+
+var scanData = []struct {
+	data     []byte
+	expected [][]byte
+	err      error
+}{
+	{[]byte{END, 1, 2, END, END, END, 3, END}, [][]byte{{1, 2}, {3}}, nil},
+	{[]byte{1, ESC, ESC_END, END, ESC, ESC_ESC, END}, [][]byte{{1, END}, {ESC}}, nil},
+	{[]byte{END, END}, [][]byte{}, nil},
+	{[]byte{}, [][]byte{}, nil},
+	// Last packet without END is returned at EOF
+	{[]byte{1, END, 2, 3}, [][]byte{{1}, {2, 3}}, nil},
+	// Protocol violations
+	{[]byte{1, END, 2, ESC, 3, END}, [][]byte{{1}}, ErrInvalidEscape},
+	{[]byte{1, END, 2, ESC}, [][]byte{{1}}, ErrIncompleteEscape},
+}
+
+func TestScanPackets(t *testing.T) {
+	for i, d := range scanData {
+		// One byte reads force the split function to request more data
+		s := bufio.NewScanner(iotest.OneByteReader(bytes.NewReader(d.data)))
+		s.Split(ScanPackets)
+
+		n := 0
+		for s.Scan() {
+			if n >= len(d.expected) {
+				t.Error(strconv.Itoa(i), "Unexpected packet", s.Bytes())
+			} else if !eqBytes(s.Bytes(), d.expected[n]) {
+				t.Error(strconv.Itoa(i), "Expected data", d.expected[n], "but got", s.Bytes())
+			}
+			n++
+		}
+		if n != len(d.expected) {
+			t.Error(strconv.Itoa(i), "Expected", len(d.expected), "packets but got", n)
+		}
+		if !errors.Is(s.Err(), d.err) {
+			t.Error(strconv.Itoa(i), "Expected error", d.err, "but got", s.Err())
+		}
+	}
+}
+
+func TestScanner(t *testing.T) {
+	for i, d := range []struct {
+		data     []byte
+		expected [][]byte
+		err      error
+	}{
+		{[]byte{END, 1, 2, END, END, END, 3, END}, [][]byte{{1, 2}, {3}}, nil},
+		{[]byte{1, ESC, ESC_END, END, ESC, ESC_ESC, END}, [][]byte{{1, END}, {ESC}}, nil},
+		{[]byte{}, [][]byte{}, nil},
+		// A last packet without END is an error
+		{[]byte{1, END, 2, 3}, [][]byte{{1}}, io.ErrUnexpectedEOF},
+		{[]byte{1, END, 2, ESC, 3, END}, [][]byte{{1}}, ErrInvalidEscape},
+		{[]byte{1, END, 1, 2, 3, 4, 5, END, 6, END}, [][]byte{{1}}, ErrPacketTooLarge},
+	} {
+		s := NewScanner(iotest.OneByteReader(bytes.NewReader(d.data)), WithStrictDecoding(true))
+		s.MaxTokenSize(4)
+		n := 0
+		for s.Scan() {
+			if n >= len(d.expected) {
+				t.Error(strconv.Itoa(i), "Unexpected packet", s.Bytes())
+			} else if !eqBytes(s.Bytes(), d.expected[n]) {
+				t.Error(strconv.Itoa(i), "Expected data", d.expected[n], "but got", s.Bytes())
+			}
+			n++
+		}
+		if n != len(d.expected) {
+			t.Error(strconv.Itoa(i), "Expected", len(d.expected), "packets but got", n)
+		}
+		if !errors.Is(s.Err(), d.err) {
+			t.Error(strconv.Itoa(i), "Expected error", d.err, "but got", s.Err())
+		}
+		if s.Scan() || s.Bytes() != nil {
+			t.Error(strconv.Itoa(i), "Expected scan to stay stopped")
+		}
+	}
+}
+
+func TestScannerDefaultMax(t *testing.T) {
+	data := append(make([]byte, bufio.MaxScanTokenSize+1), END)
+	s := NewScanner(bytes.NewReader(data))
+	if s.Scan() || s.Err() != ErrPacketTooLarge {
+		t.Error("Expected error", ErrPacketTooLarge, "but got", s.Err())
+	}
+	s = NewScanner(bytes.NewReader(data), WithMaxPacketSize(len(data)))
+	if !s.Scan() || len(s.Bytes()) != len(data)-1 {
+		t.Error("Expected", len(data)-1, "bytes but got", len(s.Bytes()), s.Err())
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected MaxTokenSize after Scan to panic")
+		}
+	}()
+	s.MaxTokenSize(10)
+}
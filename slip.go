@@ -1,32 +1,200 @@
 package slip
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"encoding"
+	"errors"
+	"fmt"
 	"io"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
+const defaultBufSize = 4096
+
 type Reader struct {
-	mu sync.Mutex
-	r  io.Reader
+	stats readerStats // first for 64-bit alignment of the atomic counters
+	codec
+
+	// MaxPacketSize limits the size of a decoded packet. Packets that
+	// grow beyond it are dropped with ErrPacketTooLarge.
+	// Zero means unlimited. It must be set before the first read.
+	MaxPacketSize int
+
+	mu       sync.Mutex
+	r        io.Reader
+	buf      []byte
+	rd, wr   int     // buf read and write positions
+	err      error   // error returned by the last fill, reported once buf is drained
+	skip     bool    // drop bytes up to the next END before reading a packet
+	skipInit bool    // set skip at the start of the stream, see WithSkipToFirstEnd
+	nskip    int     // bytes dropped by skip during the last read
+	strict   bool    // report invalid escape sequences instead of storing them
+	leadReq  bool    // the stream must start with an END, see WithRequireLeadingEnd
+	leadSeen bool    // an END has been read since NewReader or Reset
+	empty    bool    // return empty packets instead of skipping them
+	resync   bool    // drop packets with protocol errors instead of reporting them
+	sep      []byte  // written after every packet by WriteTo
+	reuse    bool    // ReadPacket returns rbuf instead of a new slice
+	rbuf     []byte  // packet buffer kept across reads, see keepPacket
+	rcap     int     // initial capacity of rbuf, zero for none
+	tbuf     []byte  // scratch packet of ReadPacketToBuffer
+	fbuf     []byte  // frame of NextFrame, kept at the largest size seen
+	capture  bool    // collect the raw bytes read in raw, see ReadPacketRaw and WithWireChecksum
+	nofill   bool    // readByte does not read from r, see ReadAvailable
+	ftail    int     // bytes of the flag sequence read so far, see WithFlagSequence
+	raw      []byte  // raw bytes of the current packet
+	ratio    float64 // maximum share of escaped bytes, zero for no limit
+	nesc     int     // escaped bytes in the current packet
+	reads    int     // empty reads in a row before errZeroRead
+
+	hook   func(frame []byte, err error) // called for every frame read
+	onIdle func()                        // called for every empty frame
+	logger Logger                        // see WithErrorLogger
+
+	warnSize int            // packets larger than this are reported to onLarge
+	onLarge  func(size int) // see WithLargeFrameWarning
+
+	validator func([]byte) error // checks every frame, see WithFrameValidator
+
+	byteTimeout  time.Duration // see WithInterByteTimeout, zero for none
+	byteDeadline time.Time     // inter-byte deadline set by the last fill
+	timeout      time.Time     // deadline of ReadPacketTimeout, zero for none
+	rdeadline    int64         // of SetReadDeadline in unix nanoseconds, atomic
+	timed        bool          // record doneAt, see ReadPacketTimed
+	doneAt       time.Time     // when the last packet was completed
+
+	window time.Duration // see WithCoalesceWindow, zero for none
+	cbuf   []byte        // frame read by coalesce
+	cerr   error         // protocol error of a frame read by coalesce
+	cwait  bool          // coalesce is waiting, its timeouts are not reported
+
+	readLimit int64 // bytes to read from r in total, zero for no limit
+	nread     int64 // bytes read from r since NewReader or Reset
+
+	tap      io.Writer         // receives every packet read, see WithTap
+	tapw     *Writer           // encodes for tap, created on first use
+	tapError func(error) error // policy for errors of tap
+
+	partial    []byte // unfinished packet kept for the next read
+	escPending bool   // partial ends with an ESC
+	complete   bool   // partial is a whole packet decoded by Peek
+	redeliver  bool   // the complete packet was returned before, see unread
+	peek       int    // stop decoding after this many bytes, zero for no limit
+
+	closing int32 // set atomically by the first call to Close
+	closed  bool
+	nolock  bool // mu is not used, see WithoutLocking
+
+	pmu     sync.Mutex
+	pending chan readResult // read abandoned by ReadPacketContext
+
+	stopPackets context.CancelFunc // stops the goroutine of Packets
+	packetsDone chan struct{}      // closed when that goroutine returned
 }
 
-func NewReader(reader io.Reader) *Reader {
-	return &Reader{
-		mu: sync.Mutex{},
-		r:  reader,
+// NewReader returns a new Reader reading from reader. Without options
+// it decodes standard SLIP with a read buffer of 4096 bytes and no
+// limit on the packet size.
+func NewReader(reader io.Reader, opts ...ReaderOption) *Reader {
+	s := &Reader{
+		codec: defaultCodec,
+		mu:    sync.Mutex{},
+		r:     reader,
+		reads: maxConsecutiveEmptyReads,
+	}
+	for _, opt := range opts {
+		opt.applyReader(s)
+	}
+	s.validate()
+	if s.buf == nil {
+		s.buf = make([]byte, defaultBufSize)
 	}
+	s.capture = s.wire != nil
+	s.skip = s.skipInit && !s.noFrame
+	return s
+}
+
+// NewReaderSize returns a new Reader whose internal read buffer has
+// the given size. The buffer is refilled with a single Read on the
+// underlying reader whenever it runs empty, so bytes following an END
+// are kept for the next call to ReadPacket.
+// A size <= 0 selects the default size of 4096 bytes.
+func NewReaderSize(reader io.Reader, size int) *Reader {
+	return NewReader(reader, WithReadBufferSize(size))
 }
 
 type Writer struct {
-	mu sync.Mutex
-	w  io.Writer
+	stats writerStats // first for 64-bit alignment of the atomic counters
+	codec
+
+	mu         sync.Mutex
+	w          io.Writer
+	streamSize int           // size of bw, zero for one Write per packet
+	bw         *bufio.Writer // streams stuffed bytes to w
+	split      bool          // ReadFrom splits at delim instead of per Read
+	delim      byte
+	wsize      int    // size of wbuf, zero for one Write per packet
+	wbuf       []byte // encoded packets waiting for Flush
+	wcount     int    // number of packets in wbuf
+
+	batch   []byte   // frames encoded since BeginBatch
+	bends   []int    // end of every frame in batch
+	bframes [][]byte // frames of the batch, kept for hook
+	inBatch int32    // set atomically from BeginBatch to EndBatch
+
+	hook     func(frame []byte, err error) // called for every packet written
+	leadOnce bool                          // only the first packet has a leading END
+
+	closing int32 // set atomically by the first call to Close
+	closed  bool
+	nolock  bool // mu is not used, see WithoutLocking
+}
+
+// NewWriter returns a new Writer writing to writer.
+func NewWriter(writer io.Writer, opts ...WriterOption) *Writer {
+	s := &Writer{
+		codec: defaultCodec,
+		mu:    sync.Mutex{},
+		w:     writer,
+	}
+	for _, opt := range opts {
+		opt.applyWriter(s)
+	}
+	s.validate()
+	if s.streamSize > 0 {
+		s.bw = bufio.NewWriterSize(countWriter{s}, s.streamSize)
+	}
+	if s.wsize > 0 {
+		s.wbuf = make([]byte, 0, s.wsize)
+	}
+	return s
 }
 
-func NewWriter(writer io.Writer) *Writer {
-	return &Writer{
-		mu: sync.Mutex{},
-		w:  writer,
+func (s *Reader) lock() {
+	if !s.nolock {
+		s.mu.Lock()
+	}
+}
+
+func (s *Reader) unlock() {
+	if !s.nolock {
+		s.mu.Unlock()
+	}
+}
+
+func (s *Writer) lock() {
+	if !s.nolock {
+		s.mu.Lock()
+	}
+}
+
+func (s *Writer) unlock() {
+	if !s.nolock {
+		s.mu.Unlock()
 	}
 }
 
@@ -37,77 +205,1275 @@ const (
 	ESC_ESC = 221 /* 0xDD, ESC ESC_ESC means ESC data byte */
 )
 
+// WritePacket writes p as one SLIP packet.
+// By default the packet is stuffed into a temporary buffer and passed
+// to the underlying writer with a single Write, so packets are never
+// interleaved on packet sockets. See WithStreamingWrites for writing
+// large packets without the temporary buffer.
 func (s *Writer) WritePacket(p []byte) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	buf := &bytes.Buffer{}
+	_, err := s.WritePacketN(p)
+	return err
+}
 
-	/* send an initial END character to flush out any data that may
-	* have accumulated in the receiver due to line noise
-	 */
-	if err := buf.WriteByte(END); err != nil {
+// WritePacketN writes p as one SLIP packet like WritePacket and returns
+// the number of bytes passed to the underlying writer, i.e. the stuffed
+// and framed length of p. On an error n is the number of bytes written
+// before it. With WithWriteBuffering n counts the bytes actually
+// written by this call: zero if the packet was only buffered, and it
+// includes earlier packets when the buffer was written.
+func (s *Writer) WritePacketN(p []byte) (n int, err error) {
+	s.lock()
+	defer s.unlock()
+	start := atomic.LoadUint64(&s.stats.bytes)
+	err = s.writePacket(p)
+	if s.hook != nil {
+		s.hook(p, err)
+	}
+	return int(atomic.LoadUint64(&s.stats.bytes) - start), err
+}
+
+func (s *Writer) writePacket(p []byte) error {
+	if s.closed {
+		return ErrClosed
+	}
+	if s.wsize > 0 {
+		n := len(s.wbuf)
+		s.wbuf = s.appendPacket(s.wbuf, p)
+		return s.buffered(n, 1)
+	}
+	if s.bw != nil {
+		s.encodePacket(s.bw, p)
+		return s.packetsWritten(1, s.flushStream())
+	}
+
+	buf := getBuffer()
+	defer putBuffer(buf)
+	s.encodePacket(buf, p)
+
+	_, err := countWriter{s}.Write(buf.Bytes())
+	return s.packetsWritten(1, err)
+}
+
+// flushStream writes the rest of a packet encoded into s.bw. A
+// bufio.Writer keeps its first error, so after a failure s.bw is
+// replaced and the rest of the packet is dropped; the next packet
+// starts with an END again for the peer to drop the partial frame.
+func (s *Writer) flushStream() error {
+	err := s.bw.Flush()
+	if err != nil {
+		s.bw = bufio.NewWriterSize(countWriter{s}, s.streamSize)
+	}
+	return err
+}
+
+// packetsWritten counts n packets as written unless err is set.
+func (s *Writer) packetsWritten(n int, err error) error {
+	s.nextLead(err)
+	if err == nil {
+		atomic.AddUint64(&s.stats.packets, uint64(n))
+	}
+	return err
+}
+
+// nextLead drops the leading END of the following packets after a
+// packet has been encoded with WithLeadingEndOnce. After a failed write
+// the peer may hold part of a packet, so the END is written again.
+func (s *Writer) nextLead(err error) {
+	if s.leadOnce {
+		s.noLead = err == nil
+	}
+}
+
+// WriteString writes str as one SLIP packet like WritePacket, without
+// converting it to a byte slice first.
+func (s *Writer) WriteString(str string) error {
+	s.lock()
+	defer s.unlock()
+	err := s.writeString(str)
+	if s.hook != nil {
+		s.hook([]byte(str), err)
+	}
+	return err
+}
+
+func (s *Writer) writeString(str string) error {
+	if s.closed {
+		return ErrClosed
+	}
+	if s.wsize > 0 {
+		n := len(s.wbuf)
+		s.wbuf = s.appendString(s.wbuf, str)
+		return s.buffered(n, 1)
+	}
+	if s.bw != nil {
+		s.encodeString(s.bw, str)
+		return s.packetsWritten(1, s.flushStream())
+	}
+
+	buf := getBuffer()
+	defer putBuffer(buf)
+	s.encodeString(buf, str)
+
+	_, err := countWriter{s}.Write(buf.Bytes())
+	return s.packetsWritten(1, err)
+}
+
+// WriteMarshaler writes the result of m.MarshalBinary as one SLIP
+// packet like WritePacket. An error of MarshalBinary is returned as is
+// and nothing is written.
+func (s *Writer) WriteMarshaler(m encoding.BinaryMarshaler) error {
+	p, err := m.MarshalBinary()
+	if err != nil {
 		return err
 	}
+	return s.WritePacket(p)
+}
 
-	/* for each byte in the packet, send the appropriate character
-	 * sequence
-	 */
-	for _, b := range p {
-		switch b {
-		/* if it's the same code as an END character, we send a
-		 * special two character code so as not to make the
-		 * receiver think we sent an END
-		 */
-		case END:
-			if err := buf.WriteByte(ESC); err != nil {
-				return err
-			}
-			if err := buf.WriteByte(ESC_END); err != nil {
-				return err
-			}
+// WritePacketv writes the concatenation of ps as one SLIP packet like
+// WritePacket, without copying the slices into one first, e.g. to send
+// a header and a body. The packet has a single leading and trailing END;
+// a byte that needs escaping is escaped wherever it sits.
+func (s *Writer) WritePacketv(ps ...[]byte) error {
+	s.lock()
+	defer s.unlock()
+	err := s.writePacketv(ps)
+	if s.hook != nil {
+		s.hook(bytes.Join(ps, nil), err)
+	}
+	return err
+}
 
-		/* if it's the same code as an ESC character,
-		 * we send a special two character code so as not
-		 * to make the receiver think we sent an ESC
-		 */
-		case ESC:
-			if err := buf.WriteByte(ESC); err != nil {
-				return err
-			}
-			if err := buf.WriteByte(ESC_ESC); err != nil {
-				return err
-			}
+func (s *Writer) writePacketv(ps [][]byte) error {
+	if s.closed {
+		return ErrClosed
+	}
+	if s.wsize > 0 {
+		n := len(s.wbuf)
+		s.wbuf = s.appendPacketv(s.wbuf, ps)
+		return s.buffered(n, 1)
+	}
+	if s.bw != nil {
+		s.encodePacketv(s.bw, ps)
+		return s.packetsWritten(1, s.flushStream())
+	}
+
+	buf := getBuffer()
+	defer putBuffer(buf)
+	s.encodePacketv(buf, ps)
 
-		/* otherwise, we just send the character
+	_, err := countWriter{s}.Write(buf.Bytes())
+	return s.packetsWritten(1, err)
+}
+
+// WriteEnd writes a single END byte, an empty frame, e.g. as a
+// keepalive or to make the peer drop line noise. Readers skip it, see
+// WithEmptyFrameCallback. With WithWriteBuffering the buffered packets
+// are written together with the END, so it goes out right away.
+func (s *Writer) WriteEnd() error {
+	s.lock()
+	defer s.unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	if s.wsize > 0 {
+		s.wbuf = s.appendEnd(s.wbuf)
+		return s.flushBuffer(len(s.wbuf), s.wcount)
+	}
+	end := s.appendEnd(nil)
+	n, err := countWriter{s}.Write(end)
+	if err == nil && n < len(end) {
+		err = io.ErrShortWrite
+	}
+	return err
+}
+
+// BatchWriteError is returned by WritePackets when the underlying
+// writer failed. The first Packets packets were written completely.
+type BatchWriteError struct {
+	Packets int
+	Err     error
+}
+
+func (e *BatchWriteError) Error() string {
+	return fmt.Sprintf("slip: wrote %d packets of batch: %v", e.Packets, e.Err)
+}
+
+func (e *BatchWriteError) Unwrap() error {
+	return e.Err
+}
+
+// WritePackets writes all packets of ps with a single Write on the
+// underlying writer, so they are contiguous on the wire even with
+// concurrent writers. This applies to streaming writers too, buffered
+// writers add the batch to the buffer as a whole.
+// If the Write fails, a *BatchWriteError reports how many packets were
+// written completely, so the caller can retry the rest.
+func (s *Writer) WritePackets(ps [][]byte) error {
+	s.lock()
+	defer s.unlock()
+	err := s.writePackets(ps)
+	if s.hook != nil {
+		s.batchHook(ps, err)
+	}
+	return err
+}
+
+// batchHook calls the hook for the packets ps written together with
+// the error err of writePackets or writeBatch.
+func (s *Writer) batchHook(ps [][]byte, err error) {
+	written := 0
+	if berr, ok := err.(*BatchWriteError); ok {
+		written = berr.Packets
+	} else if err == nil {
+		written = len(ps)
+	}
+	for i, p := range ps {
+		if i < written {
+			s.hook(p, nil)
+		} else {
+			s.hook(p, err)
+		}
+	}
+}
+
+func (s *Writer) writePackets(ps [][]byte) error {
+	if s.closed {
+		return ErrClosed
+	}
+	if s.wsize > 0 {
+		n := len(s.wbuf)
+		for _, p := range ps {
+			s.wbuf = s.appendPacket(s.wbuf, p)
+			s.nextLead(nil)
+		}
+		return s.buffered(n, len(ps))
+	}
+
+	buf := getBuffer()
+	defer putBuffer(buf)
+	ends := make([]int, len(ps))
+	for i, p := range ps {
+		s.encodePacket(buf, p)
+		s.nextLead(nil)
+		ends[i] = buf.Len()
+	}
+	return s.writeBatch(buf.Bytes(), ends)
+}
+
+// writeBatch writes the encoded packets b ending at ends with a single
+// Write.
+func (s *Writer) writeBatch(b []byte, ends []int) error {
+	n, err := countWriter{s}.Write(b)
+	if err != nil {
+		written := 0
+		for written < len(ends) && ends[written] <= n {
+			written++
+		}
+		s.packetsWritten(written, nil)
+		s.nextLead(err)
+		return &BatchWriteError{Packets: written, Err: err}
+	}
+	return s.packetsWritten(len(ends), nil)
+}
+
+// buffered accounts for k packets appended to wbuf after its first n
+// bytes and writes the buffer once it is full. Packets that no longer
+// fit are written on their own, so a packet is never split across
+// writes.
+func (s *Writer) buffered(n, k int) error {
+	s.nextLead(nil)
+	s.wcount += k
+	if len(s.wbuf) > s.wsize && n > 0 {
+		if err := s.flushBuffer(n, s.wcount-k); err != nil {
+			return err
+		}
+	}
+	if len(s.wbuf) >= s.wsize {
+		return s.flushBuffer(len(s.wbuf), s.wcount)
+	}
+	return nil
+}
+
+// flushBuffer writes the first n bytes of wbuf, which hold k packets.
+// Bytes that were not written stay in the buffer.
+func (s *Writer) flushBuffer(n, k int) error {
+	written, err := countWriter{s}.Write(s.wbuf[:n])
+	s.wbuf = s.wbuf[:copy(s.wbuf, s.wbuf[written:])]
+	s.wcount -= k
+	if err == nil && written < n {
+		err = io.ErrShortWrite
+	}
+	return s.packetsWritten(k, err)
+}
+
+// Flush writes the packets buffered with WithWriteBuffering to the
+// underlying writer with a single Write and returns the number of bytes
+// written, so shutdown code can tell whether anything was pending. Bytes
+// a failed Write left behind stay buffered. It does nothing for an
+// unbuffered Writer.
+func (s *Writer) Flush() (n int, err error) {
+	s.lock()
+	defer s.unlock()
+	if s.closed {
+		return 0, ErrClosed
+	}
+	return s.flush()
+}
+
+func (s *Writer) flush() (int, error) {
+	n := len(s.wbuf)
+	if n == 0 {
+		return 0, nil
+	}
+	err := s.flushBuffer(n, s.wcount)
+	return n - len(s.wbuf), err
+}
+
+// Available returns the number of bytes left in the buffer of
+// WithWriteBuffering, like bufio.Writer.Available: a packet whose
+// encoding is shorter is buffered without a Write on the underlying
+// writer. It is zero for a Writer without buffering.
+func (s *Writer) Available() int {
+	s.lock()
+	defer s.unlock()
+	if n := s.wsize - len(s.wbuf); n > 0 {
+		return n
+	}
+	return 0
+}
+
+// Buffered returns the number of encoded bytes waiting in the buffer of
+// WithWriteBuffering for Flush, including those a failed Write left
+// behind. It is zero for a Writer without buffering.
+func (s *Writer) Buffered() int {
+	s.lock()
+	defer s.unlock()
+	return len(s.wbuf)
+}
+
+// Pending returns the number of bytes the next Flush writes, the same
+// as Buffered. It is zero if no Flush is needed, e.g. before Close.
+func (s *Writer) Pending() int {
+	return s.Buffered()
+}
+
+// maxPooledBufferSize is the capacity above which encode buffers are
+// dropped instead of returned to the pool, so a single huge packet does
+// not pin its memory forever.
+const maxPooledBufferSize = 64 << 10
+
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		return &bytes.Buffer{}
+	},
+}
+
+func getBuffer() *bytes.Buffer {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBufferSize {
+		return
+	}
+	bufferPool.Put(buf)
+}
+
+// ErrClosed is returned by reads and writes after Close.
+var ErrClosed = errors.New("slip: closed")
+
+// Close flushes buffered packets and closes the underlying writer if
+// it implements io.Closer.
+// Later calls to WritePacket return ErrClosed, as does a second Close.
+func (s *Writer) Close() error {
+	return s.close(true)
+}
+
+// close marks the writer closed and closes the underlying writer if
+// underlying is set.
+func (s *Writer) close(underlying bool) error {
+	if !atomic.CompareAndSwapInt32(&s.closing, 0, 1) {
+		return ErrClosed
+	}
+	s.lock()
+	defer s.unlock()
+	s.closed = true
+	_, err := s.flush()
+	if c, ok := s.w.(io.Closer); ok && underlying {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// Close closes the underlying reader if it implements io.Closer.
+// Later calls to ReadPacket return ErrClosed, as does a second Close.
+// The underlying reader is closed before the mutex is taken, so a
+// ReadPacket blocked on a net.Conn or os.File returns with its error
+// instead of blocking Close.
+func (s *Reader) Close() error {
+	if !atomic.CompareAndSwapInt32(&s.closing, 0, 1) {
+		return ErrClosed
+	}
+	var err error
+	if c, ok := s.r.(io.Closer); ok {
+		err = c.Close()
+	}
+	s.lock()
+	s.closed = true
+	s.unlock()
+	return err
+}
+
+// Reset discards all buffered data and any unfinished packet and
+// makes s read from r, like bufio.Reader.Reset. A closed Reader is
+// usable again after Reset.
+func (s *Reader) Reset(r io.Reader) {
+	s.lock()
+	defer s.unlock()
+	s.r = r
+	s.rd, s.wr = 0, 0
+	s.err = nil
+	s.skip = s.skipInit && !s.noFrame
+	s.leadSeen = false
+	s.nread = 0
+	s.ftail = 0
+	s.cerr = nil
+	s.partial = s.partial[:0]
+	s.escPending = false
+	s.complete = false
+	s.redeliver = false
+	s.closed = false
+	atomic.StoreInt32(&s.closing, 0)
+
+	s.pmu.Lock()
+	s.pending = nil
+	s.pmu.Unlock()
+}
+
+// Reset discards buffered packets and makes s write to w.
+// A closed Writer is usable again after Reset.
+func (s *Writer) Reset(w io.Writer) {
+	s.lock()
+	defer s.unlock()
+	s.w = w
+	s.wbuf = s.wbuf[:0]
+	s.wcount = 0
+	if s.leadOnce {
+		s.noLead = false
+	}
+	if s.bw != nil {
+		s.bw.Reset(countWriter{s})
+	}
+	s.closed = false
+	atomic.StoreInt32(&s.closing, 0)
+}
+
+// errZeroRead is returned by readByte when the underlying reader
+// returned no data and no error s.reads times in a row.
+var errZeroRead = errors.New("slip: zero length read")
+
+// maxConsecutiveEmptyReads is the default number of reads without data
+// and error that are retried, like in bufio.
+const maxConsecutiveEmptyReads = 100
+
+// fill reads a new chunk from the underlying reader into the empty buffer.
+// Bytes returned together with an error are kept and consumed first, the
+// error is only reported by readByte once they are drained.
+// ErrReadLimitExceeded is returned by a Reader once it read the number
+// of bytes set with WithReadLimit from the underlying reader.
+var ErrReadLimitExceeded = errors.New("slip: read limit exceeded")
+
+func (s *Reader) fill() {
+	buf := s.buf
+	if s.readLimit > 0 {
+		left := s.readLimit - s.nread
+		if left <= 0 {
+			s.rd, s.wr = 0, 0
+			s.err = ErrReadLimitExceeded
+			return
+		}
+		if left < int64(len(buf)) {
+			buf = buf[:left]
+		}
+	}
+	s.extendDeadline()
+	n, err := s.r.Read(buf)
+	s.nread += int64(n)
+	s.rd, s.wr = 0, n
+	s.err = err
+}
+
+func (s *Reader) readErr() error {
+	err := s.err
+	s.err = nil
+	return err
+}
+
+// readByte returns the next raw byte from the internal buffer and
+// refills the buffer from the underlying reader when it runs empty.
+func (s *Reader) readByte() (byte, error) {
+	for i := 0; s.rd == s.wr; i++ {
+		if s.nofill {
+			return 0, errWouldBlock
+		}
+		if s.err != nil {
+			return 0, s.readErr()
+		}
+		if i == s.reads {
+			return 0, errZeroRead
+		}
+		s.fill()
+	}
+	c := s.buf[s.rd]
+	s.rd++
+	if s.ftail > 0 {
+		/* drop the rest of a flag sequence, a byte that
+		 * does not match starts the next frame
 		 */
-		default:
-			if err := buf.WriteByte(b); err != nil {
-				return err
+		if c == s.flag[s.ftail] {
+			if s.ftail++; s.ftail == len(s.flag) {
+				s.ftail = 0
 			}
+			return s.readByte()
 		}
+		s.ftail = 0
 	}
+	if c == s.end && len(s.flag) > 1 {
+		s.ftail = 1
+	}
+	if s.capture {
+		s.raw = append(s.raw, c)
+	}
+	return c, nil
+}
 
-	/* tell the receiver that we're done sending the packet
-	 */
-	if err := buf.WriteByte(END); err != nil {
+// plainRun returns the number of buffered bytes before the next END or
+// ESC that fit into a packet of n bytes under limit and the peek size.
+func (s *Reader) plainRun(n, limit int) int {
+	if s.ftail > 0 {
+		return 0
+	}
+	run := s.buf[s.rd:s.wr]
+	if limit >= 0 && limit-n < len(run) {
+		run = run[:limit-n]
+	}
+	if s.peek > 0 && s.peek-n < len(run) {
+		run = run[:s.peek-n]
+	}
+	if i := s.indexEndEsc(run); i >= 0 {
+		return i
+	}
+	return len(run)
+}
+
+// ErrBufferTooSmall is returned by ReadPacketInto when the decoded
+// frame does not fit into the destination slice.
+var ErrBufferTooSmall = errors.New("slip: buffer too small for packet")
+
+// ErrPacketTooLarge is returned when a packet exceeds MaxPacketSize.
+var ErrPacketTooLarge = errors.New("slip: packet exceeds maximum size")
+
+// ErrMissingLeadingEnd is returned by a Reader with
+// WithRequireLeadingEnd when the stream does not start with an END.
+var ErrMissingLeadingEnd = errors.New("slip: data before the first END")
+
+// ErrSuspiciousFrame is returned when the share of escaped bytes in a
+// packet exceeds the ratio set with WithMaxEscapeRatio.
+var ErrSuspiciousFrame = errors.New("slip: too many escaped bytes in packet")
+
+// ReadPacket reads the next packet from the stream.
+// The returned slice is newly allocated on every call, so it may be
+// kept across calls. With WithCopyOnRead(false) it aliases an internal
+// buffer instead and is only valid until the next read.
+//
+// At the end of the stream ReadPacket returns nil, false, io.EOF, so
+// a read loop can stop on io.EOF. If the underlying reader fails before
+// the terminating END, the bytes received so far are returned with
+// isPrefix set to true together with the error, where an io.EOF in the
+// middle of a packet is reported as io.ErrUnexpectedEOF, or as
+// ErrIncompleteEscape if the stream ended right after an ESC, in strict
+// and lenient mode alike. The reader keeps these bytes, so once more
+// data is available the next call resumes the packet and returns it as
+// a whole. A Read on the
+// underlying reader that returns neither data nor an error is retried,
+// also in the middle of an escape sequence; only after 100 such reads
+// in a row io.ErrNoProgress is returned, see WithZeroReadRetry.
+func (s *Reader) ReadPacket() (p []byte, isPrefix bool, err error) {
+	s.lock()
+	defer s.unlock()
+	if s.closed {
+		return nil, false, ErrClosed
+	}
+	p, isPrefix, err = s.read(s.packetBuffer(), s.maxPacketSize())
+	return s.keepPacket(p, err), isPrefix, err
+}
+
+// ReadPacketLimited reads the next packet like ReadPacket, but drops it
+// with ErrPacketTooLarge if it is longer than max bytes, e.g. when the
+// allowed size depends on the message expected next. The rest of the
+// packet is dropped up to its END by the next read. A max <= 0 sets no
+// limit for this call; MaxPacketSize applies in either case. Check the
+// length of the returned bytes for an unfinished packet.
+func (s *Reader) ReadPacketLimited(max int) ([]byte, error) {
+	s.lock()
+	defer s.unlock()
+	if s.closed {
+		return nil, ErrClosed
+	}
+	limit := s.maxPacketSize()
+	if max > 0 && (limit < 0 || max < limit) {
+		limit = max
+	}
+	p, _, err := s.read(s.packetBuffer(), limit)
+	return s.keepPacket(p, err), err
+}
+
+// ReadPacketRaw reads the next packet like ReadPacket and also returns
+// the raw bytes it was decoded from, e.g. to show the wire form in a
+// protocol analyzer, as re-encoding the packet does not always give the
+// same bytes. raw runs from the END before the packet, if the packet
+// has its own, through the terminating END; with WithAutoResync it
+// starts after the last dropped packet. The bytes of a packet resumed
+// after an error are included if the reads before were ReadPacketRaw
+// calls too. raw is newly allocated on every call.
+func (s *Reader) ReadPacketRaw() (decoded, raw []byte, err error) {
+	s.lock()
+	defer s.unlock()
+	if s.closed {
+		return nil, nil, ErrClosed
+	}
+	s.capture = true
+	p, _, err := s.read(s.packetBuffer(), s.maxPacketSize())
+	s.capture = s.wire != nil
+	if len(s.raw) > 0 {
+		raw = append([]byte(nil), s.raw...)
+	}
+	return s.keepPacket(p, err), raw, err
+}
+
+// ReadPacketTimed reads the next packet like ReadPacket and returns
+// the time its terminating END was consumed, before any processing by
+// the caller, e.g. for latency analysis together with Stats. The time
+// is only taken by this method, ReadPacket does not read the clock. A
+// packet completed by Peek has the time of that call. On an error t is
+// the zero time and p holds the bytes of an unfinished packet, as with
+// ReadPacket.
+func (s *Reader) ReadPacketTimed() (p []byte, t time.Time, err error) {
+	s.lock()
+	defer s.unlock()
+	if s.closed {
+		return nil, t, ErrClosed
+	}
+	s.timed = true
+	p, _, err = s.read(s.packetBuffer(), s.maxPacketSize())
+	s.timed = false
+	if err == nil {
+		t = s.doneAt
+	}
+	return s.keepPacket(p, err), t, err
+}
+
+// NextFrame reads the next complete packet into a buffer owned by s
+// and returns a view of it, so a read loop runs without allocating or
+// copying once the buffer has grown to the largest packet seen. The
+// returned slice is only valid until the next call to NextFrame or any
+// other read on s; it must be copied to be kept. On an error it is nil:
+// an unfinished packet is kept for the next call as for ReadPacket,
+// and an oversized packet is dropped with ErrPacketTooLarge.
+//
+// The buffer is linear, not a ring: a frame is returned as one
+// contiguous slice, which a ring that has wrapped around can only give
+// by copying the frame. As only one frame is valid at a time, the
+// buffer is reused from its start for every call instead.
+func (s *Reader) NextFrame() ([]byte, error) {
+	s.lock()
+	defer s.unlock()
+	if s.closed {
+		return nil, ErrClosed
+	}
+	p, _, err := s.read(s.fbuf[:0], s.maxPacketSize())
+	s.fbuf = p[:0]
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// packetBuffer returns the buffer ReadPacket decodes into.
+func (s *Reader) packetBuffer() []byte {
+	if s.rbuf == nil && s.rcap > 0 {
+		s.rbuf = make([]byte, 0, s.rcap)
+	}
+	return s.rbuf[:0]
+}
+
+// keepPacket keeps the buffer of the packet p decoded by ReadPacket for
+// the next read if it is reused, and returns the packet for the caller.
+// Without WithCopyOnRead(false) but with WithInitialReadCapacity the
+// caller gets a copy of the exact size. A buffer that grew beyond the
+// initial capacity is released. Like for a new slice, an empty p is
+// returned as nil together with an error.
+func (s *Reader) keepPacket(p []byte, err error) []byte {
+	if !s.reuse && s.rcap == 0 {
+		return p
+	}
+	if s.rcap > 0 && cap(p) > s.rcap {
+		s.rbuf = nil
+	} else {
+		s.rbuf = p[:0]
+	}
+	switch {
+	case len(p) == 0 && err != nil:
+		return nil
+	case s.reuse || len(p) == 0:
+		return p
+	}
+	q := make([]byte, len(p))
+	copy(q, p)
+	return q
+}
+
+// ReadPacketToBuffer reads the next packet like ReadPacket and appends
+// it to dst, returning the number of bytes appended, so a caller can
+// collect packets in a buffer it owns. Only complete packets are
+// appended: on an error nothing is written to dst and n is zero, while
+// an unfinished packet is kept by s as for ReadPacket.
+func (s *Reader) ReadPacketToBuffer(dst *bytes.Buffer) (n int, err error) {
+	s.lock()
+	defer s.unlock()
+	if s.closed {
+		return 0, ErrClosed
+	}
+	p, _, err := s.read(s.tbuf[:0], s.maxPacketSize())
+	s.tbuf = p[:0]
+	if err != nil {
+		return 0, err
+	}
+	return dst.Write(p)
+}
+
+// UnmarshalError is returned by ReadInto when UnmarshalBinary failed
+// on a packet that was read successfully.
+type UnmarshalError struct {
+	Packet []byte // the packet passed to UnmarshalBinary
+	Err    error
+}
+
+func (e *UnmarshalError) Error() string {
+	return fmt.Sprintf("slip: unmarshal packet of %d bytes: %v", len(e.Packet), e.Err)
+}
+
+func (e *UnmarshalError) Unwrap() error {
+	return e.Err
+}
+
+// ReadInto reads the next packet like ReadPacket and passes it to
+// u.UnmarshalBinary. Errors of reading the packet are returned as is,
+// u is not called then; an error of UnmarshalBinary is returned as an
+// *UnmarshalError. At the end of the stream ReadInto returns io.EOF.
+func (s *Reader) ReadInto(u encoding.BinaryUnmarshaler) error {
+	p, _, err := s.ReadPacket()
+	if err != nil {
 		return err
 	}
+	if err = u.UnmarshalBinary(p); err != nil {
+		return &UnmarshalError{Packet: p, Err: err}
+	}
+	return nil
+}
+
+// ReadAll reads packets until the end of the stream and returns them,
+// like io.ReadAll. Every packet is a slice of its own, also with
+// WithCopyOnRead(false). At the end of the stream ReadAll returns nil;
+// on any other error it returns the packets read before together with
+// it, e.g. io.ErrUnexpectedEOF if the stream ended inside a packet or
+// ErrPacketTooLarge for a packet larger than MaxPacketSize. The bytes
+// of an unfinished packet are kept for the next read as for ReadPacket.
+func (s *Reader) ReadAll() ([][]byte, error) {
+	s.lock()
+	defer s.unlock()
+	if s.closed {
+		return nil, ErrClosed
+	}
+	var packets [][]byte
+	for {
+		p, _, err := s.read(s.packetBuffer(), s.maxPacketSize())
+		p = s.keepPacket(p, err)
+		if err == io.EOF {
+			return packets, nil
+		}
+		if err != nil {
+			return packets, err
+		}
+		if s.reuse {
+			p = append([]byte(nil), p...)
+		}
+		packets = append(packets, p)
+	}
+}
+
+// errWouldBlock is returned by readByte when the buffer is empty and
+// s.nofill is set.
+var errWouldBlock = errors.New("slip: no buffered data")
+
+// ReadAvailable reads the next packet like ReadPacket, waiting for it
+// if necessary, and then as many of the following packets as can be
+// decoded from the bytes already buffered, up to max packets in total,
+// so a consumer handles a burst of packets in one call. A max <= 0
+// sets no limit. A packet only partly buffered is kept for the next
+// read. Every packet is a slice of its own, also with
+// WithCopyOnRead(false). If reading the first packet fails, the error
+// is returned as by ReadPacket; an error after it is returned together
+// with the packets read before.
+func (s *Reader) ReadAvailable(max int) ([][]byte, error) {
+	s.lock()
+	defer s.unlock()
+	if s.closed {
+		return nil, ErrClosed
+	}
+	var packets [][]byte
+	for max <= 0 || len(packets) < max {
+		p, _, err := s.read(s.packetBuffer(), s.maxPacketSize())
+		p = s.keepPacket(p, err)
+		if err == errWouldBlock {
+			break
+		}
+		if err != nil {
+			s.nofill = false
+			return packets, err
+		}
+		if s.reuse {
+			p = append([]byte(nil), p...)
+		}
+		packets = append(packets, p)
+		s.nofill = true
+	}
+	s.nofill = false
+	return packets, nil
+}
+
+// read is ReadPacket with the given limit, it appends the packet to
+// dst.
+func (s *Reader) read(dst []byte, limit int) (p []byte, isPrefix bool, err error) {
+	s.nskip = 0
+	if s.cerr != nil {
+		err, s.cerr = s.cerr, nil
+		return dst, false, err
+	}
+	again := s.complete && s.redeliver
+	for {
+		p, isPrefix, err = s.readFrame(dst, limit, ErrPacketTooLarge)
+		if !s.dropped(err) {
+			break
+		}
+	}
+	if s.window > 0 && err == nil && len(p) > 0 && !again {
+		p = s.coalesce(p, limit)
+	}
+	if err == errZeroRead {
+		err = io.ErrNoProgress
+	}
+	return
+}
+
+// ReadPacketInto decodes the next packet into dst and returns the
+// number of bytes written. It does not allocate, so a single dst can
+// be reused for every packet.
+// If the packet does not fit into dst, dst holds the first len(dst)
+// bytes, the remainder of the packet is discarded up to its END and
+// ErrBufferTooSmall is returned. If MaxPacketSize is smaller than dst
+// and exceeded, ErrPacketTooLarge is returned instead.
+// If the underlying reader fails before the terminating END, the bytes
+// received so far are stored in dst and returned together with the
+// error. Like ReadPacket the next call resumes the packet and io.EOF is
+// only returned between packets.
+func (s *Reader) ReadPacketInto(dst []byte) (n int, err error) {
+	s.lock()
+	defer s.unlock()
+	if s.closed {
+		return 0, ErrClosed
+	}
+	limit, errOverflow := len(dst), ErrBufferTooSmall
+	if max := s.maxPacketSize(); max >= 0 && max < limit {
+		limit, errOverflow = max, ErrPacketTooLarge
+	}
+	var p []byte
+	s.nskip = 0
+	for {
+		p, _, err = s.readFrame(dst[:0], limit, errOverflow)
+		if !s.dropped(err) {
+			break
+		}
+	}
+	if err == errZeroRead {
+		err = io.ErrNoProgress
+	}
+	return len(p), err
+}
+
+// readFrame is readPacket followed by the read hook. The hook sees
+// every frame, including the ones dropped by WithAutoResync, but not
+// a clean end of the stream.
+func (s *Reader) readFrame(p []byte, limit int, errOverflow error) ([]byte, bool, error) {
+	if s.complete && s.redeliver {
+		// handed back by unread, it has been through the hooks
+		return s.readPacket(p, limit, errOverflow)
+	}
+	p, isPrefix, err := s.readPacket(p, limit, errOverflow)
+	if s.cwait && isTimeout(err) {
+		// the end of the window, not an error of the stream
+		return p, isPrefix, err
+	}
+	if s.onLarge != nil && err == nil && len(p) > s.warnSize {
+		s.onLarge(len(p))
+	}
+	if s.validator != nil && err == nil {
+		if verr := s.validator(p); verr != nil {
+			atomic.AddUint64(&s.stats.errors, 1)
+			p, err = p[:0], &FrameError{Err: verr}
+		}
+	}
+	if s.tap != nil && err == nil && len(p) > 0 {
+		err = s.tapPacket(p)
+	}
+	if s.logger != nil && err != nil && isProtocolError(err) {
+		s.logFrame(p, err)
+	}
+	if s.hook != nil && err != io.EOF && err != errWouldBlock {
+		herr := err
+		if herr == errZeroRead {
+			herr = io.ErrNoProgress
+		}
+		s.hook(p, herr)
+	}
+	return p, isPrefix, err
+}
+
+// ErrInvalidFrame matches every FrameError with errors.Is.
+var ErrInvalidFrame = errors.New("slip: invalid frame")
+
+// FrameError is returned when the validator of WithFrameValidator
+// rejects a frame. The frame is dropped.
+type FrameError struct {
+	Err error // error returned by the validator
+}
 
-	_, err := s.w.Write(buf.Bytes())
+func (e *FrameError) Error() string {
+	return "slip: invalid frame: " + e.Err.Error()
+}
+
+// Is reports whether target is ErrInvalidFrame.
+func (e *FrameError) Is(target error) bool {
+	return target == ErrInvalidFrame
+}
+
+func (e *FrameError) Unwrap() error {
+	return e.Err
+}
+
+// ErrNoFrameLength is returned by a Reader with WithFramingDisabled
+// when the read sets no frame length.
+var ErrNoFrameLength = errors.New("slip: framing disabled and no frame length given")
+
+// TapError is returned by a read when writing the packet to the tap
+// of WithTap failed and the error policy kept the error. The packet was
+// read completely and is returned with it.
+type TapError struct {
+	Err error
+}
+
+func (e *TapError) Error() string {
+	return "slip: write to tap: " + e.Err.Error()
+}
+
+func (e *TapError) Unwrap() error {
+	return e.Err
+}
+
+// tapPacket encodes p to the tap set with WithTap.
+func (s *Reader) tapPacket(p []byte) error {
+	if s.tapw == nil {
+		s.tapw = NewWriter(s.tap, WithoutLocking())
+		s.tapw.codec = s.codec
+	}
+	err := s.tapw.WritePacket(p)
+	if err == nil || s.tapError == nil {
+		return nil
+	}
+	if err = s.tapError(err); err != nil {
+		return &TapError{Err: err}
+	}
+	return nil
+}
+
+// errPeeked is returned by readPacket when it decoded s.peek bytes.
+var errPeeked = errors.New("slip: peeked")
+
+// Peek returns the first n bytes of the next packet without consuming
+// them: the following ReadPacket returns the whole packet. Only as many
+// bytes as needed are read from the underlying reader.
+// If the packet is shorter than n bytes, Peek returns the whole packet
+// with io.EOF. If the stream ends in the middle of the packet, the bytes
+// received so far are returned with io.ErrUnexpectedEOF, and at the end
+// of the stream Peek returns nil, io.EOF.
+// With WithCRC16 the checksum is only verified once the packet is
+// complete, so a packet longer than n bytes is not verified yet.
+func (s *Reader) Peek(n int) ([]byte, error) {
+	s.lock()
+	defer s.unlock()
+	if s.closed {
+		return nil, ErrClosed
+	}
+	if n <= 0 {
+		return nil, nil
+	}
+
+	s.peek = n
+	redeliver := s.complete && s.redeliver
+	p, _, err := s.readPacket(nil, s.maxPacketSize(), ErrPacketTooLarge)
+	s.peek = 0
+	switch err {
+	case errPeeked:
+		return p[:n], nil
+	case nil:
+		s.partial = append(s.partial[:0], p...)
+		s.complete = true
+		s.redeliver = redeliver
+		s.doneAt = time.Now()
+		if len(p) < n {
+			return p, io.EOF
+		}
+		return p[:n], nil
+	case errZeroRead:
+		err = io.ErrNoProgress
+	}
+	return p, err
+}
+
+// Buffered returns the number of raw bytes that have been read from
+// the underlying reader but not yet consumed, like bufio.Reader.Buffered.
+// If it is not zero, the next ReadPacket makes progress without a Read
+// on the underlying reader, though it blocks anyway if the buffered
+// bytes hold no END. Bytes of an unfinished or peeked packet that were
+// already decoded are not included.
+func (s *Reader) Buffered() int {
+	s.lock()
+	defer s.unlock()
+	return s.wr - s.rd
+}
+
+// LastOverflowBytes returns the number of raw bytes of oversized
+// packets the last ReadPacket, ReadPacketLimited or ReadPacketInto
+// dropped, e.g. to log how far a peer exceeded MaxPacketSize. The read
+// that returns ErrPacketTooLarge or ErrBufferTooSmall drops the rest of
+// the packet before it returns, so it reports every byte that did not
+// fit, escape sequences with both bytes; with WithAutoResync it is the
+// read that returned the next packet. If the underlying reader fails
+// before the END, the next read drops and reports the rest. The count
+// includes the terminating END and is reset by every read.
+func (s *Reader) LastOverflowBytes() int {
+	s.lock()
+	defer s.unlock()
+	return s.nskip
+}
+
+func (s *Reader) maxPacketSize() int {
+	if s.MaxPacketSize <= 0 {
+		return -1
+	}
+	return s.MaxPacketSize
+}
+
+// skipPacket drops bytes up to and including the next END and returns
+// their number. Escaped bytes are skipped as a whole so an ESC ESC_END
+// is never mistaken for the boundary. Outside strict mode a raw END
+// after an ESC still ends the skip, as it ends a packet.
+func (s *Reader) skipPacket() (n int, err error) {
+	for {
+		c, err := s.readByte()
+		if err != nil {
+			return n, err
+		}
+		n++
+		if c == s.esc {
+			if c, err = s.readByte(); err != nil {
+				return n, err
+			}
+			n++
+			if c != s.end || s.strict {
+				continue
+			}
+		}
+		if c == s.end {
+			s.skip = false
+			s.leadSeen = true
+			return n, nil
+		}
+	}
+}
+
+// Discard drops the rest of the current frame: it reads and drops
+// bytes up to and including the next END, so the next ReadPacket starts
+// after it. An escaped END is not mistaken for the boundary. Unlike
+// Resync it always consumes an END, also between packets, where it
+// drops the next frame; after a ReadPacket that is only the leading END
+// of the next packet. The unfinished or peeked packet s is in, if any,
+// is dropped with it. ReaderStats.Discarded counts the raw bytes read.
+// Discard returns the error of the underlying reader, with io.EOF if
+// the stream ended before an END.
+func (s *Reader) Discard() error {
+	s.lock()
+	defer s.unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	complete := s.complete
+	s.partial = s.partial[:0]
+	s.escPending = false
+	s.complete = false
+	s.redeliver = false
+	if complete {
+		return nil
+	}
+	n, err := s.skipPacket()
+	atomic.AddUint64(&s.stats.discarded, uint64(n))
+	if err == errZeroRead {
+		err = io.ErrNoProgress
+	}
 	return err
 }
 
-/* RECV_PACKET: receives a packet into the buffer located at "p".
- *      If more than len bytes are received, the packet will
- *      be truncated.
- *      Returns the number of bytes stored in the buffer.
+// Resync drops the unfinished packet s is in the middle of, if any,
+// and the bytes up to and including its END, so the next ReadPacket
+// starts at a packet boundary. Between packets it does nothing.
+// The dropped bytes are not reported. ReadPacket already resyncs after
+// it returned ErrPacketTooLarge or an InvalidEscapeError, Resync is for
+// callers who give up on a packet for other reasons, e.g. a validation
+// error of their own.
+func (s *Reader) Resync() error {
+	s.lock()
+	defer s.unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	if s.complete {
+		s.partial = s.partial[:0]
+		s.complete = false
+		s.redeliver = false
+		return nil
+	}
+	if len(s.partial) > 0 || s.escPending {
+		s.partial = s.partial[:0]
+		s.escPending = false
+		s.skip = true
+	}
+	if s.skip {
+		_, err := s.skipPacket()
+		if err == errZeroRead {
+			err = io.ErrNoProgress
+		}
+		return err
+	}
+	return nil
+}
+
+// dropped reports whether WithAutoResync drops the packet that failed
+// with err, and counts it.
+func (s *Reader) dropped(err error) bool {
+	if !s.resync || !isProtocolError(err) {
+		return false
+	}
+	atomic.AddUint64(&s.stats.dropped, 1)
+	return true
+}
+
+// isProtocolError reports whether err was caused by a malformed packet
+// rather than the underlying reader.
+func isProtocolError(err error) bool {
+	return err == ErrPacketTooLarge || err == ErrSuspiciousFrame ||
+		errors.Is(err, ErrInvalidEscape) || errors.Is(err, ErrChecksumMismatch) ||
+		errors.Is(err, ErrInvalidFrame) || err == ErrFrameTimeout ||
+		err == ErrMissingLeadingEnd
+}
+
+// suspend keeps the unfinished packet p for the next call to
+// readPacket and reports it as a prefix.
+// An io.EOF in the middle of a packet becomes io.ErrUnexpectedEOF,
+// or ErrIncompleteEscape right after an ESC.
+func (s *Reader) suspend(p []byte, esc bool, err error) ([]byte, bool, error) {
+	s.partial = append(s.partial[:0], p...)
+	s.escPending = esc
+	isPrefix := len(p) > 0 || esc
+	if isPrefix && err == io.EOF {
+		err = io.ErrUnexpectedEOF
+		if esc {
+			err = ErrIncompleteEscape
+		}
+	}
+	return p, isPrefix, err
+}
+
+/* RECV_PACKET: receives a packet and appends it to "p".
+ *      If more than limit bytes are received, errOverflow is
+ *      returned right away and the rest of the packet is dropped
+ *      on the next call. A negative limit means no limit.
+ *      If reading fails in the middle of a packet, the packet
+ *      is kept and continued by the next call.
  */
-func (s *Reader) ReadPacket() (p []byte, isPrefix bool, err error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	n := 0
-	buf := &bytes.Buffer{}
-	readBuf := make([]byte, 1)
+func (s *Reader) readPacket(p []byte, limit int, errOverflow error) ([]byte, bool, error) {
+	if s.noFrame && limit < 0 {
+		return p, false, ErrNoFrameLength
+	}
+
+	/* drop what is left of an oversized packet first
+	 */
+	if s.skip {
+		n, err := s.skipPacket()
+		s.nskip += n
+		if err != nil {
+			return p, false, err
+		}
+	}
+	if !s.capture || len(s.partial) == 0 && !s.escPending && !s.complete {
+		s.raw = s.raw[:0]
+	}
+
+	/* pick up an unfinished packet of the last call
+	 */
+	esc := s.escPending
+	if len(s.partial) == 0 && !esc {
+		s.nesc = 0
+	}
+	s.escPending = false
+	complete := s.complete
+	s.complete = false
+	s.redeliver = false
+	if len(s.partial) > 0 || complete {
+		if limit >= 0 && len(s.partial) > limit {
+			p = append(p, s.partial[:limit]...)
+			n := len(s.partial) - limit
+			s.partial = s.partial[:0]
+			if complete {
+				s.nskip += n
+			} else {
+				s.overflow(n)
+			}
+			atomic.AddUint64(&s.stats.errors, 1)
+			return p, false, errOverflow
+		}
+		p = append(p, s.partial...)
+		s.partial = s.partial[:0]
+		if complete {
+			if p == nil {
+				p = []byte{}
+			}
+			return p, false, nil
+		}
+	}
 
 	/* sit in a loop reading bytes until we put together
 	 * a whole packet.
@@ -115,67 +1481,209 @@ func (s *Reader) ReadPacket() (p []byte, isPrefix bool, err error) {
 	 * run out of room.
 	 */
 	for {
-		/* get a character to process
+		/* without framing the packet is done once it holds
+		 * as many bytes as we have been asked for
 		 */
-		n, err = s.r.Read(readBuf)
-		if n == 0 || err != nil {
-			isPrefix = true
-			p = buf.Bytes()
-			return
+		if s.noFrame && len(p) >= limit {
+			var err error
+			if s.wire != nil {
+				if p, err = s.verifyWire(p); err != nil {
+					atomic.AddUint64(&s.stats.errors, 1)
+					return p, false, err
+				}
+			}
+			if s.crc != nil {
+				if p, err = s.crc.verify(p); err != nil {
+					atomic.AddUint64(&s.stats.errors, 1)
+					return p, false, err
+				}
+			}
+			if s.timed {
+				s.doneAt = time.Now()
+			}
+			atomic.AddUint64(&s.stats.packets, 1)
+			atomic.AddUint64(&s.stats.bytes, uint64(len(p)))
+			if p == nil {
+				p = []byte{}
+			}
+			return p, false, nil
 		}
 
-		/* handle bytestuffing if necessary
+		/* stop here if we have been asked to peek at the
+		 * start of the packet only
 		 */
-		switch readBuf[0] {
+		if s.peek > 0 && len(p) >= s.peek {
+			return s.suspend(p, esc, errPeeked)
+		}
 
-		/* if it's an END character then we're done with
-		 * the packet
+		/* copy a run of plain bytes from the buffer in
+		 * one go, the loop below handles the byte after it
 		 */
-		case END:
-			/* a minor optimization: if there is no
-			 * data in the packet, ignore it. This is
-			 * meant to avoid bothering IP with all
-			 * the empty packets generated by the
-			 * duplicate END characters which are in
-			 * turn sent to try to detect line noise.
-			 */
-			if buf.Len() > 0 {
-				p = buf.Bytes()
-				isPrefix = false
-				return
-			} else {
+		if !esc && (s.leadSeen || !s.leadReq) {
+			if n := s.plainRun(len(p), limit); n > 0 {
+				if s.capture {
+					s.raw = append(s.raw, s.buf[s.rd:s.rd+n]...)
+				}
+				p = append(p, s.buf[s.rd:s.rd+n]...)
+				s.rd += n
 				continue
 			}
+		}
+
+		/* get a character to process
+		 */
+		c, err := s.readByte()
+		if err != nil {
+			if (len(p) > 0 || esc) && s.frameTimedOut(err) {
+				atomic.AddUint64(&s.stats.errors, 1)
+				return p[:0], false, ErrFrameTimeout
+			}
+			return s.suspend(p, esc, err)
+		}
 
-		/* if it's the same code as an ESC character, wait
-		 * and get another character and then figure out
-		 * what to store in the packet based on that.
+		/* make sure the stream starts with an END if we
+		 * have been asked to
 		 */
-		case ESC:
-			n, err = s.r.Read(readBuf)
+		if s.leadReq && !s.leadSeen && !s.noFrame {
+			if c != s.end {
+				s.skip = true
+				atomic.AddUint64(&s.stats.errors, 1)
+				return p, false, ErrMissingLeadingEnd
+			}
+			s.leadSeen = true
+		}
 
-			if n == 0 || err != nil {
-				isPrefix = true
-				p = buf.Bytes()
-				return
+		/* an END right after an ESC still ends the packet
+		 * and the ESC is dropped, so a damaged escape
+		 * sequence never merges two packets, unless we have
+		 * been asked to be strict about it
+		 */
+		if esc && c == s.end && !s.strict {
+			esc = false
+			if s.logger != nil {
+				s.logger.Printf("slip: ESC before END at stream offset %d, frame offset %d, dropped",
+					s.offset()-1, len(p))
 			}
+		}
+
+		used := 1 // raw bytes of c, 2 for an escape sequence
+		if esc {
+			esc = false
+			used = 2
 
 			/* if "c" is not one of these two, then we
 			 * have a protocol violation.  The best bet
 			 * seems to be to leave the byte alone and
-			 * just stuff it into the packet
+			 * just stuff it into the packet, unless we
+			 * have been asked to be strict about it
 			 */
-			switch readBuf[0] {
-			case ESC_END:
-				readBuf[0] = END
-			case ESC_ESC:
-				readBuf[0] = ESC
+			if b, ok := s.unescape(c); ok {
+				c = b
+				s.nesc++
+			} else if s.strict {
+				/* an END after the ESC still ends the packet,
+				 * so there is nothing left to drop
+				 */
+				s.skip = c != s.end
+				atomic.AddUint64(&s.stats.errors, 1)
+				return p, false, &InvalidEscapeError{Byte: c, Offset: len(p)}
+			} else if s.logger != nil {
+				s.logger.Printf("slip: invalid escape %#02x at stream offset %d, frame offset %d, stored as is",
+					c, s.offset()-1, len(p))
+			}
+		} else {
+			/* handle bytestuffing if necessary
+			 */
+			switch c {
+
+			/* if it's an END character then we're done with
+			 * the packet
+			 */
+			case s.end:
+				if s.noFrame {
+					atomic.AddUint64(&s.stats.errors, 1)
+					return p, false, ErrUnescapedEnd
+				}
+
+				/* a minor optimization: if there is no
+				 * data in the packet, ignore it. This is
+				 * meant to avoid bothering IP with all
+				 * the empty packets generated by the
+				 * duplicate END characters which are in
+				 * turn sent to try to detect line noise,
+				 * unless we have been asked to keep them.
+				 * Packets that are mostly escape sequences
+				 * are dropped if we have been asked to.
+				 */
+				if s.ratio > 0 && len(p) > 0 && float64(s.nesc) > s.ratio*float64(len(p)) {
+					atomic.AddUint64(&s.stats.errors, 1)
+					return p[:0], false, ErrSuspiciousFrame
+				}
+				if len(p) > 0 && s.wire != nil {
+					if p, err = s.verifyWire(p); err != nil {
+						atomic.AddUint64(&s.stats.errors, 1)
+						return p, false, err
+					}
+				}
+				if len(p) > 0 && s.crc != nil {
+					if p, err = s.crc.verify(p); err != nil {
+						atomic.AddUint64(&s.stats.errors, 1)
+						return p, false, err
+					}
+				}
+				if len(p) > 0 {
+					if s.timed {
+						s.doneAt = time.Now()
+					}
+					atomic.AddUint64(&s.stats.packets, 1)
+					atomic.AddUint64(&s.stats.bytes, uint64(len(p)))
+					return p, false, nil
+				}
+				atomic.AddUint64(&s.stats.emptyFrames, 1)
+				if s.onIdle != nil {
+					s.onIdle()
+				}
+				if s.empty {
+					if s.timed {
+						s.doneAt = time.Now()
+					}
+					if p == nil {
+						p = []byte{}
+					}
+					return p, false, nil
+				}
+				continue
+
+			/* if it's the same code as an ESC character, wait
+			 * and get another character and then figure out
+			 * what to store in the packet based on that.
+			 */
+			case s.esc:
+				esc = true
+				continue
 			}
 		}
 
 		/* here we fall into the default handler and let
-		 * it store the character for us
+		 * it store the character for us, unless we ran
+		 * out of room
 		 */
-		buf.WriteByte(readBuf[0])
+		if limit >= 0 && len(p) >= limit {
+			s.overflow(used)
+			atomic.AddUint64(&s.stats.errors, 1)
+			return p, false, errOverflow
+		}
+		p = append(p, c)
 	}
 }
+
+// overflow drops the rest of an oversized packet, whose n raw bytes
+// that did not fit have been read, up to its END right away, so the
+// read that fails reports them in LastOverflowBytes. If the underlying
+// reader fails first, the next read drops the rest.
+func (s *Reader) overflow(n int) {
+	s.nskip += n
+	s.skip = true
+	n, _ = s.skipPacket()
+	s.nskip += n
+}
@@ -2,9 +2,13 @@ package slip
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 	"io"
 	"strconv"
 	"testing"
+	"testing/iotest"
+	"time"
 )
 
 var readData = []struct {
@@ -13,9 +17,10 @@ var readData = []struct {
 	isPrefix bool
 	err      error
 }{
-	{[]byte{}, []byte{}, true, io.EOF},
+	// No packet was started
+	{[]byte{}, []byte{}, false, io.EOF},
 	// All the END are received till EOF or data
-	{[]byte{END, END, END, END}, []byte{}, true, io.EOF},
+	{[]byte{END, END, END, END}, []byte{}, false, io.EOF},
 	{[]byte{END, END, 1, END}, []byte{1}, false, nil},
 	// Properly terminated data
 	{[]byte{1, 2, 3, END}, []byte{1, 2, 3}, false, nil},
@@ -23,13 +28,16 @@ var readData = []struct {
 	{[]byte{ESC, ESC_ESC, END}, []byte{ESC}, false, nil},
 	{[]byte{ESC, ESC_END, END}, []byte{END}, false, nil},
 	// Non terminated data
-	{[]byte{1, 2, 3}, []byte{1, 2, 3}, true, io.EOF},
-	{[]byte{ESC, ESC_ESC}, []byte{ESC}, true, io.EOF},
-	{[]byte{ESC, ESC_END}, []byte{END}, true, io.EOF},
+	{[]byte{1, 2, 3}, []byte{1, 2, 3}, true, io.ErrUnexpectedEOF},
+	{[]byte{ESC, ESC_ESC}, []byte{ESC}, true, io.ErrUnexpectedEOF},
+	{[]byte{ESC, ESC_END}, []byte{END}, true, io.ErrUnexpectedEOF},
+	{[]byte{END, 1, 2, 3, END, 4}, []byte{1, 2, 3}, false, nil},
 	// Bad control sequences
-	{[]byte{1, ESC_ESC, 3}, []byte{1, ESC_ESC, 3}, true, io.EOF},
-	{[]byte{1, ESC_END, 3}, []byte{1, ESC_END, 3}, true, io.EOF},
-	{[]byte{1, ESC, 3}, []byte{1, 3}, true, io.EOF},
+	{[]byte{1, ESC_ESC, 3}, []byte{1, ESC_ESC, 3}, true, io.ErrUnexpectedEOF},
+	{[]byte{1, ESC_END, 3}, []byte{1, ESC_END, 3}, true, io.ErrUnexpectedEOF},
+	{[]byte{1, ESC, 3}, []byte{1, 3}, true, io.ErrUnexpectedEOF},
+	// An END after an ESC ends the packet, the ESC is dropped
+	{[]byte{1, ESC, END, 2}, []byte{1}, false, nil},
 }
 
 var writeData = []struct {
@@ -91,8 +99,8 @@ func TestReadMultipart(t *testing.T) {
 	r := NewReader(buf)
 	p, isPrefix, err := r.ReadPacket()
 
-	if err != io.EOF {
-		t.Error("Expected error", io.EOF, "but got", err)
+	if err != io.ErrUnexpectedEOF {
+		t.Error("Expected error", io.ErrUnexpectedEOF, "but got", err)
 	}
 	if !isPrefix {
 		t.Error("Expected isPrefix", true, "but got", isPrefix)
@@ -101,18 +109,128 @@ func TestReadMultipart(t *testing.T) {
 		t.Error("Expected data", part1, "but got", p)
 	}
 
-	part2 := []byte{1, 2, 3, END}
+	// The reader resumes the packet and returns it as a whole
+	part2 := []byte{4, ESC}
 	buf.Write(part2)
 	p, isPrefix, err = r.ReadPacket()
 
+	if err != ErrIncompleteEscape {
+		t.Error("Expected error", ErrIncompleteEscape, "but got", err)
+	}
+	if !isPrefix {
+		t.Error("Expected isPrefix", true, "but got", isPrefix)
+	}
+	if !eqBytes(p, []byte{1, 2, 3, 4}) {
+		t.Error("Expected data", []byte{1, 2, 3, 4}, "but got", p)
+	}
+
+	// The pending ESC is completed by the next part
+	part3 := []byte{ESC_END, 5, END}
+	buf.Write(part3)
+	p, isPrefix, err = r.ReadPacket()
+
 	if err != nil {
 		t.Error("Expected error", nil, "but got", err)
 	}
 	if isPrefix {
 		t.Error("Expected isPrefix", false, "but got", isPrefix)
 	}
-	if !eqBytes(p, part2[:len(part2)-1]) {
-		t.Error("Expected data", part2, "but got", p)
+	if !eqBytes(p, []byte{1, 2, 3, 4, END, 5}) {
+		t.Error("Expected data", []byte{1, 2, 3, 4, END, 5}, "but got", p)
+	}
+}
+
+// zeroReader returns no data and no error
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	return 0, nil
+}
+
+// dataErrReader returns the last byte of every chunk together with err.
+type dataErrReader struct {
+	data []byte
+	err  error
+}
+
+func (d *dataErrReader) Read(p []byte) (int, error) {
+	if len(d.data) == 0 {
+		return 0, d.err
+	}
+	n := copy(p[:1], d.data)
+	d.data = d.data[n:]
+	if len(d.data) == 0 {
+		return n, d.err
+	}
+	return n, nil
+}
+
+func TestReadDataWithError(t *testing.T) {
+	for i, d := range readData {
+		r := NewReader(iotest.DataErrReader(bytes.NewReader(d.data)))
+		p, isPrefix, err := r.ReadPacket()
+		if err != d.err || isPrefix != d.isPrefix || !eqBytes(p, d.expected) {
+			t.Error(strconv.Itoa(i), "Expected data", d.expected, d.isPrefix, d.err, "but got", p, isPrefix, err)
+		}
+	}
+
+	// The final END delivered with the error completes the packet
+	r := NewReader(&dataErrReader{[]byte{END, 1, 2, END}, errLimit})
+	if p, _, err := r.ReadPacket(); err != nil || !eqBytes([]byte{1, 2}, p) {
+		t.Error("Expected data", []byte{1, 2}, "but got", p, err)
+	}
+	if _, _, err := r.ReadPacket(); err != errLimit {
+		t.Error("Expected error", errLimit, "but got", err)
+	}
+
+	// A byte delivered with the error is part of the prefix
+	r = NewReader(&dataErrReader{[]byte{END, 1, 2}, errLimit})
+	if p, isPrefix, err := r.ReadPacket(); err != errLimit || !isPrefix || !eqBytes([]byte{1, 2}, p) {
+		t.Error("Expected data", []byte{1, 2}, "with", errLimit, "but got", p, isPrefix, err)
+	}
+}
+
+func TestReadZeroRead(t *testing.T) {
+	r := NewReader(zeroReader{})
+	p, isPrefix, err := r.ReadPacket()
+	if err != io.ErrNoProgress {
+		t.Error("Expected error", io.ErrNoProgress, "but got", err)
+	}
+	if isPrefix {
+		t.Error("Expected isPrefix", false, "but got", isPrefix)
+	}
+	if len(p) != 0 {
+		t.Error("Expected no data but got", p)
+	}
+}
+
+// stutterReader returns a few empty reads before every byte of r.
+type stutterReader struct {
+	r     io.Reader
+	empty int
+}
+
+func (s *stutterReader) Read(p []byte) (int, error) {
+	if s.empty < 3 {
+		s.empty++
+		return 0, nil
+	}
+	s.empty = 0
+	return s.r.Read(p[:1])
+}
+
+func TestReadRetriesZeroRead(t *testing.T) {
+	// Empty reads between ESC and ESC_END too
+	r := NewReader(&stutterReader{r: bytes.NewReader([]byte{END, 1, ESC, ESC_END, 2, END})})
+	p, _, err := r.ReadPacket()
+	if err != nil {
+		t.Error("Unexpected error:", err)
+	}
+	if !eqBytes(p, []byte{1, END, 2}) {
+		t.Error("Expected data", []byte{1, END, 2}, "but got", p)
+	}
+	if _, _, err = r.ReadPacket(); err != io.EOF {
+		t.Error("Expected error", io.EOF, "but got", err)
 	}
 }
 
@@ -136,6 +254,77 @@ func TestWrite(t *testing.T) {
 	}
 }
 
+func TestWritePacketN(t *testing.T) {
+	for i, d := range writeData {
+		for _, opt := range []WriterOption{WithStreamingWrites(0), WithStreamingWrites(2)} {
+			buf := &bytes.Buffer{}
+			n, err := NewWriter(buf, opt).WritePacketN(d.data)
+			if err != nil || n != len(d.expected) {
+				t.Error(strconv.Itoa(i), "Expected", len(d.expected), "bytes but got", n, err)
+			}
+		}
+	}
+
+	// A short write reports the partial count
+	n, err := NewWriter(&limitedWriter{n: 2}).WritePacketN([]byte{1, 2, 3})
+	if err != errLimit || n != 2 {
+		t.Error("Expected 2 bytes and", errLimit, "but got", n, err)
+	}
+
+	// Buffered packets count once they are written
+	w := NewWriter(&bytes.Buffer{}, WithWriteBuffering(8))
+	if n, err = w.WritePacketN([]byte{1, 2}); err != nil || n != 0 {
+		t.Error("Expected 0 bytes but got", n, err)
+	}
+	// The first packet is written, the second stays buffered
+	if n, err = w.WritePacketN([]byte{3, 4, 5}); err != nil || n != 4 {
+		t.Error("Expected 4 bytes but got", n, err)
+	}
+}
+
+// shortWriter takes at most n bytes per Write, returning err with short
+// writes.
+type shortWriter struct {
+	bytes.Buffer
+	n   int
+	err error
+}
+
+func (w *shortWriter) Write(p []byte) (int, error) {
+	if len(p) > w.n {
+		m, _ := w.Buffer.Write(p[:w.n])
+		return m, w.err
+	}
+	return w.Buffer.Write(p)
+}
+
+func TestWritePacketShortWrite(t *testing.T) {
+	for _, err := range []error{nil, io.ErrShortWrite} {
+		for _, opt := range []WriterOption{WithStreamingWrites(0), WithStreamingWrites(4), WithWriteBuffering(8)} {
+			sw := &shortWriter{n: 2, err: err}
+			w := NewWriter(sw, opt)
+			if err := w.WritePacket([]byte{1, 2, END, 3}); err != nil {
+				t.Error("Unexpected error:", err)
+			}
+			w.WritePackets([][]byte{{4}, {5, 6}})
+			w.Flush()
+			expected := []byte{END, 1, 2, ESC, ESC_END, 3, END, END, 4, END, END, 5, 6, END}
+			if !eqBytes(expected, sw.Bytes()) {
+				t.Error("Expected data", expected, "but got", sw.Bytes())
+			}
+			if s := w.Stats(); s.Packets != 3 || s.Bytes != uint64(len(expected)) {
+				t.Error("Expected 3 packets and", len(expected), "bytes but got", s)
+			}
+		}
+	}
+
+	// A writer that takes nothing fails the packet
+	sw := &shortWriter{n: 0}
+	if err := NewWriter(sw).WritePacket([]byte{1}); err != io.ErrShortWrite {
+		t.Error("Expected error", io.ErrShortWrite, "but got", err)
+	}
+}
+
 func TestWriteAndRead(t *testing.T) {
 	for i, d := range writeData {
 		buf := &bytes.Buffer{}
@@ -160,3 +349,1501 @@ func TestWriteAndRead(t *testing.T) {
 		}
 	}
 }
+
+// countingReader counts the calls to Read on the wrapped reader
+type countingReader struct {
+	r     io.Reader
+	calls int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	c.calls++
+	return c.r.Read(p)
+}
+
+// controlSequences returns every sequence of up to n bytes made of the
+// control bytes and a plain byte.
+func controlSequences(n int) [][]byte {
+	alphabet := []byte{END, ESC, ESC_END, ESC_ESC, 1}
+	seqs := [][]byte{{}}
+	for last := seqs; n > 0; n-- {
+		var next [][]byte
+		for _, seq := range last {
+			for _, b := range alphabet {
+				next = append(next, append(append([]byte(nil), seq...), b))
+			}
+		}
+		seqs = append(seqs, next...)
+		last = next
+	}
+	return seqs
+}
+
+func TestRoundtripControlBytes(t *testing.T) {
+	all := make([]byte, 256)
+	for i := range all {
+		all[i] = byte(i)
+	}
+	payloads := append(controlSequences(4), all, bytes.Repeat([]byte{ESC_END, ESC_ESC}, 100))
+
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	for _, p := range payloads {
+		w.WritePacket(p)
+	}
+	for _, strict := range []bool{false, true} {
+		r := NewReader(bytes.NewReader(buf.Bytes()), WithStrictDecoding(strict), WithKeepEmptyFrames(true))
+		for i, p := range payloads {
+			// Every packet is preceded by an empty frame
+			r.ReadPacket()
+			got, _, err := r.ReadPacket()
+			if err != nil || !eqBytes(p, got) {
+				t.Fatal(strconv.Itoa(i), "Expected data", p, "but got", got, err)
+			}
+			if dec, err := Decode(nil, Encode(nil, p)); len(p) > 0 && (err != nil || !eqBytes(p, dec)) {
+				t.Fatal(strconv.Itoa(i), "Expected data", p, "but got", dec, err)
+			}
+		}
+
+		packets, errs := feed(NewDecoder(WithStrictDecoding(strict), WithKeepEmptyFrames(true)), buf.Bytes())
+		if len(errs) != 0 || len(packets) != 2*len(payloads) {
+			t.Fatal("Expected", 2*len(payloads), "packets but got", len(packets), errs)
+		}
+		for i, p := range payloads {
+			if !eqBytes(p, packets[2*i+1]) {
+				t.Fatal(strconv.Itoa(i), "Expected data", p, "but got", packets[2*i+1])
+			}
+		}
+	}
+}
+
+func TestReadBuffered(t *testing.T) {
+	data := []byte{END, 1, 2, END, END, 3, ESC, ESC_END, END, 4, 5, END}
+	expected := [][]byte{{1, 2}, {3, END}, {4, 5}}
+
+	cr := &countingReader{r: bytes.NewReader(data)}
+	r := NewReader(cr)
+
+	for i, e := range expected {
+		p, isPrefix, err := r.ReadPacket()
+		if err != nil {
+			t.Error(strconv.Itoa(i), "Unexpected error:", err)
+		}
+		if isPrefix {
+			t.Error(strconv.Itoa(i), "Expected no Prefix but is", isPrefix)
+		}
+		if !eqBytes(p, e) {
+			t.Error(strconv.Itoa(i), "Expected data", e, "but got", p)
+		}
+	}
+
+	// All frames are served from a single read on the underlying reader
+	if cr.calls != 1 {
+		t.Error("Expected 1 call to Read but got", cr.calls)
+	}
+}
+
+func TestBuffered(t *testing.T) {
+	r := NewReader(bytes.NewReader([]byte{END, 1, END, 2, ESC, ESC_END, END}))
+	if n := r.Buffered(); n != 0 {
+		t.Error("Expected 0 buffered bytes but got", n)
+	}
+	r.ReadPacket()
+	if n := r.Buffered(); n != 4 {
+		t.Error("Expected 4 buffered bytes but got", n)
+	}
+	r.Peek(1)
+	if n := r.Buffered(); n != 3 {
+		t.Error("Expected 3 buffered bytes but got", n)
+	}
+	r.ReadPacket()
+	if n := r.Buffered(); n != 0 {
+		t.Error("Expected 0 buffered bytes but got", n)
+	}
+}
+
+func TestReadSmallBuffer(t *testing.T) {
+	// A buffer of 1 byte splits every ESC sequence across refills
+	for _, size := range []int{1, 2, 3} {
+		for i, d := range writeData {
+			r := NewReaderSize(bytes.NewReader(d.expected), size)
+			p, isPrefix, err := r.ReadPacket()
+
+			if err != nil {
+				t.Error(strconv.Itoa(size), strconv.Itoa(i), "Unexpected error:", err)
+			}
+			if isPrefix {
+				t.Error(strconv.Itoa(size), strconv.Itoa(i), "Expected no Prefix but is", isPrefix)
+			}
+			if !eqBytes(p, d.data) {
+				t.Error(strconv.Itoa(size), strconv.Itoa(i), "Expected data", d.data, "but got", p)
+			}
+		}
+	}
+}
+
+var readIntoData = []struct {
+	data     []byte
+	size     int
+	expected []byte
+	err      error
+}{
+	{[]byte{1, 2, 3, END}, 3, []byte{1, 2, 3}, nil},
+	{[]byte{1, 2, 3, END}, 8, []byte{1, 2, 3}, nil},
+	{[]byte{END, ESC, ESC_END, END}, 1, []byte{END}, nil},
+	{[]byte{1, 2, 3, END}, 2, []byte{1, 2}, ErrBufferTooSmall},
+	{[]byte{1, 2, ESC, ESC_ESC, END}, 2, []byte{1, 2}, ErrBufferTooSmall},
+	{[]byte{1, END}, 0, []byte{}, ErrBufferTooSmall},
+	{[]byte{1, 2, 3}, 8, []byte{1, 2, 3}, io.ErrUnexpectedEOF},
+	{[]byte{}, 8, []byte{}, io.EOF},
+}
+
+func TestReadPacketInto(t *testing.T) {
+	for i, d := range readIntoData {
+		r := NewReader(bytes.NewReader(d.data))
+		dst := make([]byte, d.size)
+		n, err := r.ReadPacketInto(dst)
+
+		if err != d.err {
+			t.Error(strconv.Itoa(i), "Expected error", d.err, "but got", err)
+		}
+		if !eqBytes(dst[:n], d.expected) {
+			t.Error(strconv.Itoa(i), "Expected data", d.expected, "but got", dst[:n])
+		}
+	}
+}
+
+func TestReadPacketIntoReuse(t *testing.T) {
+	data := []byte{1, 2, 3, 4, END, 5, END, 6, 7, 8, 9, 10, END, 11, END}
+	expected := [][]byte{{1, 2, 3, 4}, {5}, {6, 7, 8, 9}, {11}}
+	errs := []error{nil, nil, ErrBufferTooSmall, nil}
+
+	r := NewReader(bytes.NewReader(data))
+	dst := make([]byte, 4)
+	for i, e := range expected {
+		n, err := r.ReadPacketInto(dst)
+		if err != errs[i] {
+			t.Error(strconv.Itoa(i), "Expected error", errs[i], "but got", err)
+		}
+		if !eqBytes(dst[:n], e) {
+			t.Error(strconv.Itoa(i), "Expected data", e, "but got", dst[:n])
+		}
+	}
+}
+
+func TestReadMaxPacketSize(t *testing.T) {
+	data := []byte{
+		1, 2, END, // fits
+		1, 2, 3, 4, END, // too large
+		1, 2, ESC, ESC_END, END, // overflow mid ESC sequence
+		1, 2, 3, ESC, ESC_END, 4, END, // escaped END in the dropped part
+		5, END,
+	}
+	expected := []struct {
+		p   []byte
+		err error
+	}{
+		{[]byte{1, 2}, nil},
+		{[]byte{1, 2}, ErrPacketTooLarge},
+		{[]byte{1, 2}, ErrPacketTooLarge},
+		{[]byte{1, 2}, ErrPacketTooLarge},
+		{[]byte{5}, nil},
+		{[]byte{}, io.EOF},
+	}
+
+	r := NewReader(bytes.NewReader(data))
+	r.MaxPacketSize = 2
+	for i, e := range expected {
+		p, _, err := r.ReadPacket()
+		if err != e.err {
+			t.Error(strconv.Itoa(i), "Expected error", e.err, "but got", err)
+		}
+		if !eqBytes(p, e.p) {
+			t.Error(strconv.Itoa(i), "Expected data", e.p, "but got", p)
+		}
+	}
+}
+
+func TestLastOverflowBytes(t *testing.T) {
+	data := []byte{END, 1, 2, 3, 4, 5, 6, 7, 8, ESC, ESC_END, END, 2, END, 3, END}
+	r := NewReader(bytes.NewReader(data), WithMaxPacketSize(4))
+	if _, _, err := r.ReadPacket(); err != ErrPacketTooLarge {
+		t.Fatal("Expected error", ErrPacketTooLarge, "but got", err)
+	}
+	// The failing read drops 5, 6, 7, 8, ESC, ESC_END and END
+	if n := r.LastOverflowBytes(); n != 7 {
+		t.Error("Expected 7 bytes but got", n)
+	}
+	for i := 0; i < 2; i++ {
+		if _, _, err := r.ReadPacket(); err != nil {
+			t.Fatal(strconv.Itoa(i), "Unexpected error", err)
+		}
+		if n := r.LastOverflowBytes(); n != 0 {
+			t.Error(strconv.Itoa(i), "Expected 0 bytes but got", n)
+		}
+	}
+
+	// An escape sequence that does not fit counts with both bytes
+	r = NewReader(bytes.NewReader([]byte{END, 1, 2, ESC, ESC_ESC, 3, END}), WithMaxPacketSize(2))
+	if _, _, err := r.ReadPacket(); err != ErrPacketTooLarge {
+		t.Fatal("Expected error", ErrPacketTooLarge, "but got", err)
+	}
+	if n := r.LastOverflowBytes(); n != 4 {
+		t.Error("Expected 4 bytes but got", n)
+	}
+
+	// The rest of a packet cut by the underlying reader comes later
+	r = NewReader(&chunkReader{[][]byte{{END, 1, 2, 3}, nil, {4, END, 5, END}}}, WithMaxPacketSize(2))
+	if _, _, err := r.ReadPacket(); err != ErrPacketTooLarge {
+		t.Fatal("Expected error", ErrPacketTooLarge, "but got", err)
+	}
+	if n := r.LastOverflowBytes(); n != 1 {
+		t.Error("Expected 1 byte but got", n)
+	}
+	if p, _, err := r.ReadPacket(); err != nil || !eqBytes([]byte{5}, p) || r.LastOverflowBytes() != 2 {
+		t.Error("Expected data", []byte{5}, "after 2 bytes but got", p, err, r.LastOverflowBytes())
+	}
+
+	r = NewReader(bytes.NewReader(data), WithMaxPacketSize(4), WithAutoResync(true))
+	p, _, err := r.ReadPacket()
+	if err != nil || !eqBytes([]byte{2}, p) {
+		t.Error("Expected data", []byte{2}, "but got", p, err)
+	}
+	if n := r.LastOverflowBytes(); n != 7 {
+		t.Error("Expected 7 bytes but got", n)
+	}
+}
+
+func TestReadPacketTimed(t *testing.T) {
+	r := NewReader(&chunkReader{[][]byte{{END, 1, 2}, nil, {3, END, 4, END, 5}}})
+	if p, ts, err := r.ReadPacketTimed(); err != errLimit || !ts.IsZero() || !eqBytes([]byte{1, 2}, p) {
+		t.Fatal("Expected partial packet with error", errLimit, "but got", p, ts, err)
+	}
+	before := time.Now()
+	p, ts, err := r.ReadPacketTimed()
+	if err != nil || !eqBytes([]byte{1, 2, 3}, p) {
+		t.Fatal("Expected data", []byte{1, 2, 3}, "but got", p, err)
+	}
+	if ts.Before(before) || ts.After(time.Now()) {
+		t.Error("Expected time after", before, "but got", ts)
+	}
+
+	// The time of a packet completed by Peek is taken by Peek
+	r.Peek(2)
+	after := time.Now()
+	if _, ts, err = r.ReadPacketTimed(); err != nil || ts.After(after) {
+		t.Error("Expected time before", after, "but got", ts, err)
+	}
+	if _, ts, err = r.ReadPacketTimed(); err != io.ErrUnexpectedEOF || !ts.IsZero() {
+		t.Error("Expected error", io.ErrUnexpectedEOF, "and zero time but got", ts, err)
+	}
+}
+
+func TestReadPacketRaw(t *testing.T) {
+	wire := [][]byte{
+		{END, 1, ESC, ESC_END, 2, END},
+		{3, ESC, ESC_ESC, END}, // no leading END of its own
+		{END, END, 4, END},
+	}
+	r := NewReader(bytes.NewReader(bytes.Join(wire, nil)))
+	for i, expected := range [][]byte{{1, END, 2}, {3, ESC}, {4}} {
+		p, raw, err := r.ReadPacketRaw()
+		if err != nil || !eqBytes(expected, p) {
+			t.Error(strconv.Itoa(i), "Expected data", expected, "but got", p, err)
+		}
+		if !eqBytes(wire[i], raw) {
+			t.Error(strconv.Itoa(i), "Expected raw data", wire[i], "but got", raw)
+		}
+	}
+
+	// A resumed packet keeps its raw bytes
+	r = NewReader(&chunkReader{[][]byte{{END, 1, ESC}, nil, {ESC_END, END}}})
+	if _, raw, err := r.ReadPacketRaw(); err != errLimit || !eqBytes([]byte{END, 1, ESC}, raw) {
+		t.Error("Expected raw data", []byte{END, 1, ESC}, "but got", raw, err)
+	}
+	p, raw, err := r.ReadPacketRaw()
+	if expected := []byte{END, 1, ESC, ESC_END, END}; err != nil || !eqBytes([]byte{1, END}, p) || !eqBytes(expected, raw) {
+		t.Error("Expected raw data", expected, "but got", p, raw, err)
+	}
+	if _, raw, err = r.ReadPacketRaw(); err != io.EOF || raw != nil {
+		t.Error("Expected EOF but got", raw, err)
+	}
+}
+
+func TestNextFrame(t *testing.T) {
+	data := []byte{END, 1, 2, 3, END, 4, ESC, ESC_END, END, 5, 6, 7, 8, 9, END, 1, 2}
+	r := NewReader(bytes.NewReader(data), WithMaxPacketSize(4))
+	for i, expected := range [][]byte{{1, 2, 3}, {4, END}} {
+		p, err := r.NextFrame()
+		if err != nil || !eqBytes(expected, p) {
+			t.Error(strconv.Itoa(i), "Expected data", expected, "but got", p, err)
+		}
+		// The buffer is reused
+		if i > 0 && &p[0] != &r.fbuf[:1][0] {
+			t.Error(strconv.Itoa(i), "Expected a view of the frame buffer")
+		}
+	}
+	if p, err := r.NextFrame(); err != ErrPacketTooLarge || p != nil {
+		t.Error("Expected error", ErrPacketTooLarge, "but got", p, err)
+	}
+	if p, err := r.NextFrame(); err != io.ErrUnexpectedEOF || p != nil {
+		t.Error("Expected error", io.ErrUnexpectedEOF, "but got", p, err)
+	}
+	if cap(r.fbuf) < 4 {
+		t.Error("Expected the buffer to keep its size but got", cap(r.fbuf))
+	}
+}
+
+func TestReadPacketLimited(t *testing.T) {
+	data := []byte{END, 1, 2, 3, END, 4, 5, 6, END, 7, 8, 9, END, 10, END}
+	r := NewReader(bytes.NewReader(data))
+	var tests = []struct {
+		max      int
+		expected []byte
+		err      error
+	}{
+		{3, []byte{1, 2, 3}, nil},
+		{2, []byte{4, 5}, ErrPacketTooLarge},
+		{0, []byte{7, 8, 9}, nil},
+		{5, []byte{10}, nil},
+		{0, nil, io.EOF},
+	}
+	for i, test := range tests {
+		p, err := r.ReadPacketLimited(test.max)
+		if err != test.err || !eqBytes(test.expected, p) {
+			t.Error(strconv.Itoa(i), "Expected data", test.expected, test.err, "but got", p, err)
+		}
+	}
+
+	// MaxPacketSize still applies
+	r = NewReader(bytes.NewReader(data), WithMaxPacketSize(2))
+	if p, err := r.ReadPacketLimited(3); err != ErrPacketTooLarge || !eqBytes([]byte{1, 2}, p) {
+		t.Error("Expected data", []byte{1, 2}, ErrPacketTooLarge, "but got", p, err)
+	}
+}
+
+func TestReadPacketToBuffer(t *testing.T) {
+	r := NewReader(&chunkReader{[][]byte{
+		{END, 1, 2, END, 3}, nil, {ESC, ESC_END, END, 4, 5, 6, END},
+	}}, WithMaxPacketSize(2))
+	dst := bytes.NewBufferString("x")
+	if n, err := r.ReadPacketToBuffer(dst); err != nil || n != 2 {
+		t.Error("Expected 2 bytes but got", n, err)
+	}
+	// The unfinished packet is not appended twice
+	if n, err := r.ReadPacketToBuffer(dst); err != errLimit || n != 0 {
+		t.Error("Expected error", errLimit, "but got", n, err)
+	}
+	if n, err := r.ReadPacketToBuffer(dst); err != nil || n != 2 {
+		t.Error("Expected 2 bytes but got", n, err)
+	}
+	if n, err := r.ReadPacketToBuffer(dst); err != ErrPacketTooLarge || n != 0 {
+		t.Error("Expected error", ErrPacketTooLarge, "but got", n, err)
+	}
+	if n, err := r.ReadPacketToBuffer(dst); err != io.EOF || n != 0 {
+		t.Error("Expected error", io.EOF, "but got", n, err)
+	}
+	expected := []byte{'x', 1, 2, 3, END}
+	if !eqBytes(expected, dst.Bytes()) {
+		t.Error("Expected data", expected, "but got", dst.Bytes())
+	}
+}
+
+func TestReadPacketIntoMaxPacketSize(t *testing.T) {
+	r := NewReader(bytes.NewReader([]byte{1, 2, 3, END, 4, END}))
+	r.MaxPacketSize = 2
+	dst := make([]byte, 8)
+
+	n, err := r.ReadPacketInto(dst)
+	if err != ErrPacketTooLarge {
+		t.Error("Expected error", ErrPacketTooLarge, "but got", err)
+	}
+	if n != 2 {
+		t.Error("Expected 2 bytes but got", n)
+	}
+
+	n, err = r.ReadPacketInto(dst)
+	if err != nil {
+		t.Error("Unexpected error:", err)
+	}
+	if !eqBytes(dst[:n], []byte{4}) {
+		t.Error("Expected data", []byte{4}, "but got", dst[:n])
+	}
+}
+
+func TestReadStrict(t *testing.T) {
+	data := []byte{1, ESC, ESC_END, ESC, ESC_ESC, END, 1, 2, ESC, 3, 4, END, 5, END}
+	r := NewReader(bytes.NewReader(data), WithStrictDecoding(true))
+
+	p, _, err := r.ReadPacket()
+	if err != nil {
+		t.Error("Unexpected error:", err)
+	}
+	if !eqBytes(p, []byte{1, END, ESC}) {
+		t.Error("Expected data", []byte{1, END, ESC}, "but got", p)
+	}
+
+	_, _, err = r.ReadPacket()
+	escErr, ok := err.(*InvalidEscapeError)
+	if !ok {
+		t.Fatal("Expected InvalidEscapeError but got", err)
+	}
+	if escErr.Byte != 3 || escErr.Offset != 2 {
+		t.Error("Expected byte 3 at offset 2 but got", escErr.Byte, "at", escErr.Offset)
+	}
+	if !errors.Is(err, ErrInvalidEscape) {
+		t.Error("Expected error to match", ErrInvalidEscape)
+	}
+
+	// The rest of the bad packet is dropped
+	p, _, err = r.ReadPacket()
+	if err != nil {
+		t.Error("Unexpected error:", err)
+	}
+	if !eqBytes(p, []byte{5}) {
+		t.Error("Expected data", []byte{5}, "but got", p)
+	}
+
+	// An END after the ESC ends the bad packet, the next one is kept
+	r = NewReader(bytes.NewReader([]byte{1, ESC, END, 2, END}), WithStrictDecoding(true))
+	if _, _, err = r.ReadPacket(); !errors.Is(err, ErrInvalidEscape) {
+		t.Error("Expected error", ErrInvalidEscape, "but got", err)
+	}
+	p, _, err = r.ReadPacket()
+	if err != nil || !eqBytes(p, []byte{2}) {
+		t.Error("Expected data", []byte{2}, "but got", p, err)
+	}
+}
+
+func TestReadEscapeBeforeEnd(t *testing.T) {
+	data := []byte{1, ESC, END, 2, END, ESC, END, 3, END}
+
+	// Lenient decoding keeps the packet boundary
+	r := NewReader(bytes.NewReader(data))
+	d := NewDecoder()
+	packets, _ := feed(d, data)
+	for i, expected := range [][]byte{{1}, {2}, {3}} {
+		p, _, err := r.ReadPacket()
+		if err != nil || !eqBytes(expected, p) {
+			t.Error(strconv.Itoa(i), "Expected data", expected, "but got", p, err)
+		}
+		if i >= len(packets) || !eqBytes(expected, packets[i]) {
+			t.Error(strconv.Itoa(i), "Expected Decoder data", expected, "but got", packets)
+		}
+	}
+	if s := r.Stats(); s.Errors != 0 {
+		t.Error("Expected no errors but got", s.Errors)
+	}
+
+	// Strict decoding reports the END and drops the packet only
+	r = NewReader(bytes.NewReader(data), WithStrictDecoding(true))
+	_, _, err := r.ReadPacket()
+	var escErr *InvalidEscapeError
+	if !errors.As(err, &escErr) || escErr.Byte != END || escErr.Offset != 1 {
+		t.Fatal("Expected InvalidEscapeError of END at offset 1 but got", err)
+	}
+	if p, _, err := r.ReadPacket(); err != nil || !eqBytes([]byte{2}, p) {
+		t.Error("Expected data", []byte{2}, "but got", p, err)
+	}
+}
+
+func TestSkipEscapeBeforeEnd(t *testing.T) {
+	// An END after an ESC also ends the rest of an oversized packet
+	data := []byte{1, 2, 3, ESC, END, 4, END, 5, END}
+	r := NewReader(bytes.NewReader(data), WithMaxPacketSize(2))
+	if _, _, err := r.ReadPacket(); err != ErrPacketTooLarge {
+		t.Fatal("Expected error", ErrPacketTooLarge, "but got", err)
+	}
+	packets, errs := feed(NewDecoder(WithMaxPacketSize(2)), data)
+	if len(errs) != 1 || errs[0] != ErrPacketTooLarge {
+		t.Error("Expected Decoder error", ErrPacketTooLarge, "but got", errs)
+	}
+	for i, expected := range [][]byte{{4}, {5}} {
+		p, _, err := r.ReadPacket()
+		if err != nil || !eqBytes(expected, p) {
+			t.Error(strconv.Itoa(i), "Expected data", expected, "but got", p, err)
+		}
+		if i >= len(packets) || !eqBytes(expected, packets[i]) {
+			t.Error(strconv.Itoa(i), "Expected Decoder data", expected, "but got", packets)
+		}
+	}
+
+	// Discard and Resync stop there too
+	data = []byte{END, 1, ESC, END, 2, END}
+	r = NewReader(bytes.NewReader(data))
+	if p, err := r.Peek(1); err != nil || !eqBytes([]byte{1}, p) {
+		t.Fatal("Expected data", []byte{1}, "but got", p, err)
+	}
+	if err := r.Discard(); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if p, _, err := r.ReadPacket(); err != nil || !eqBytes([]byte{2}, p) {
+		t.Error("Expected data", []byte{2}, "but got", p, err)
+	}
+
+	r = NewReader(&chunkReader{[][]byte{{END, 1}, nil, {ESC, END, 2, END}}})
+	if p, isPrefix, err := r.ReadPacket(); !isPrefix || err != errLimit {
+		t.Fatal("Expected a prefix but got", p, isPrefix, err)
+	}
+	if err := r.Resync(); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if p, _, err := r.ReadPacket(); err != nil || !eqBytes([]byte{2}, p) {
+		t.Error("Expected data", []byte{2}, "but got", p, err)
+	}
+
+	// Strict decoding skips the escaped END as before
+	r = NewReader(bytes.NewReader([]byte{1, 2, 3, ESC, END, 4, END, 5, END}),
+		WithMaxPacketSize(2), WithStrictDecoding(true))
+	r.ReadPacket()
+	if p, _, err := r.ReadPacket(); err != nil || !eqBytes([]byte{5}, p) {
+		t.Error("Expected data", []byte{5}, "but got", p, err)
+	}
+}
+
+// countingWriter counts the calls to Write on the wrapped writer
+type countingWriter struct {
+	w     io.Writer
+	calls int
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.calls++
+	return c.w.Write(p)
+}
+
+func TestWriteStreaming(t *testing.T) {
+	for i, d := range writeData {
+		buf := &bytes.Buffer{}
+		w := NewWriter(buf, WithStreamingWrites(2))
+		if err := w.WritePacket(d.data); err != nil {
+			t.Error(strconv.Itoa(i), "Unexpected error:", err)
+		}
+		if !eqBytes(buf.Bytes(), d.expected) {
+			t.Error(strconv.Itoa(i), "Expected data", d.expected, "but got", buf.Bytes())
+		}
+	}
+
+	cw := &countingWriter{w: &bytes.Buffer{}}
+	w := NewWriter(cw, WithStreamingWrites(16))
+	if err := w.WritePacket(make([]byte, 100)); err != nil {
+		t.Error("Unexpected error:", err)
+	}
+	if cw.calls < 2 {
+		t.Error("Expected several calls to Write but got", cw.calls)
+	}
+}
+
+// failOnceWriter fails its first Write, e.g. on a write timeout
+type failOnceWriter struct {
+	bytes.Buffer
+	failed bool
+}
+
+func (f *failOnceWriter) Write(p []byte) (int, error) {
+	if !f.failed {
+		f.failed = true
+		return 0, errLimit
+	}
+	return f.Buffer.Write(p)
+}
+
+func TestWriteStreamingRecovers(t *testing.T) {
+	fw := &failOnceWriter{}
+	w := NewWriter(fw, WithStreamingWrites(4))
+	if err := w.WritePacket([]byte{1, 2, 3, 4, 5}); err != errLimit {
+		t.Fatal("Expected error", errLimit, "but got", err)
+	}
+	// The partial frame is dropped, the next packets go out
+	if err := w.WritePacket([]byte{6}); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if err := w.WriteString("a"); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if err := w.WritePacketv([]byte{7}); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	expected := []byte{END, 6, END, END, 'a', END, END, 7, END}
+	if !eqBytes(expected, fw.Bytes()) {
+		t.Error("Expected data", expected, "but got", fw.Bytes())
+	}
+	if s := w.Stats(); s.Packets != 3 {
+		t.Error("Expected 3 packets but got", s.Packets)
+	}
+}
+
+func TestWriteBuffering(t *testing.T) {
+	buf := &bytes.Buffer{}
+	cw := &countingWriter{w: buf}
+	w := NewWriter(cw, WithWriteBuffering(8))
+
+	w.WritePacket([]byte{1})
+	w.WriteString("a")
+	if cw.calls != 0 || w.Stats().Packets != 0 {
+		t.Error("Expected no write before Flush but got", cw.calls, w.Stats())
+	}
+	if _, err := w.Flush(); err != nil {
+		t.Error("Unexpected error:", err)
+	}
+	expected := []byte{END, 1, END, END, 'a', END}
+	if cw.calls != 1 || !eqBytes(buf.Bytes(), expected) {
+		t.Error("Expected one write of", expected, "but got", cw.calls, buf.Bytes())
+	}
+	if s := w.Stats(); s.Packets != 2 {
+		t.Error("Expected 2 packets but got", s.Packets)
+	}
+
+	// A packet that does not fit writes the buffer first
+	buf.Reset()
+	cw.calls = 0
+	w.WritePacket([]byte{1, 2})      // 4 bytes
+	w.WritePacket([]byte{3, 4, 5})   // 5 bytes, does not fit
+	w.WritePacket([]byte{6, END, 7}) // 6 bytes, does not fit either
+	if cw.calls != 2 {
+		t.Error("Expected 2 writes but got", cw.calls)
+	}
+	expected = []byte{END, 1, 2, END, END, 3, 4, 5, END}
+	if !eqBytes(buf.Bytes(), expected) {
+		t.Error("Expected data", expected, "but got", buf.Bytes())
+	}
+	w.Flush()
+	expected = append(expected, END, 6, ESC, ESC_END, 7, END)
+	if cw.calls != 3 || !eqBytes(buf.Bytes(), expected) {
+		t.Error("Expected data", expected, "but got", buf.Bytes())
+	}
+
+	// Packets larger than the buffer are written on their own
+	buf.Reset()
+	cw.calls = 0
+	w.WritePackets([][]byte{{1}, make([]byte, 20)})
+	if cw.calls != 1 || buf.Len() != 25 {
+		t.Error("Expected one write of 25 bytes but got", cw.calls, buf.Len())
+	}
+
+	// Close flushes
+	buf.Reset()
+	w.WritePacket([]byte{9})
+	if err := w.Close(); err != nil {
+		t.Error("Unexpected error:", err)
+	}
+	if expected = []byte{END, 9, END}; !eqBytes(buf.Bytes(), expected) {
+		t.Error("Expected data", expected, "but got", buf.Bytes())
+	}
+	if _, err := w.Flush(); err != ErrClosed {
+		t.Error("Expected error", ErrClosed, "but got", err)
+	}
+}
+
+func TestWriterAvailable(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf, WithWriteBuffering(8))
+	if n, m := w.Available(), w.Buffered(); n != 8 || m != 0 {
+		t.Error("Expected 8 bytes available and 0 buffered but got", n, m)
+	}
+	w.WritePacket([]byte{1, 2})
+	if n, m := w.Available(), w.Buffered(); n != 4 || m != 4 {
+		t.Error("Expected 4 bytes available and 4 buffered but got", n, m)
+	}
+	// 3 bytes fit without a write
+	w.WritePacket([]byte{3})
+	if n, m := w.Available(), w.Buffered(); n != 1 || m != 7 || buf.Len() != 0 {
+		t.Error("Expected 1 byte available and 7 buffered but got", n, m, buf.Len())
+	}
+	w.Flush()
+	if n, m := w.Available(), w.Buffered(); n != 8 || m != 0 || buf.Len() != 7 {
+		t.Error("Expected 8 bytes available and 0 buffered but got", n, m, buf.Len())
+	}
+
+	w = NewWriter(buf)
+	w.WritePacket([]byte{1})
+	if n, m := w.Available(), w.Buffered(); n != 0 || m != 0 {
+		t.Error("Expected nothing available or buffered but got", n, m)
+	}
+}
+
+func TestWriteBufferingError(t *testing.T) {
+	lw := &limitedWriter{n: 4}
+	w := NewWriter(lw, WithWriteBuffering(64))
+	w.WritePacket([]byte{1, 2})
+	w.WritePacket([]byte{3, 4})
+	if n, err := w.Flush(); err != errLimit || n != 4 {
+		t.Error("Expected 4 bytes and error", errLimit, "but got", n, err)
+	}
+	if s := w.Stats(); s.Packets != 0 || s.Bytes != 4 {
+		t.Error("Expected 0 packets and 4 bytes but got", s)
+	}
+	if n := w.Pending(); n != 4 {
+		t.Error("Expected 4 bytes pending but got", n)
+	}
+
+	// The rest is written by the next Flush
+	lw.n = 100
+	if n, err := w.Flush(); err != nil || n != 4 {
+		t.Error("Expected 4 bytes but got", n, err)
+	}
+	if n := w.Pending(); n != 0 {
+		t.Error("Expected nothing pending but got", n)
+	}
+	if n, err := w.Flush(); err != nil || n != 0 {
+		t.Error("Expected empty flush but got", n, err)
+	}
+	expected := []byte{END, 1, 2, END, END, 3, 4, END}
+	if !eqBytes(lw.Bytes(), expected) {
+		t.Error("Expected data", expected, "but got", lw.Bytes())
+	}
+}
+
+func TestPutBufferDropsLargeBuffers(t *testing.T) {
+	buf := &bytes.Buffer{}
+	buf.Grow(maxPooledBufferSize + 1)
+	putBuffer(buf)
+	if got := getBuffer(); got == buf {
+		t.Error("Expected oversized buffer to be dropped")
+	}
+}
+
+func BenchmarkWritePacket(b *testing.B) {
+	benchmarkWritePacket(b, 64)
+}
+
+func BenchmarkWritePacketClean(b *testing.B) {
+	p := bytes.Repeat([]byte{1, 2, 3, 4, 5, 6, 7, 8}, 64)
+	w := NewWriter(io.Discard)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(p)))
+	for i := 0; i < b.N; i++ {
+		if err := w.WritePacket(p); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkWritePacketSmall(b *testing.B) {
+	benchmarkWritePacket(b, 1)
+}
+
+func BenchmarkWritePacketSmallWithoutLocking(b *testing.B) {
+	benchmarkWritePacket(b, 1, WithoutLocking())
+}
+
+func benchmarkWritePacket(b *testing.B, n int, opts ...WriterOption) {
+	p := bytes.Repeat([]byte{1, 2, 3, END, 4, 5, ESC, 6}, n)
+	w := NewWriter(io.Discard, opts...)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(p)))
+	for i := 0; i < b.N; i++ {
+		if err := w.WritePacket(p); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkReadPacket(b *testing.B) {
+	benchmarkReadPacket(b)
+}
+
+func BenchmarkReadPacketWithoutLocking(b *testing.B) {
+	benchmarkReadPacket(b, WithoutLocking())
+}
+
+func benchmarkReadPacket(b *testing.B, opts ...ReaderOption) {
+	packet := Encode(nil, []byte{1, 2, 3, END, 4, 5, ESC, 6})
+	data := bytes.Repeat(packet, 512)
+	br := bytes.NewReader(data)
+	dst := make([]byte, 64)
+	r := NewReader(br, opts...)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(packet)))
+	for i := 0; i < b.N; i++ {
+		if _, err := r.ReadPacketInto(dst); err == io.EOF {
+			br.Reset(data)
+		} else if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkNextFrame(b *testing.B) {
+	packet := Encode(nil, []byte{1, 2, 3, END, 4, 5, ESC, 6})
+	data := bytes.Repeat(packet, 512)
+	br := bytes.NewReader(data)
+	r := NewReader(br)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(packet)))
+	for i := 0; i < b.N; i++ {
+		if _, err := r.NextFrame(); err == io.EOF {
+			br.Reset(data)
+		} else if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkReadPacketLarge(b *testing.B) {
+	benchmarkReadPacketLarge(b)
+}
+
+func BenchmarkReadPacketLargeInitialCapacity(b *testing.B) {
+	benchmarkReadPacketLarge(b, WithInitialReadCapacity(1<<20))
+}
+
+func benchmarkReadPacketLarge(b *testing.B, opts ...ReaderOption) {
+	packet := Encode(nil, bytes.Repeat([]byte{1, 2, 3, END, 4, 5, ESC, 6}, 1<<17))
+	br := bytes.NewReader(packet)
+	r := NewReader(br, opts...)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(packet)))
+	for i := 0; i < b.N; i++ {
+		br.Reset(packet)
+		if _, _, err := r.ReadPacket(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkReadPacketClean(b *testing.B) {
+	packet := Encode(nil, bytes.Repeat([]byte{1, 2, 3, 4, 5, 6, 7, 8}, 512))
+	br := bytes.NewReader(packet)
+	dst := make([]byte, 8*512)
+	r := NewReader(br)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(packet)))
+	for i := 0; i < b.N; i++ {
+		br.Reset(packet)
+		if _, err := r.ReadPacketInto(dst); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkReadPacketCleanBytewise decodes the packet of
+// BenchmarkReadPacketClean byte by byte with a Decoder, the reference
+// for the bulk copy of plain bytes in a Reader.
+func BenchmarkReadPacketCleanBytewise(b *testing.B) {
+	packet := Encode(nil, bytes.Repeat([]byte{1, 2, 3, 4, 5, 6, 7, 8}, 512))
+	d := NewDecoder()
+	b.ReportAllocs()
+	b.SetBytes(int64(len(packet)))
+	for i := 0; i < b.N; i++ {
+		for _, c := range packet {
+			if _, _, err := d.Feed(c); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestReadPacketBulk(t *testing.T) {
+	// The bulk copy of plain bytes must decode like a Decoder, which
+	// works byte by byte
+	data := bytes.Repeat([]byte{END, 1, 2, 3, 4, 5, ESC, ESC_END, 6, 7, END, 8, 9, END, END, ESC, 1, 2, END}, 3)
+	for _, limit := range []int{0, 3, 5, 8} {
+		var expected []string
+		d := NewDecoder(WithMaxPacketSize(limit))
+		for _, c := range data {
+			if p, complete, err := d.Feed(c); err != nil {
+				expected = append(expected, err.Error())
+			} else if complete {
+				expected = append(expected, fmt.Sprint(p))
+			}
+		}
+		for _, size := range []int{1, 2, 3, 7, 64} {
+			r := NewReader(&chunkReader{split(data, size)}, WithReadBufferSize(size))
+			r.MaxPacketSize = limit
+			var got []string
+			for {
+				p, _, err := r.ReadPacket()
+				if err == errLimit {
+					break
+				}
+				if err != nil {
+					got = append(got, err.Error())
+				} else {
+					got = append(got, fmt.Sprint(p))
+				}
+			}
+			if fmt.Sprint(got) != fmt.Sprint(expected) {
+				t.Error(size, limit, "Expected", expected, "but got", got)
+			}
+		}
+	}
+}
+
+func split(p []byte, n int) [][]byte {
+	var chunks [][]byte
+	for len(p) > n {
+		chunks = append(chunks, p[:n])
+		p = p[n:]
+	}
+	return append(chunks, p, nil)
+}
+
+func TestWithoutLocking(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf, WithoutLocking())
+	w.WritePacket([]byte{1, END})
+	r := NewReader(buf, WithoutLocking())
+	p, _, err := r.ReadPacket()
+	if err != nil || !eqBytes(p, []byte{1, END}) {
+		t.Error("Expected data", []byte{1, END}, "but got", p, err)
+	}
+	r.Close()
+	if _, _, err = r.ReadPacket(); err != ErrClosed {
+		t.Error("Expected error", ErrClosed, "but got", err)
+	}
+}
+
+func TestReadUntilEOF(t *testing.T) {
+	data := []byte{END, 1, END, END, 2, 3, END, END}
+	r := NewReader(bytes.NewReader(data))
+
+	n := 0
+	for {
+		p, isPrefix, err := r.ReadPacket()
+		if err == io.EOF {
+			if p != nil || isPrefix {
+				t.Error("Expected nil, false at EOF but got", p, isPrefix)
+			}
+			break
+		}
+		if err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		n++
+	}
+	if n != 2 {
+		t.Error("Expected 2 packets but got", n)
+	}
+}
+
+// closeRecorder is a stream that records calls to Close
+type closeRecorder struct {
+	bytes.Buffer
+	closed int
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed++
+	return nil
+}
+
+func TestReaderClose(t *testing.T) {
+	c := &closeRecorder{}
+	c.Write([]byte{1, END})
+	r := NewReader(c)
+
+	if err := r.Close(); err != nil {
+		t.Error("Unexpected error:", err)
+	}
+	if c.closed != 1 {
+		t.Error("Expected underlying reader to be closed once but got", c.closed)
+	}
+	if _, _, err := r.ReadPacket(); err != ErrClosed {
+		t.Error("Expected error", ErrClosed, "but got", err)
+	}
+	if err := r.Close(); err != ErrClosed {
+		t.Error("Expected error", ErrClosed, "but got", err)
+	}
+	if c.closed != 1 {
+		t.Error("Expected underlying reader to be closed once but got", c.closed)
+	}
+}
+
+func TestWriterClose(t *testing.T) {
+	c := &closeRecorder{}
+	w := NewWriter(c)
+
+	if err := w.Close(); err != nil {
+		t.Error("Unexpected error:", err)
+	}
+	if c.closed != 1 {
+		t.Error("Expected underlying writer to be closed once but got", c.closed)
+	}
+	if err := w.WritePacket([]byte{1}); err != ErrClosed {
+		t.Error("Expected error", ErrClosed, "but got", err)
+	}
+	if c.Len() != 0 {
+		t.Error("Expected no data after close but got", c.Bytes())
+	}
+}
+
+func TestCloseWithoutCloser(t *testing.T) {
+	r := NewReader(&bytes.Buffer{})
+	if err := r.Close(); err != nil {
+		t.Error("Unexpected error:", err)
+	}
+	w := NewWriter(&bytes.Buffer{})
+	if err := w.Close(); err != nil {
+		t.Error("Unexpected error:", err)
+	}
+}
+
+// limitedWriter accepts n bytes and fails afterwards
+type limitedWriter struct {
+	bytes.Buffer
+	n int
+}
+
+var errLimit = errors.New("limit reached")
+
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	if len(p) > l.n {
+		n, _ := l.Buffer.Write(p[:l.n])
+		l.n = 0
+		return n, errLimit
+	}
+	l.n -= len(p)
+	return l.Buffer.Write(p)
+}
+
+func TestWritePackets(t *testing.T) {
+	cw := &countingWriter{w: &bytes.Buffer{}}
+	w := NewWriter(cw)
+	ps := [][]byte{{1, 2}, {END}, {3}}
+	if err := w.WritePackets(ps); err != nil {
+		t.Error("Unexpected error:", err)
+	}
+	if cw.calls != 1 {
+		t.Error("Expected 1 call to Write but got", cw.calls)
+	}
+
+	expected := []byte{END, 1, 2, END, END, ESC, ESC_END, END, END, 3, END}
+	got := cw.w.(*bytes.Buffer).Bytes()
+	if !eqBytes(got, expected) {
+		t.Error("Expected data", expected, "but got", got)
+	}
+}
+
+func TestWritePacketsPartial(t *testing.T) {
+	// The first packet takes 4 bytes, the second 5
+	lw := &limitedWriter{n: 6}
+	w := NewWriter(lw)
+	err := w.WritePackets([][]byte{{1, 2}, {END}, {3}})
+
+	be, ok := err.(*BatchWriteError)
+	if !ok {
+		t.Fatal("Expected BatchWriteError but got", err)
+	}
+	if be.Packets != 1 {
+		t.Error("Expected 1 written packet but got", be.Packets)
+	}
+	if !errors.Is(err, errLimit) {
+		t.Error("Expected error to wrap", errLimit)
+	}
+}
+
+type marshaler struct {
+	p   []byte
+	err error
+}
+
+func (m marshaler) MarshalBinary() ([]byte, error) {
+	return m.p, m.err
+}
+
+func TestWriteMarshaler(t *testing.T) {
+	c := &countingWriter{w: &bytes.Buffer{}}
+	w := NewWriter(c)
+	if err := w.WriteMarshaler(marshaler{p: []byte{1, END}}); err != nil {
+		t.Error("Unexpected error", err)
+	}
+	expected := []byte{END, 1, ESC, ESC_END, END}
+	if b := c.w.(*bytes.Buffer).Bytes(); !eqBytes(expected, b) {
+		t.Error("Expected data", expected, "but got", b)
+	}
+
+	if err := w.WriteMarshaler(marshaler{err: errLimit}); err != errLimit {
+		t.Error("Expected error", errLimit, "but got", err)
+	}
+	if c.calls != 1 {
+		t.Error("Expected 1 write but got", c.calls)
+	}
+}
+
+type unmarshaler struct {
+	p   []byte
+	err error
+}
+
+func (u *unmarshaler) UnmarshalBinary(p []byte) error {
+	u.p = append(u.p[:0], p...)
+	return u.err
+}
+
+func TestReadAll(t *testing.T) {
+	data := []byte{END, 1, END, END, 2, ESC, ESC_END, END, 3}
+	for _, reuse := range []bool{false, true} {
+		r := NewReader(bytes.NewReader(data), WithCopyOnRead(!reuse))
+		packets, err := r.ReadAll()
+		if err != io.ErrUnexpectedEOF || len(packets) != 2 {
+			t.Fatal("Expected 2 packets and", io.ErrUnexpectedEOF, "but got", packets, err)
+		}
+		if !eqBytes([]byte{1}, packets[0]) || !eqBytes([]byte{2, END}, packets[1]) {
+			t.Error("Expected packets [1] [2 END] but got", packets)
+		}
+	}
+
+	r := NewReader(bytes.NewReader(data[:8]))
+	if packets, err := r.ReadAll(); err != nil || len(packets) != 2 {
+		t.Error("Expected 2 packets but got", packets, err)
+	}
+	if packets, err := r.ReadAll(); err != nil || packets != nil {
+		t.Error("Expected no packets but got", packets, err)
+	}
+
+	r = NewReader(bytes.NewReader(data), WithMaxPacketSize(1))
+	if packets, err := r.ReadAll(); err != ErrPacketTooLarge || len(packets) != 1 {
+		t.Error("Expected 1 packet and", ErrPacketTooLarge, "but got", packets, err)
+	}
+}
+
+func TestReadAvailable(t *testing.T) {
+	cr := &countingReader{r: &chunkReader{[][]byte{
+		{END, 1, END, END, 2, END, END, 3, ESC},
+		{ESC_END, END, 4, END},
+		{5, END},
+	}}}
+	r := NewReader(cr, WithCopyOnRead(false))
+	packets, err := r.ReadAvailable(0)
+	if err != nil || len(packets) != 2 || !eqBytes([]byte{1}, packets[0]) || !eqBytes([]byte{2}, packets[1]) {
+		t.Error("Expected packets [1] [2] but got", packets, err)
+	}
+	if cr.calls != 1 {
+		t.Error("Expected 1 read but got", cr.calls)
+	}
+
+	// The buffered part of the third packet is kept
+	packets, err = r.ReadAvailable(1)
+	if err != nil || len(packets) != 1 || !eqBytes([]byte{3, END}, packets[0]) {
+		t.Error("Expected packet [3 END] but got", packets, err)
+	}
+	packets, err = r.ReadAvailable(5)
+	if err != nil || len(packets) != 1 || !eqBytes([]byte{4}, packets[0]) {
+		t.Error("Expected packet [4] but got", packets, err)
+	}
+	if cr.calls != 2 {
+		t.Error("Expected 2 reads but got", cr.calls)
+	}
+
+	packets, err = r.ReadAvailable(0)
+	if err != nil || len(packets) != 1 || !eqBytes([]byte{5}, packets[0]) {
+		t.Error("Expected packet [5] but got", packets, err)
+	}
+	if packets, err = r.ReadAvailable(0); err != io.EOF || packets != nil {
+		t.Error("Expected EOF but got", packets, err)
+	}
+}
+
+func TestReadInto(t *testing.T) {
+	r := NewReader(bytes.NewReader([]byte{END, 1, ESC, ESC_END, END, 2, END, 3}))
+	var u unmarshaler
+	if err := r.ReadInto(&u); err != nil || !eqBytes([]byte{1, END}, u.p) {
+		t.Error("Expected data", []byte{1, END}, "but got", u.p, err)
+	}
+
+	u.err = errLimit
+	err := r.ReadInto(&u)
+	var uerr *UnmarshalError
+	if !errors.As(err, &uerr) || !errors.Is(err, errLimit) || !eqBytes([]byte{2}, uerr.Packet) {
+		t.Error("Expected UnmarshalError wrapping", errLimit, "but got", err)
+	}
+
+	// Read errors are not passed to u
+	u.p = nil
+	if err = r.ReadInto(&u); err != io.ErrUnexpectedEOF || u.p != nil {
+		t.Error("Expected error", io.ErrUnexpectedEOF, "but got", err, u.p)
+	}
+}
+
+func TestWritePacketv(t *testing.T) {
+	splits := [][][]byte{
+		{{1, END}, {ESC, 2}},
+		{{}, {1}, nil, {END, ESC, 2}},
+		{{1, END, ESC, 2}},
+	}
+	expected := Encode(nil, []byte{1, END, ESC, 2})
+	opts := []WriterOption{WithStreamingWrites(0), WithStreamingWrites(2), WithWriteBuffering(64)}
+	for i, ps := range splits {
+		for _, opt := range opts {
+			buf := &bytes.Buffer{}
+			w := NewWriter(buf, opt)
+			if err := w.WritePacketv(ps...); err != nil {
+				t.Error(strconv.Itoa(i), "Unexpected error:", err)
+			}
+			w.Flush()
+			if !eqBytes(buf.Bytes(), expected) {
+				t.Error(strconv.Itoa(i), "Expected data", expected, "but got", buf.Bytes())
+			}
+		}
+	}
+
+	// The checksum covers all slices
+	buf := &bytes.Buffer{}
+	NewWriter(buf, WithCRC16(CRC16CCITT)).WritePacketv([]byte("1234"), []byte("56789"))
+	p, _, err := NewReader(buf).ReadPacket()
+	if expected = append([]byte("123456789"), 0x29, 0xb1); err != nil || !eqBytes(expected, p) {
+		t.Error("Expected data", expected, "but got", p, err)
+	}
+}
+
+func TestWriteEnd(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	w.WritePacket([]byte{1})
+	if err := w.WriteEnd(); err != nil {
+		t.Error("Unexpected error", err)
+	}
+	expected := []byte{END, 1, END, END}
+	if !eqBytes(expected, buf.Bytes()) {
+		t.Error("Expected data", expected, "but got", buf.Bytes())
+	}
+	if s := w.Stats(); s.Packets != 1 || s.Bytes != 4 {
+		t.Error("Expected 1 packet and 4 bytes but got", s)
+	}
+
+	// Buffered packets go out with the END
+	buf.Reset()
+	w = NewWriter(buf, WithWriteBuffering(64))
+	w.WritePacket([]byte{1})
+	w.WriteEnd()
+	if !eqBytes(expected, buf.Bytes()) {
+		t.Error("Expected data", expected, "but got", buf.Bytes())
+	}
+
+	w.Close()
+	if err := w.WriteEnd(); err != ErrClosed {
+		t.Error("Expected error", ErrClosed, "but got", err)
+	}
+}
+
+func TestWriteString(t *testing.T) {
+	for i, d := range writeData {
+		for _, opt := range []WriterOption{WithStreamingWrites(0), WithStreamingWrites(2)} {
+			buf := &bytes.Buffer{}
+			w := NewWriter(buf, opt)
+			if err := w.WriteString(string(d.data)); err != nil {
+				t.Error(strconv.Itoa(i), "Unexpected error:", err)
+			}
+			if !eqBytes(buf.Bytes(), d.expected) {
+				t.Error(strconv.Itoa(i), "Expected data", d.expected, "but got", buf.Bytes())
+			}
+		}
+	}
+}
+
+func TestWriteStringAllocs(t *testing.T) {
+	w := NewWriter(io.Discard)
+	cmd := "AT+RESET\r\n"
+	allocs := testing.AllocsPerRun(100, func() {
+		w.WriteString(cmd)
+	})
+	if allocs != 0 {
+		t.Error("Expected no allocations but got", allocs)
+	}
+}
+
+func TestReadPacketIntoAllocs(t *testing.T) {
+	data := bytes.Repeat(Encode(nil, []byte{1, 2, END, 3, ESC, 4}), 4)
+	br := bytes.NewReader(data)
+	r := NewReader(br)
+	dst := make([]byte, 16)
+	allocs := testing.AllocsPerRun(100, func() {
+		if _, err := r.ReadPacketInto(dst); err == io.EOF {
+			br.Reset(data)
+		}
+	})
+	if allocs != 0 {
+		t.Error("Expected no allocations but got", allocs)
+	}
+}
+
+func TestReaderReset(t *testing.T) {
+	r := NewReader(bytes.NewReader([]byte{1, 2, END, 3, END, 4, ESC}))
+	r.ReadPacket()
+	r.ReadPacket()
+	if _, _, err := r.ReadPacket(); err != ErrIncompleteEscape {
+		t.Error("Expected error", ErrIncompleteEscape, "but got", err)
+	}
+	r.Close()
+
+	// Neither the unfinished packet nor the closed state survive Reset
+	r.Reset(bytes.NewReader([]byte{ESC_END, 5, END}))
+	p, _, err := r.ReadPacket()
+	if err != nil {
+		t.Error("Unexpected error:", err)
+	}
+	if !eqBytes(p, []byte{ESC_END, 5}) {
+		t.Error("Expected data", []byte{ESC_END, 5}, "but got", p)
+	}
+}
+
+func TestWriterReset(t *testing.T) {
+	for _, opt := range []WriterOption{WithStreamingWrites(0), WithStreamingWrites(2)} {
+		w := NewWriter(&bytes.Buffer{}, opt)
+		w.WritePacket([]byte{1})
+		w.Close()
+
+		buf := &bytes.Buffer{}
+		w.Reset(buf)
+		if err := w.WritePacket([]byte{2}); err != nil {
+			t.Error("Unexpected error:", err)
+		}
+		if !eqBytes(buf.Bytes(), []byte{END, 2, END}) {
+			t.Error("Expected data", []byte{END, 2, END}, "but got", buf.Bytes())
+		}
+	}
+}
+
+// chunkReader returns one chunk per Read and errLimit for a nil chunk.
+type chunkReader struct {
+	chunks [][]byte
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	if len(c.chunks) == 0 {
+		return 0, io.EOF
+	}
+	chunk := c.chunks[0]
+	c.chunks = c.chunks[1:]
+	if chunk == nil {
+		return 0, errLimit
+	}
+	return copy(p, chunk), nil
+}
+
+func TestResync(t *testing.T) {
+	r := NewReader(&chunkReader{[][]byte{
+		{END, 1, 2}, nil, {ESC, ESC_END, 3, END, 4, END}, {5, END},
+	}})
+	if p, isPrefix, err := r.ReadPacket(); !isPrefix || err != errLimit {
+		t.Fatal("Expected a prefix but got", p, isPrefix, err)
+	}
+	if err := r.Resync(); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	p, _, err := r.ReadPacket()
+	if err != nil || !eqBytes([]byte{4}, p) {
+		t.Error("Expected data", []byte{4}, "but got", p, err)
+	}
+
+	// Between packets Resync keeps the next packet
+	if err = r.Resync(); err != nil {
+		t.Error("Unexpected error", err)
+	}
+	p, _, err = r.ReadPacket()
+	if err != nil || !eqBytes([]byte{5}, p) {
+		t.Error("Expected data", []byte{5}, "but got", p, err)
+	}
+}
+
+func TestDiscard(t *testing.T) {
+	data := []byte{END, 1, 2, ESC, ESC_END, 3, END, 4, END, 5, 6, END, END, 7, END, 8}
+	r := NewReader(iotest.OneByteReader(bytes.NewReader(data)))
+	if p, err := r.Peek(1); err != nil || !eqBytes([]byte{1}, p) {
+		t.Fatal("Expected data", []byte{1}, "but got", p, err)
+	}
+	// The escaped END is skipped as part of the frame
+	if err := r.Discard(); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	p, _, err := r.ReadPacket()
+	if err != nil || !eqBytes([]byte{4}, p) {
+		t.Error("Expected data", []byte{4}, "but got", p, err)
+	}
+
+	// Between packets the next frame is dropped
+	if err = r.Discard(); err != nil {
+		t.Error("Unexpected error", err)
+	}
+	p, _, err = r.ReadPacket()
+	if err != nil || !eqBytes([]byte{7}, p) {
+		t.Error("Expected data", []byte{7}, "but got", p, err)
+	}
+	if err = r.Discard(); err != io.EOF {
+		t.Error("Expected error", io.EOF, "but got", err)
+	}
+	if s := r.Stats(); s.Discarded != 5+3+1 {
+		t.Error("Expected 9 discarded bytes but got", s.Discarded)
+	}
+}
+
+var peekData = []struct {
+	data     []byte
+	n        int
+	expected []byte
+	err      error
+}{
+	{[]byte{END, 1, 2, 3, END}, 2, []byte{1, 2}, nil},
+	{[]byte{END, 1, 2, 3, END}, 3, []byte{1, 2, 3}, nil},
+	{[]byte{END, ESC, ESC_END, ESC, ESC_ESC, 3, END}, 2, []byte{END, ESC}, nil},
+	// Shorter packets are returned with io.EOF
+	{[]byte{END, 1, 2, 3, END}, 4, []byte{1, 2, 3}, io.EOF},
+	{[]byte{END, 1, 2}, 4, []byte{1, 2}, io.ErrUnexpectedEOF},
+	{[]byte{END, END}, 1, nil, io.EOF},
+}
+
+func TestPeek(t *testing.T) {
+	for i, test := range peekData {
+		r := NewReader(iotest.OneByteReader(bytes.NewReader(test.data)))
+		p, err := r.Peek(test.n)
+		if err != test.err {
+			t.Error(strconv.Itoa(i), "Expected error", test.err, "but got", err)
+		}
+		if !eqBytes(test.expected, p) {
+			t.Error(strconv.Itoa(i), "Expected data", test.expected, "but got", p)
+		}
+
+		// Peeking again returns the same bytes
+		if again, _ := r.Peek(test.n); !eqBytes(p, again) {
+			t.Error(strconv.Itoa(i), "Expected data", p, "but got", again)
+		}
+
+		// The packet is still complete
+		expected, _, _ := NewReader(bytes.NewReader(test.data)).ReadPacket()
+		if p, _, _ = r.ReadPacket(); !eqBytes(expected, p) {
+			t.Error(strconv.Itoa(i), "Expected data", expected, "but got", p)
+		}
+	}
+}
+
+func TestPeekReadsOnlyNeededBytes(t *testing.T) {
+	c := &countingReader{r: iotest.OneByteReader(bytes.NewReader([]byte{END, 1, 2, 3, 4, END, 5, END}))}
+	r := NewReader(c)
+	if p, err := r.Peek(1); err != nil || !eqBytes([]byte{1}, p) {
+		t.Fatal("Expected data", []byte{1}, "but got", p, err)
+	}
+	if c.calls != 2 {
+		t.Error("Expected 2 reads but got", c.calls)
+	}
+
+	dst := make([]byte, 2)
+	if n, err := r.ReadPacketInto(dst); err != ErrBufferTooSmall || n != 2 {
+		t.Error("Expected error", ErrBufferTooSmall, "but got", n, err)
+	}
+	if p, err := r.Peek(3); err != io.EOF || !eqBytes([]byte{5}, p) {
+		t.Error("Expected data", []byte{5}, "but got", p, err)
+	}
+}
+
+func TestReadIncompleteEscape(t *testing.T) {
+	for _, strict := range []bool{false, true} {
+		r := NewReader(bytes.NewReader([]byte{END, 1, ESC}), WithStrictDecoding(strict))
+		p, isPrefix, err := r.ReadPacket()
+		if err != ErrIncompleteEscape || !isPrefix || !eqBytes(p, []byte{1}) {
+			t.Error(strict, "Expected error", ErrIncompleteEscape, "but got", p, isPrefix, err)
+		}
+
+		// A packet truncated elsewhere is still unexpected EOF
+		r = NewReader(bytes.NewReader([]byte{END, 1, ESC, ESC_ESC}), WithStrictDecoding(strict))
+		if _, _, err = r.ReadPacket(); err != io.ErrUnexpectedEOF {
+			t.Error(strict, "Expected error", io.ErrUnexpectedEOF, "but got", err)
+		}
+	}
+
+	// Just an ESC
+	r := NewReader(bytes.NewReader([]byte{ESC}))
+	if p, isPrefix, err := r.ReadPacket(); err != ErrIncompleteEscape || !isPrefix || len(p) != 0 {
+		t.Error("Expected error", ErrIncompleteEscape, "but got", p, isPrefix, err)
+	}
+}
@@ -1,17 +1,14 @@
 package slip
 
-import (
-	"bytes"
-	"io"
-)
+import "io"
 
 type SlipMuxReader struct {
 	r *Reader
 }
 
-func NewSlipMuxReader(reader io.Reader) *SlipMuxReader {
+func NewSlipMuxReader(reader io.Reader, opts ...ReaderOption) *SlipMuxReader {
 	return &SlipMuxReader{
-		r: NewReader(reader),
+		r: NewReader(reader, opts...),
 	}
 }
 
@@ -19,9 +16,9 @@ type SlipMuxWriter struct {
 	w *Writer
 }
 
-func NewSlipMuxWriter(writer io.Writer) *SlipMuxWriter {
+func NewSlipMuxWriter(writer io.Writer, opts ...WriterOption) *SlipMuxWriter {
 	return &SlipMuxWriter{
-		w: NewWriter(writer),
+		w: NewWriter(writer, opts...),
 	}
 }
 
@@ -81,25 +78,25 @@ func isInvalidFrame(frameType byte) bool {
 // IPv4 and IPv6 frame identifiers are not stripped to keep
 // backwards compatibility with SLIP
 func (s *SlipMuxReader) ReadPacket() ([]byte, byte, error) {
-	buf := bytes.Buffer{}
+	var res []byte
 
 	for {
-		p, isPrefix, err := s.r.ReadPacket()
-		if err != nil && err != io.EOF {
+		p, _, err := s.r.ReadPacket()
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF && err != ErrIncompleteEscape && err != io.ErrNoProgress {
 			// EOF does not return here and must be handled
 			// via Timeout in the application this is because
 			// some streams might return EOF even if there
-			// will be more data in future
+			// will be more data in future.
+			// The reader keeps unfinished packets, so the
+			// next call resumes them.
 			return nil, 0, err
 		}
-		buf.Write(p)
-		if !isPrefix && len(p) > 0 {
+		if err == nil && len(p) > 0 {
+			res = p
 			break
 		}
 	}
 
-	res := buf.Bytes()
-
 	frameType := res[0]
 
 	// Ignore packets with invalid frame types
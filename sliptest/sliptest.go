@@ -0,0 +1,44 @@
+// Package sliptest provides helpers for testing code that reads SLIP
+// streams, like the edge cases of a read loop: short reads, reads
+// returning no bytes and errors in the middle of a packet.
+package sliptest
+
+import (
+	"io"
+)
+
+// ReadStep is the result of one Read call of a scripted reader. A step
+// with neither Data nor Err returns 0, nil.
+type ReadStep struct {
+	Data []byte
+	Err  error // returned with the last bytes of Data
+}
+
+// scriptedReader plays its steps one Read at a time.
+type scriptedReader struct {
+	steps []ReadStep
+}
+
+// NewScriptedReader returns a reader that returns one step per Read
+// call, in order, and io.EOF once all steps are done. Data that does
+// not fit into the buffer of a call is returned by the next calls
+// before the following step, the error of the step with its last byte.
+// The steps are not modified.
+func NewScriptedReader(steps []ReadStep) io.Reader {
+	return &scriptedReader{steps: append([]ReadStep(nil), steps...)}
+}
+
+func (r *scriptedReader) Read(p []byte) (int, error) {
+	if len(r.steps) == 0 {
+		return 0, io.EOF
+	}
+	step := &r.steps[0]
+	n := copy(p, step.Data)
+	if n < len(step.Data) {
+		step.Data = step.Data[n:]
+		return n, nil
+	}
+	err := step.Err
+	r.steps = r.steps[1:]
+	return n, err
+}
@@ -0,0 +1,56 @@
+package sliptest_test
+
+import (
+	"errors"
+	"io"
+	"strconv"
+	"testing"
+
+	"github.com/meandrewdev/slip"
+	"github.com/meandrewdev/slip/sliptest"
+)
+
+var errBroken = errors.New("broken")
+
+func TestScriptedReader(t *testing.T) {
+	steps := []sliptest.ReadStep{
+		{Data: []byte{1, 2, 3}},
+		{},
+		{Data: []byte{4}, Err: errBroken},
+	}
+	r := sliptest.NewScriptedReader(steps)
+	buf := make([]byte, 2)
+	for i, expected := range []struct {
+		data []byte
+		err  error
+	}{
+		{[]byte{1, 2}, nil},
+		{[]byte{3}, nil},
+		{[]byte{}, nil},
+		{[]byte{4}, errBroken},
+		{[]byte{}, io.EOF},
+	} {
+		n, err := r.Read(buf)
+		if string(buf[:n]) != string(expected.data) || err != expected.err {
+			t.Error(strconv.Itoa(i), "Expected data", expected.data, expected.err, "but got", buf[:n], err)
+		}
+	}
+	if len(steps[0].Data) != 3 {
+		t.Error("Expected steps to be unchanged but got", steps)
+	}
+}
+
+func TestScriptedReaderPartialPacket(t *testing.T) {
+	r := slip.NewReader(sliptest.NewScriptedReader([]sliptest.ReadStep{
+		{Data: []byte{slip.END, 1, slip.ESC}, Err: errBroken},
+		{},
+		{Data: []byte{slip.ESC_END, 2, slip.END}},
+	}))
+	if p, isPrefix, err := r.ReadPacket(); !isPrefix || err != errBroken {
+		t.Fatal("Expected partial packet with error", errBroken, "but got", p, isPrefix, err)
+	}
+	p, isPrefix, err := r.ReadPacket()
+	if err != nil || isPrefix || string(p) != string([]byte{1, slip.END, 2}) {
+		t.Error("Expected data", []byte{1, slip.END, 2}, "but got", p, isPrefix, err)
+	}
+}
@@ -0,0 +1,103 @@
+package slip
+
+import (
+	"io"
+	"sync/atomic"
+)
+
+// ReaderStats is a snapshot of the counters of a Reader.
+type ReaderStats struct {
+	Packets       uint64 // packets returned
+	Bytes         uint64 // decoded bytes of the returned packets
+	EmptyFrames   uint64 // END bytes that ended no packet, like the leading END of each packet
+	Errors        uint64 // packets dropped because of a decode error
+	Discarded     uint64 // raw bytes dropped by Discard
+	FramesDropped uint64 // packets with a protocol error skipped by WithAutoResync
+}
+
+// WriterStats is a snapshot of the counters of a Writer.
+type WriterStats struct {
+	Packets uint64 // packets written completely
+	Bytes   uint64 // encoded bytes passed to the underlying writer
+}
+
+// ConnStats is a snapshot of the counters of both directions of a
+// Conn.
+type ConnStats struct {
+	Read  ReaderStats
+	Write WriterStats
+}
+
+// readerStats holds the counters of a Reader. They are updated
+// atomically, so Stats is safe while another goroutine reads.
+type readerStats struct {
+	packets     uint64
+	bytes       uint64
+	emptyFrames uint64
+	errors      uint64
+	discarded   uint64
+	dropped     uint64
+}
+
+type writerStats struct {
+	packets uint64
+	bytes   uint64
+}
+
+// Stats returns a snapshot of the counters of s. It may be called
+// concurrently with ReadPacket.
+func (s *Reader) Stats() ReaderStats {
+	return ReaderStats{
+		Packets:       atomic.LoadUint64(&s.stats.packets),
+		Bytes:         atomic.LoadUint64(&s.stats.bytes),
+		EmptyFrames:   atomic.LoadUint64(&s.stats.emptyFrames),
+		Errors:        atomic.LoadUint64(&s.stats.errors),
+		Discarded:     atomic.LoadUint64(&s.stats.discarded),
+		FramesDropped: atomic.LoadUint64(&s.stats.dropped),
+	}
+}
+
+// Stats returns a snapshot of the counters of s. It may be called
+// concurrently with WritePacket.
+func (s *Writer) Stats() WriterStats {
+	return WriterStats{
+		Packets: atomic.LoadUint64(&s.stats.packets),
+		Bytes:   atomic.LoadUint64(&s.stats.bytes),
+	}
+}
+
+// Stats returns a snapshot of the counters of both directions of c.
+// It may be called concurrently with ReadPacket and WritePacket.
+func (c *Conn) Stats() ConnStats {
+	return ConnStats{
+		Read:  c.Reader.Stats(),
+		Write: c.Writer.Stats(),
+	}
+}
+
+// countWriter passes writes to the underlying writer of a Writer and
+// counts the bytes written. A short write is continued with the rest
+// of p, so a frame is not cut short by a writer that takes it in
+// pieces; only a Write that makes no progress ends it with
+// io.ErrShortWrite.
+type countWriter struct {
+	s *Writer
+}
+
+func (c countWriter) Write(p []byte) (n int, err error) {
+	for n < len(p) {
+		var m int
+		m, err = c.s.w.Write(p[n:])
+		n += m
+		atomic.AddUint64(&c.s.stats.bytes, uint64(m))
+		switch {
+		case m > 0 && (err == nil || err == io.ErrShortWrite):
+			err = nil
+		case err != nil:
+			return n, err
+		default:
+			return n, io.ErrShortWrite
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,75 @@
+package slip
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+)
+
+func TestReaderStats(t *testing.T) {
+	data := []byte{END, 1, 2, END, END, END, 3, 4, 5, END, 6, END, 1, ESC, 2, END}
+	r := NewReader(bytes.NewReader(data), WithMaxPacketSize(2), WithStrictDecoding(true))
+	for i := 0; i < 5; i++ {
+		r.ReadPacket()
+	}
+
+	expected := ReaderStats{Packets: 2, Bytes: 3, EmptyFrames: 3, Errors: 2}
+	if s := r.Stats(); s != expected {
+		t.Error("Expected stats", expected, "but got", s)
+	}
+}
+
+func TestWriterStats(t *testing.T) {
+	for _, opt := range []WriterOption{WithStreamingWrites(0), WithStreamingWrites(2)} {
+		w := NewWriter(&bytes.Buffer{}, opt)
+		w.WritePacket([]byte{1, END})
+		w.WriteString("ab")
+		w.WritePackets([][]byte{{ESC}, {3}})
+
+		expected := WriterStats{Packets: 4, Bytes: 5 + 4 + 4 + 3}
+		if s := w.Stats(); s != expected {
+			t.Error("Expected stats", expected, "but got", s)
+		}
+	}
+}
+
+func TestConnStats(t *testing.T) {
+	rw := &closeRecorder{}
+	c := NewConn(rw)
+	c.WritePacket([]byte{1, END})
+	c.ReadPacket()
+
+	expected := ConnStats{
+		Read:  ReaderStats{Packets: 1, Bytes: 2, EmptyFrames: 1},
+		Write: WriterStats{Packets: 1, Bytes: 5},
+	}
+	if s := c.Stats(); s != expected {
+		t.Error("Expected stats", expected, "but got", s)
+	}
+}
+
+func TestStatsConcurrent(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	for i := 0; i < 100; i++ {
+		w.WritePacket([]byte{1})
+	}
+	r := NewReader(buf)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			r.ReadPacket()
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		r.Stats()
+	}
+	wg.Wait()
+
+	if s := r.Stats(); s.Packets != 100 {
+		t.Error("Expected 100 packets but got", s.Packets)
+	}
+}
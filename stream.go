@@ -0,0 +1,224 @@
+package slip
+
+import (
+	"bytes"
+	"io"
+)
+
+// decodedStream reads the decoded bytes of a Reader without packet boundaries
+type decodedStream struct {
+	s   *Reader
+	esc bool // an ESC was read but not the byte following it
+	off int  // offset within the current packet
+}
+
+// DecodedStream returns an io.Reader over the decoded payload of all
+// packets read from s. END delimiters are dropped, so packet boundaries
+// are lost. An ESC followed by anything but ESC_END or ESC_ESC returns
+// an InvalidEscapeError and an ESC directly before the end of the stream
+// returns ErrIncompleteEscape. Empty reads of s are retried like in
+// ReadPacket before io.ErrNoProgress is returned.
+//
+// The stream shares the buffer of s and can be mixed with calls to
+// ReadPacket, which continue after the last byte returned by the stream.
+func (s *Reader) DecodedStream() io.Reader {
+	return &decodedStream{s: s}
+}
+
+func (d *decodedStream) Read(p []byte) (n int, err error) {
+	s := d.s
+	s.lock()
+	defer s.unlock()
+	if s.closed {
+		return 0, ErrClosed
+	}
+
+	if s.skip {
+		if _, err = s.skipPacket(); err != nil {
+			return 0, err
+		}
+	}
+
+	// Hand out what is left of a packet ReadPacket did not finish
+	if len(s.partial) > 0 {
+		n = copy(p, s.partial)
+		s.partial = s.partial[:copy(s.partial, s.partial[n:])]
+		d.off += n
+	}
+	if len(s.partial) == 0 && s.complete {
+		s.complete = false
+		s.redeliver = false
+		d.off = 0
+	}
+	if s.escPending {
+		s.escPending = false
+		d.esc = true
+	}
+
+	for n < len(p) {
+		// Never block on the underlying reader once we have data
+		if n > 0 && s.rd == s.wr {
+			return n, nil
+		}
+
+		c, err := s.readByte()
+		if err != nil {
+			if err == errZeroRead {
+				err = io.ErrNoProgress
+			} else if d.esc && err == io.EOF {
+				err = ErrIncompleteEscape
+			}
+			return n, err
+		}
+
+		if d.esc {
+			d.esc = false
+			b, ok := s.unescape(c)
+			if !ok {
+				err = &InvalidEscapeError{Byte: c, Offset: d.off}
+				if c == s.end {
+					d.off = 0
+				}
+				return n, err
+			}
+			c = b
+		} else {
+			switch c {
+			case s.end:
+				d.off = 0
+				continue
+			case s.esc:
+				d.esc = true
+				continue
+			}
+		}
+
+		p[n] = c
+		n++
+		d.off++
+	}
+	return n, nil
+}
+
+// WriteTo implements io.WriterTo. It writes the payload of every packet
+// read from s to w until the end of the stream, with one Write per
+// packet. The separator set with WithPacketSeparator follows every
+// payload; without one the packets are flattened like DecodedStream
+// and the boundaries are lost. The packets are read like ReadPacket,
+// so WithCoalesceWindow joins them. The packet buffer is reused, so
+// WriteTo does not allocate per packet.
+// At the end of the stream WriteTo returns nil. It returns the first
+// error of ReadPacket or w otherwise, an unfinished packet stays in s
+// for the next read. s is locked until WriteTo returns.
+func (s *Reader) WriteTo(w io.Writer) (n int64, err error) {
+	s.lock()
+	defer s.unlock()
+	if s.closed {
+		return 0, ErrClosed
+	}
+
+	var buf []byte
+	for {
+		var p []byte
+		p, _, err = s.read(buf[:0], s.maxPacketSize())
+		if err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return n, err
+		}
+		if len(p) == 0 && len(s.sep) == 0 {
+			continue
+		}
+
+		p = append(p, s.sep...)
+		buf = p
+		var m int
+		m, err = w.Write(p)
+		n += int64(m)
+		if err != nil {
+			return n, err
+		}
+		if m != len(p) {
+			return n, io.ErrShortWrite
+		}
+	}
+}
+
+// Write implements io.Writer. It writes p as one packet like
+// WritePacket and returns len(p) on success, so a Writer can be used
+// where an io.Writer is expected.
+func (s *Writer) Write(p []byte) (int, error) {
+	if err := s.WritePacket(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// ReadFrom implements io.ReaderFrom. It reads r until io.EOF and writes
+// every chunk returned by a Read on r as one packet, so io.Copy frames
+// a stream. With WithFrameDelimiter the data is split at the delimiter
+// instead, a final chunk without delimiter becomes a packet at io.EOF.
+// Empty chunks are not written. ReadFrom returns the number of bytes
+// read from r, including delimiters.
+func (s *Writer) ReadFrom(r io.Reader) (n int64, err error) {
+	return s.readFrom(r, s.split, s.delim)
+}
+
+// FrameLines writes every delim-terminated chunk read from src to dst
+// as one packet, without the delimiter, until src returns io.EOF. A
+// final chunk without delimiter is written as a packet too, empty
+// chunks are not written. Chunks may span any number of Reads on src.
+// It is Writer.ReadFrom with WithFrameDelimiter for a Writer created
+// without it. At io.EOF FrameLines returns nil.
+func FrameLines(dst *Writer, src io.Reader, delim byte) error {
+	_, err := dst.readFrom(src, true, delim)
+	return err
+}
+
+// readFrom is ReadFrom splitting at delim if split is set.
+func (s *Writer) readFrom(r io.Reader, split bool, delim byte) (n int64, err error) {
+	buf := make([]byte, 32<<10)
+	var line []byte // data after the last delimiter
+	for {
+		m, rerr := r.Read(buf)
+		n += int64(m)
+		chunk := buf[:m]
+
+		if split {
+			for len(chunk) > 0 {
+				i := bytes.IndexByte(chunk, delim)
+				if i < 0 {
+					line = append(line, chunk...)
+					break
+				}
+				p := chunk[:i]
+				if len(line) > 0 {
+					line = append(line, p...)
+					p = line
+				}
+				if err = s.writeChunk(p); err != nil {
+					return n, err
+				}
+				line = line[:0]
+				chunk = chunk[i+1:]
+			}
+		} else if err = s.writeChunk(chunk); err != nil {
+			return n, err
+		}
+
+		if rerr == io.EOF {
+			return n, s.writeChunk(line)
+		}
+		if rerr != nil {
+			return n, rerr
+		}
+	}
+}
+
+func (s *Writer) writeChunk(p []byte) error {
+	if len(p) == 0 {
+		return nil
+	}
+	return s.WritePacket(p)
+}
@@ -0,0 +1,215 @@
+package slip
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"testing"
+	"testing/iotest"
+)
+
+var decodedStreamData = []struct {
+	data     []byte
+	expected []byte
+	err      error
+}{
+	{[]byte{END, 1, 2, END, END, 3, END}, []byte{1, 2, 3}, nil},
+	{[]byte{1, ESC, ESC_END, 2, ESC, ESC_ESC, END, 3}, []byte{1, END, 2, ESC, 3}, nil},
+	{[]byte{}, []byte{}, nil},
+	// Protocol violations
+	{[]byte{1, ESC, 2, END}, []byte{1}, ErrInvalidEscape},
+	{[]byte{1, 2, ESC}, []byte{1, 2}, ErrIncompleteEscape},
+}
+
+func TestDecodedStream(t *testing.T) {
+	for i, d := range decodedStreamData {
+		// One byte reads split every ESC sequence across calls to Read
+		r := NewReader(iotest.OneByteReader(bytes.NewReader(d.data)))
+		p, err := io.ReadAll(r.DecodedStream())
+
+		if !errors.Is(err, d.err) {
+			t.Error(strconv.Itoa(i), "Expected error", d.err, "but got", err)
+		}
+		if !eqBytes(p, d.expected) {
+			t.Error(strconv.Itoa(i), "Expected data", d.expected, "but got", p)
+		}
+	}
+}
+
+func TestDecodedStreamZeroRead(t *testing.T) {
+	// io.ReadAll must not loop on a reader that never makes progress
+	r := NewReader(zeroReader{})
+	if p, err := io.ReadAll(r.DecodedStream()); err != io.ErrNoProgress || len(p) != 0 {
+		t.Error("Expected error", io.ErrNoProgress, "but got", p, err)
+	}
+}
+
+func TestDecodedStreamAndReadPacket(t *testing.T) {
+	r := NewReader(bytes.NewReader([]byte{END, 1, 2, 3, END, 4, END}))
+
+	p := make([]byte, 2)
+	if _, err := io.ReadFull(r.DecodedStream(), p); err != nil {
+		t.Error("Unexpected error:", err)
+	}
+	if !eqBytes(p, []byte{1, 2}) {
+		t.Error("Expected data", []byte{1, 2}, "but got", p)
+	}
+
+	// ReadPacket continues with the rest of the packet
+	p, _, err := r.ReadPacket()
+	if err != nil {
+		t.Error("Unexpected error:", err)
+	}
+	if !eqBytes(p, []byte{3}) {
+		t.Error("Expected data", []byte{3}, "but got", p)
+	}
+}
+
+func TestDecodedStreamAfterPartialPacket(t *testing.T) {
+	buf := bytes.NewBuffer([]byte{1, 2, ESC})
+	r := NewReader(buf)
+	if _, _, err := r.ReadPacket(); err != ErrIncompleteEscape {
+		t.Error("Expected error", ErrIncompleteEscape, "but got", err)
+	}
+
+	// The stream continues the unfinished packet
+	buf.Write([]byte{ESC_ESC, 3, END})
+	p, err := io.ReadAll(r.DecodedStream())
+	if err != nil {
+		t.Error("Unexpected error:", err)
+	}
+	if !eqBytes(p, []byte{1, 2, ESC, 3}) {
+		t.Error("Expected data", []byte{1, 2, ESC, 3}, "but got", p)
+	}
+}
+
+var writeToData = []struct {
+	data     []byte
+	sep      []byte
+	expected []byte
+	err      error
+}{
+	{[]byte{END, 1, 2, END, END, 3, END}, nil, []byte{1, 2, 3}, nil},
+	{[]byte{END, 1, 2, END, END, 3, END}, []byte{'\n'}, []byte{1, 2, '\n', 3, '\n'}, nil},
+	{[]byte{END, ESC, ESC_END, END}, []byte{0, 0}, []byte{END, 0, 0}, nil},
+	{[]byte{}, []byte{'\n'}, []byte{}, nil},
+	// An unfinished packet is not written
+	{[]byte{END, 1, END, 2}, nil, []byte{1}, io.ErrUnexpectedEOF},
+}
+
+func TestWriteTo(t *testing.T) {
+	for i, d := range writeToData {
+		r := NewReader(iotest.OneByteReader(bytes.NewReader(d.data)), WithPacketSeparator(d.sep))
+		buf := &bytes.Buffer{}
+		n, err := r.WriteTo(buf)
+		if err != d.err {
+			t.Error(strconv.Itoa(i), "Expected error", d.err, "but got", err)
+		}
+		if n != int64(buf.Len()) {
+			t.Error(strconv.Itoa(i), "Expected count", buf.Len(), "but got", n)
+		}
+		if !eqBytes(buf.Bytes(), d.expected) {
+			t.Error(strconv.Itoa(i), "Expected data", d.expected, "but got", buf.Bytes())
+		}
+	}
+}
+
+func TestWriteToWriterError(t *testing.T) {
+	r := NewReader(bytes.NewReader([]byte{END, 1, 2, END, 3, 4, END}))
+	w := &limitedWriter{n: 3}
+	n, err := r.WriteTo(w)
+	if err != errLimit || n != 3 {
+		t.Error("Expected error", errLimit, "after 3 bytes but got", n, err)
+	}
+	var _ io.WriterTo = r
+}
+
+func TestReadFrom(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	src := &chunkReader{[][]byte{{1, 2}, {3}, {}, {END}}}
+	n, err := io.Copy(w, src)
+	if err != nil || n != 4 {
+		t.Error("Expected 4 bytes but got", n, err)
+	}
+	expected := []byte{END, 1, 2, END, END, 3, END, END, ESC, ESC_END, END}
+	if !eqBytes(buf.Bytes(), expected) {
+		t.Error("Expected data", expected, "but got", buf.Bytes())
+	}
+
+	// Errors of the source are returned
+	src = &chunkReader{[][]byte{{1}, nil}}
+	if n, err = w.ReadFrom(src); err != errLimit || n != 1 {
+		t.Error("Expected error", errLimit, "but got", n, err)
+	}
+}
+
+var readFromData = []struct {
+	data     []byte
+	expected [][]byte
+}{
+	{[]byte("a\nbc\n"), [][]byte{[]byte("a"), []byte("bc")}},
+	{[]byte("a\n\nbc"), [][]byte{[]byte("a"), []byte("bc")}},
+	{[]byte{'\n', END, '\n'}, [][]byte{{END}}},
+	{[]byte{}, nil},
+}
+
+func TestReadFromDelimiter(t *testing.T) {
+	for i, d := range readFromData {
+		buf := &bytes.Buffer{}
+		w := NewWriter(buf, WithFrameDelimiter('\n'))
+		n, err := w.ReadFrom(iotest.OneByteReader(bytes.NewReader(d.data)))
+		if err != nil || n != int64(len(d.data)) {
+			t.Error(strconv.Itoa(i), "Expected", len(d.data), "bytes but got", n, err)
+		}
+
+		r := NewReader(buf)
+		for _, e := range d.expected {
+			p, _, err := r.ReadPacket()
+			if err != nil || !eqBytes(p, e) {
+				t.Error(strconv.Itoa(i), "Expected data", e, "but got", p, err)
+			}
+		}
+		if p, _, err := r.ReadPacket(); err != io.EOF {
+			t.Error(strconv.Itoa(i), "Expected EOF but got", p, err)
+		}
+	}
+}
+
+func TestFrameLines(t *testing.T) {
+	for i, d := range readFromData {
+		buf := &bytes.Buffer{}
+		src := &chunkReader{[][]byte{d.data[:len(d.data)/2], d.data[len(d.data)/2:]}}
+		if err := FrameLines(NewWriter(buf), iotest.DataErrReader(src), '\n'); err != nil {
+			t.Error(strconv.Itoa(i), "Unexpected error", err)
+		}
+
+		r := NewReader(buf)
+		for _, e := range d.expected {
+			p, _, err := r.ReadPacket()
+			if err != nil || !eqBytes(p, e) {
+				t.Error(strconv.Itoa(i), "Expected data", e, "but got", p, err)
+			}
+		}
+		if p, _, err := r.ReadPacket(); err != io.EOF {
+			t.Error(strconv.Itoa(i), "Expected EOF but got", p, err)
+		}
+	}
+}
+
+func TestWriterWrite(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	if n, err := fmt.Fprint(w, "hi"); err != nil || n != 2 {
+		t.Error("Expected 2 bytes but got", n, err)
+	}
+	if expected := []byte{END, 'h', 'i', END}; !eqBytes(buf.Bytes(), expected) {
+		t.Error("Expected data", expected, "but got", buf.Bytes())
+	}
+	w.Close()
+	if n, err := w.Write([]byte{1}); err != ErrClosed || n != 0 {
+		t.Error("Expected error", ErrClosed, "but got", n, err)
+	}
+}
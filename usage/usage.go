@@ -18,7 +18,7 @@ func ReadExample() {
 
 	// packet == 1, 2, 3
 	// isPrefix == false
-	// err == io.EOF
+	// err == nil
 
 	if packet[0] != 1 || packet[1] != 2 || packet[2] != 3 {
 		panic("Bad data")
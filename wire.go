@@ -0,0 +1,53 @@
+package slip
+
+// Fletcher16 returns the Fletcher-16 checksum of p, the second sum in
+// the high byte. It can be passed to WithWireChecksum.
+func Fletcher16(p []byte) uint16 {
+	var sum1, sum2 uint16
+	for _, b := range p {
+		sum1 = (sum1 + uint16(b)) % 255
+		sum2 = (sum2 + sum1) % 255
+	}
+	return sum2<<8 | sum1
+}
+
+// appendWireTrailer appends the wire checksum of the stuffed bytes
+// dst[start:], stuffed itself and most significant byte first.
+func (c *codec) appendWireTrailer(dst []byte, start int) []byte {
+	sum := c.wire(dst[start:])
+	dst = c.appendByte(dst, byte(sum>>8))
+	return c.appendByte(dst, byte(sum))
+}
+
+// verifyWire strips and checks the wire checksum trailer of the
+// decoded packet p against the bytes captured for it in s.raw.
+func (s *Reader) verifyWire(p []byte) ([]byte, error) {
+	raw := s.raw
+	for len(raw) > 0 && raw[0] == s.end && !s.noFrame {
+		raw = raw[1:]
+	}
+	if len(raw) > 0 && raw[len(raw)-1] == s.end && !s.noFrame {
+		raw = raw[:len(raw)-1]
+	}
+	if len(p) < 2 {
+		return p[:0], &ChecksumError{Want: s.wire(raw)}
+	}
+
+	/* walk back over the two stuffed trailer bytes, an escape
+	 * code never equals ESC, so the byte before it tells
+	 */
+	j := len(raw)
+	for k := 0; k < 2 && j > 0; k++ {
+		if j >= 2 && raw[j-2] == s.esc {
+			j -= 2
+		} else {
+			j--
+		}
+	}
+	want := s.wire(raw[:j])
+	got := uint16(p[len(p)-2])<<8 | uint16(p[len(p)-1])
+	if got != want {
+		return p[:0], &ChecksumError{Got: got, Want: want}
+	}
+	return p[:len(p)-2], nil
+}
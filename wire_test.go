@@ -0,0 +1,137 @@
+package slip
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"testing"
+)
+
+func TestFletcher16(t *testing.T) {
+	for i, test := range []struct {
+		data     string
+		expected uint16
+	}{
+		{"", 0},
+		{"abcde", 0xc8f0},
+		{"abcdef", 0x2057},
+		{"abcdefgh", 0x0627},
+	} {
+		if sum := Fletcher16([]byte(test.data)); sum != test.expected {
+			t.Errorf("%d Expected checksum %#04x but got %#04x", i, test.expected, sum)
+		}
+	}
+}
+
+func TestWireChecksumOnTheWire(t *testing.T) {
+	buf := &bytes.Buffer{}
+	NewWriter(buf, WithWireChecksum(Fletcher16)).WritePacket([]byte{1, END, 2})
+	stuffed := []byte{1, ESC, ESC_END, 2}
+	sum := Fletcher16(stuffed)
+	expected := append(append([]byte{END}, stuffed...), byte(sum>>8), byte(sum), END)
+	if !eqBytes(expected, buf.Bytes()) {
+		t.Error("Expected data", expected, "but got", buf.Bytes())
+	}
+}
+
+func TestWireChecksumRoundtrip(t *testing.T) {
+	packets := [][]byte{
+		{1, 2, 3},
+		{END, ESC, END},
+		{ESC},
+		// trailer 0xe6e6, stuffed with WithExtraEscapedBytes
+		{0xff, 0xff, 0xff, 0xff, 0xff, 0xe6},
+		[]byte("x"),
+	}
+	for j, opt := range []Option{
+		WithoutLocking(),
+		WithCRC16(CRC16CCITT),
+		WithExtraEscapedBytes(0x11, 0xe6),
+	} {
+		buf := &bytes.Buffer{}
+		w := NewWriter(buf, WithWireChecksum(Fletcher16), opt)
+		for _, p := range packets[:2] {
+			if err := w.WritePacket(p); err != nil {
+				t.Fatal(strconv.Itoa(j), "Unexpected error", err)
+			}
+		}
+		w.WritePacketv(packets[2][:0], packets[2])
+		w.WritePacket(packets[3])
+		w.WriteString("x")
+
+		r := NewReader(buf, WithWireChecksum(Fletcher16), opt)
+		for i, expected := range packets {
+			p, _, err := r.ReadPacket()
+			if err != nil {
+				t.Fatal(strconv.Itoa(j), strconv.Itoa(i), "Unexpected error", err)
+			}
+			if !eqBytes(expected, p) {
+				t.Error(strconv.Itoa(j), strconv.Itoa(i), "Expected data", expected, "but got", p)
+			}
+		}
+		if s := r.Stats(); s.Errors != 0 {
+			t.Error(strconv.Itoa(j), "Expected no errors but got", s.Errors)
+		}
+	}
+}
+
+func TestWireChecksumBuffered(t *testing.T) {
+	for i, opt := range []WriterOption{WithWriteBuffering(64), WithStreamingWrites(64)} {
+		buf := &bytes.Buffer{}
+		w := NewWriter(buf, WithWireChecksum(Fletcher16), opt)
+		w.WritePacket([]byte{END, 1})
+		w.Flush()
+		p, _, err := NewReader(buf, WithWireChecksum(Fletcher16)).ReadPacket()
+		if err != nil || !eqBytes([]byte{END, 1}, p) {
+			t.Error(strconv.Itoa(i), "Expected data", []byte{END, 1}, "but got", p, err)
+		}
+	}
+}
+
+func TestWireChecksumMismatch(t *testing.T) {
+	// The checksum covers the escape codes, so the same payload with
+	// a different escape sequence fails
+	stuffed := []byte{1, ESC, ESC_END}
+	sum := Fletcher16(stuffed)
+	data := append(append([]byte{END}, stuffed...), byte(sum>>8), byte(sum), END)
+	data[3] = ESC_ESC
+	data = append(data, 7, byte(Fletcher16([]byte{7})>>8), byte(Fletcher16([]byte{7})), END)
+
+	r := NewReader(bytes.NewReader(data), WithWireChecksum(Fletcher16))
+	p, _, err := r.ReadPacket()
+	if !errors.Is(err, ErrChecksumMismatch) || len(p) != 0 {
+		t.Fatal("Expected error", ErrChecksumMismatch, "but got", p, err)
+	}
+	var cerr *ChecksumError
+	if !errors.As(err, &cerr) || cerr.Got != sum || cerr.Want != Fletcher16([]byte{1, ESC, ESC_ESC}) {
+		t.Error("Expected ChecksumError with Got", sum, "but got", err)
+	}
+
+	// The next packet is not affected
+	p, _, err = r.ReadPacket()
+	if err != nil || !eqBytes([]byte{7}, p) {
+		t.Error("Expected data", []byte{7}, "but got", p, err)
+	}
+
+	// Too short for a trailer
+	r = NewReader(bytes.NewReader([]byte{END, 1, END}), WithWireChecksum(Fletcher16))
+	if _, _, err = r.ReadPacket(); !errors.Is(err, ErrChecksumMismatch) {
+		t.Error("Expected error", ErrChecksumMismatch, "but got", err)
+	}
+}
+
+func TestWireChecksumStepwise(t *testing.T) {
+	for i, f := range []func(){
+		func() { NewEncoder(WithWireChecksum(Fletcher16)) },
+		func() { NewDecoder(WithWireChecksum(Fletcher16)) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Error(strconv.Itoa(i), "Expected a panic for WithWireChecksum")
+				}
+			}()
+			f()
+		}()
+	}
+}